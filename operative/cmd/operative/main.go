@@ -2,16 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/nstogner/operative/pkg/controller"
+	"github.com/nstogner/operative/pkg/events"
+	"github.com/nstogner/operative/pkg/model"
+	"github.com/nstogner/operative/pkg/model/anthropic"
 	"github.com/nstogner/operative/pkg/model/gemini"
+	"github.com/nstogner/operative/pkg/model/openai"
+	"github.com/nstogner/operative/pkg/sandbox"
 	"github.com/nstogner/operative/pkg/sandbox/docker"
+	"github.com/nstogner/operative/pkg/sandbox/kubernetes"
 	"github.com/nstogner/operative/pkg/server"
 	"github.com/nstogner/operative/pkg/store/sqlite"
 	"github.com/nstogner/operative/web"
+	k8sclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 func main() {
@@ -20,13 +32,6 @@ func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stderr, opts))
 	slog.SetDefault(logger)
 
-	// Config.
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		slog.Error("GEMINI_API_KEY environment variable not set")
-		os.Exit(1)
-	}
-
 	ctx := context.Background()
 
 	// Initialize store.
@@ -41,15 +46,18 @@ func main() {
 	}
 	defer store.Close()
 
-	// Initialize model provider.
-	provider, err := gemini.New(ctx, apiKey)
+	// Initialize model providers.
+	providers, err := newProviders(ctx)
 	if err != nil {
-		slog.Error("Failed to initialize Gemini provider", "error", err)
+		slog.Error("Failed to initialize model providers", "error", err)
 		os.Exit(1)
 	}
+	for _, p := range providers.Providers() {
+		slog.Info("Registered model provider", "provider", p.Name())
+	}
 
 	// Initialize sandbox manager.
-	sbMgr, err := docker.New()
+	sbMgr, err := newSandboxManager()
 	if err != nil {
 		slog.Error("Failed to initialize sandbox manager", "error", err)
 		os.Exit(1)
@@ -64,8 +72,12 @@ func main() {
 		}
 	}()
 
+	// Transient events (e.g. partial model responses) shared by the
+	// controller and the server.
+	bus := events.NewBus()
+
 	// Initialize controller.
-	ctrl := controller.New(store, store, store, provider, sbMgr)
+	ctrl := controller.New(store, store, store, providers, sbMgr, bus)
 
 	// Start controller in background.
 	go func() {
@@ -75,9 +87,95 @@ func main() {
 	}()
 
 	// Start server.
-	srv := server.New(store, store, store, provider, sbMgr, web.DistFS)
+	srv := server.New(store, store, store, providers, sbMgr, ctrl, bus, web.DistFS, allowedImages())
 	if err := srv.Start(":8080"); err != nil {
 		slog.Error("Server failed", "error", err)
 		os.Exit(1)
 	}
 }
+
+// newSandboxManager creates the sandbox backend selected by SANDBOX_BACKEND:
+// "docker" (the default) or "kubernetes". The Kubernetes backend creates
+// pods in SANDBOX_NAMESPACE. It uses the in-cluster config and reaches pods
+// by IP, or, when KUBECONFIG is set, that config and port-forwards.
+func newSandboxManager() (sandbox.Manager, error) {
+	switch backend := os.Getenv("SANDBOX_BACKEND"); backend {
+	case "", "docker":
+		return docker.New()
+	case "kubernetes":
+		cfg := kubernetes.Config{Namespace: os.Getenv("SANDBOX_NAMESPACE")}
+		var restCfg *rest.Config
+		var err error
+		if path := os.Getenv("KUBECONFIG"); path != "" {
+			restCfg, err = clientcmd.BuildConfigFromFlags("", path)
+			cfg.RESTConfig = restCfg
+		} else {
+			restCfg, err = rest.InClusterConfig()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("loading kubernetes config: %w", err)
+		}
+		client, err := k8sclient.NewForConfig(restCfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating kubernetes client: %w", err)
+		}
+		return kubernetes.New(client, cfg)
+	default:
+		return nil, fmt.Errorf("unknown SANDBOX_BACKEND %q: want docker or kubernetes", backend)
+	}
+}
+
+// allowedImages returns the sandbox images listed in SANDBOX_IMAGES
+// (comma-separated) that operatives may use instead of the default one.
+func allowedImages() []string {
+	var images []string
+	for _, image := range strings.Split(os.Getenv("SANDBOX_IMAGES"), ",") {
+		if image = strings.TrimSpace(image); image != "" {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// newProviders registers every model provider configured in the environment:
+// GEMINI_API_KEY, ANTHROPIC_API_KEY, and OPENAI_API_KEY and/or OPENAI_BASE_URL
+// for an OpenAI-compatible endpoint. The first one configured, in that order,
+// is the default for operatives that do not name a provider.
+func newProviders(ctx context.Context) (*model.Registry, error) {
+	var providers []model.Provider
+
+	if apiKey := os.Getenv("GEMINI_API_KEY"); apiKey != "" {
+		p, err := gemini.New(ctx, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+
+	if apiKey := os.Getenv("ANTHROPIC_API_KEY"); apiKey != "" {
+		p, err := anthropic.New(anthropic.Config{APIKey: apiKey})
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if apiKey != "" || baseURL != "" {
+		p, err := openai.New(openai.Config{
+			Name:    os.Getenv("OPENAI_PROVIDER_NAME"),
+			BaseURL: baseURL,
+			APIKey:  apiKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+
+	if len(providers) == 0 {
+		return nil, errors.New("no model provider configured: set GEMINI_API_KEY, ANTHROPIC_API_KEY, or OPENAI_API_KEY and/or OPENAI_BASE_URL")
+	}
+	return model.NewRegistry(providers...)
+}
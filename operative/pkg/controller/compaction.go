@@ -2,8 +2,12 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 
 	"github.com/nstogner/operative/pkg/domain"
 	"github.com/nstogner/operative/pkg/model"
@@ -23,130 +27,520 @@ func (c *Controller) checkAndCompact(ctx context.Context, op *domain.Operative,
 		return nil
 	}
 
-	threshold := op.CompactionThreshold
-	if threshold <= 0 {
-		threshold = DefaultCompactionThreshold
-	}
-
-	// Estimate token usage (rough heuristic: ~4 chars per token).
-	totalChars := 0
-	for _, e := range entries {
-		totalChars += len(e.Content)
-	}
-	estimatedTokens := totalChars / 4
+	threshold := compactionThreshold(op)
 
 	// Look up the model to get max context window.
-	models, err := c.provider.List(ctx)
+	m, ok, err := c.providers.Model(ctx, op.Provider, op.Model)
 	if err != nil {
-		return fmt.Errorf("listing models for compaction check: %w", err)
+		return fmt.Errorf("looking up model for compaction check: %w", err)
 	}
-
-	maxTokens := 0
-	for _, m := range models {
-		if m.ID == op.Model {
-			maxTokens = m.MaxTokens
-			break
-		}
-	}
-	if maxTokens == 0 {
+	if !ok || m.MaxTokens == 0 {
 		// Can't determine context window, skip compaction.
 		return nil
 	}
 
-	if float64(estimatedTokens) < float64(maxTokens)*threshold {
+	promptTokens, err := c.promptTokens(ctx, op, entries)
+	if err != nil {
+		return err
+	}
+
+	if float64(promptTokens) < float64(m.MaxTokens)*threshold {
 		// Under threshold, no compaction needed.
 		return nil
 	}
 
 	slog.Info("Stream compaction triggered",
 		"operativeID", op.ID,
-		"estimatedTokens", estimatedTokens,
-		"maxTokens", maxTokens,
+		"promptTokens", promptTokens,
+		"maxTokens", m.MaxTokens,
 		"threshold", threshold,
 	)
 
 	return c.compact(ctx, op, entries)
 }
 
-// compact performs stream compaction by asking the model to summarize older entries.
-func (c *Controller) compact(ctx context.Context, op *domain.Operative, entries []domain.StreamEntry) error {
-	// Find a safe compaction point: around 50% of entries from the beginning,
-	// but never split in the middle of a tool_call/tool_result pair.
-	splitIdx := len(entries) / 2
-	for splitIdx > 0 {
-		entry := entries[splitIdx]
-		// Don't split right after a tool call (before the result).
-		if entry.ContentType == domain.ContentTypeToolCall {
-			splitIdx--
-			continue
+// compactionThreshold returns the fraction of the context window at which
+// the operative's stream is compacted.
+func compactionThreshold(op *domain.Operative) float64 {
+	if op.CompactionThreshold > 0 {
+		return op.CompactionThreshold
+	}
+	return DefaultCompactionThreshold
+}
+
+// promptTokens returns the size of the prompt of the next model call for
+// entries, including the system instructions and tool declarations. If the
+// stream ends with a model response whose usage was reported, that is the
+// prompt of the response plus the response itself. Otherwise the provider
+// counts the prompt, and if that fails it is estimated.
+func (c *Controller) promptTokens(ctx context.Context, op *domain.Operative, entries []domain.StreamEntry) (int, error) {
+	if u := entries[len(entries)-1].Usage; u != nil && u.InputTokens > 0 {
+		return u.InputTokens + u.OutputTokens, nil
+	}
+
+	provider, modelName, err := c.providers.Resolve(ctx, op.Provider, op.Model)
+	if err != nil {
+		return 0, fmt.Errorf("resolving model for compaction check: %w", err)
+	}
+	instructions := buildInstructions(op)
+	messages := entriesToMessages(entries)
+	n, err := provider.CountTokens(ctx, modelName, instructions, messages, model.DefaultTools)
+	if err != nil {
+		slog.Warn("Counting tokens failed, estimating", "operativeID", op.ID, "error", err)
+		return model.EstimateTokens(instructions, messages, model.DefaultTools), nil
+	}
+	return n, nil
+}
+
+// compactionAttempts is how many times the model is asked for a valid split
+// before compaction falls back to the heuristic split.
+const compactionAttempts = 3
+
+const (
+	// compactionEntryBytes bounds each entry rendered in a compaction prompt.
+	compactionEntryBytes = 4000
+	// defaultCompactionBudget is the token budget of the conversation in a
+	// compaction prompt when the compaction model's context window is
+	// unknown. Otherwise it is half of that window.
+	defaultCompactionBudget = 32000
+)
+
+// compactionTool is the only tool declared to the model when it compacts the
+// stream. It returns the summary and where the kept tail starts.
+var compactionTool = model.Tool{
+	Name:        "compact_stream",
+	Description: "Replace the entries before first_kept_entry_id with a summary. The entry with that ID and every entry after it are kept verbatim.",
+	Parameters: &model.Schema{
+		Type: model.TypeObject,
+		Properties: map[string]*model.Schema{
+			"summary":             {Type: model.TypeString, Description: "The summary of the entries before the first kept entry."},
+			"first_kept_entry_id": {Type: model.TypeString, Description: "The ID of the first entry to keep."},
+		},
+		Required: []string{"summary", "first_kept_entry_id"},
+	},
+}
+
+// compactionInstructions is the system prompt of every compaction request.
+const compactionInstructions = "You are a conversation summarizer."
+
+// summaryGuidelines describes what a compaction summary must preserve.
+const summaryGuidelines = "Create a dense, comprehensive summary that preserves:\n" +
+	"- Key decisions and outcomes\n" +
+	"- Important code/files that were created or modified\n" +
+	"- Current state of any ongoing tasks\n" +
+	"- Any instructions or preferences the user expressed\n\n" +
+	"Be thorough but concise. This summary will replace the original messages.\n" +
+	"Messages marked [pinned] stay verbatim after the summary; do not repeat them.\n\n"
+
+// ErrNothingToCompact is returned by Compact when the stream is too short
+// to compact.
+var ErrNothingToCompact = errors.New("nothing to compact")
+
+// Compact compacts the operative's stream now, regardless of its compaction
+// threshold. With dryRun, the compaction is only planned and returned; its
+// summary entry has no ID. Otherwise it waits for the operative's current
+// step to finish, and no step starts until it is done.
+func (c *Controller) Compact(ctx context.Context, operativeID string, dryRun bool) (*domain.Compaction, error) {
+	if !dryRun {
+		unlock, err := c.locks.lock(ctx, operativeID)
+		if err != nil {
+			return nil, err
 		}
-		// Don't split on a tool result (keep it with its call).
-		if entry.Role == domain.RoleTool {
-			splitIdx--
+		defer unlock()
+	}
+
+	op, err := c.operatives.Get(ctx, operativeID)
+	if err != nil {
+		return nil, fmt.Errorf("loading operative: %w", err)
+	}
+	entries, err := c.stream.GetEntries(ctx, operativeID, 0)
+	if err != nil {
+		return nil, fmt.Errorf("loading stream: %w", err)
+	}
+
+	summary, splitIdx, err := c.planCompaction(ctx, op, entries)
+	if err != nil {
+		return nil, err
+	}
+	if splitIdx < 0 {
+		return nil, ErrNothingToCompact
+	}
+
+	var entry *domain.StreamEntry
+	if dryRun {
+		entry = &domain.StreamEntry{
+			OperativeID:      op.ID,
+			Role:             domain.RoleCompactionSummary,
+			ContentType:      domain.ContentTypeText,
+			Content:          summary,
+			FirstKeptEntryID: entries[splitIdx].ID,
+		}
+	} else if entry, err = c.stream.Compact(ctx, op.ID, summary, entries[splitIdx].ID); err != nil {
+		return nil, err
+	}
+	return newCompaction(*entry, entries[:splitIdx]), nil
+}
+
+// newCompaction describes a compaction summary that replaced the compacted
+// entries of a view. Pinned entries stay in the view, so they do not count.
+func newCompaction(summary domain.StreamEntry, compacted []domain.StreamEntry) *domain.Compaction {
+	compaction := &domain.Compaction{Summary: summary}
+	for _, e := range compacted {
+		if e.Role == domain.RoleCompactionSummary || e.Pinned {
 			continue
 		}
-		break
+		if compaction.FirstEntryID == "" {
+			compaction.FirstEntryID = e.ID
+		}
+		compaction.LastEntryID = e.ID
+		compaction.EntryCount++
 	}
+	return compaction
+}
 
-	if splitIdx <= 1 {
-		// Not enough entries to compact.
-		return nil
+// compact performs stream compaction (see planCompaction).
+func (c *Controller) compact(ctx context.Context, op *domain.Operative, entries []domain.StreamEntry) error {
+	summary, splitIdx, err := c.planCompaction(ctx, op, entries)
+	if err != nil || splitIdx < 0 {
+		return err
 	}
 
-	entriesToCompact := entries[:splitIdx]
+	// Append the compaction summary entry. Old entries remain immutable in the DB
+	// but GetEntries will now return this summary, the pinned entries before
+	// entries[splitIdx], and the entries from entries[splitIdx] on.
+	_, err = c.stream.Compact(ctx, op.ID, summary, entries[splitIdx].ID)
+	return err
+}
 
-	// Use the compaction model (or main model if not specified).
+// planCompaction returns the summary of a compaction of entries and the index
+// of the first kept entry, or -1 if there are not enough entries to compact.
+// The model chooses where to split the stream and summarizes the entries
+// before the split with a compact_stream tool call. It is only shown the
+// oldest entries that fit the compaction budget, since compaction runs when
+// the stream is about to outgrow the context window. Invalid splits are sent
+// back to the model with the reason; if it does not produce a valid one, or
+// the request fails, the stream is split heuristically, later if the kept
+// entries would not fit under the compaction threshold, and the model only
+// summarizes.
+func (c *Controller) planCompaction(ctx context.Context, op *domain.Operative, entries []domain.StreamEntry) (string, int, error) {
+	fallbackIdx := heuristicSplit(entries)
+	if fallbackIdx < 0 {
+		return "", -1, nil
+	}
+
+	// Use the compaction model (or main model if not specified). Both are
+	// served by the operative's provider.
 	compactionModel := op.CompactionModel
 	if compactionModel == "" {
 		compactionModel = op.Model
 	}
+	budget := defaultCompactionBudget
+	if m, ok, err := c.providers.Model(ctx, op.Provider, compactionModel); err == nil && ok && m.MaxTokens > 0 {
+		budget = m.MaxTokens / 2
+	}
+	provider, compactionModel, err := c.providers.Resolve(ctx, op.Provider, compactionModel)
+	if err != nil {
+		return "", 0, fmt.Errorf("resolving compaction model: %w", err)
+	}
 
-	// Build the compaction prompt.
+	shown := fittingEntries(entries, budget)
+	if fallbackIdx > shown {
+		if idx := splitAtOrBefore(entries, shown); idx >= 0 {
+			fallbackIdx = idx
+		}
+	}
+
+	summary, splitIdx, err := chooseSplit(ctx, provider, compactionModel, entries, shown)
+	if err != nil && ctx.Err() == nil {
+		slog.Warn("Model chose no valid compaction split, using heuristic split",
+			"operativeID", op.ID, "error", err)
+		// The kept tail must fit under the compaction threshold, or the
+		// next step compacts again.
+		limit := 0
+		if m, ok, err := c.providers.Model(ctx, op.Provider, op.Model); err == nil && ok && m.MaxTokens > 0 {
+			limit = int(float64(m.MaxTokens) * compactionThreshold(op))
+		}
+		if splitIdx, err = fitSplit(op, entries, fallbackIdx, max(shown, fallbackIdx), limit); err != nil {
+			return "", 0, err
+		}
+		summary, err = summarize(ctx, provider, compactionModel, entries[:splitIdx])
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return summary, splitIdx, nil
+}
+
+// errNoValidSplit is returned by chooseSplit when the model did not choose a
+// valid split within compactionAttempts.
+var errNoValidSplit = errors.New("no valid compaction split")
+
+// chooseSplit asks the model to split entries and summarize the entries
+// before the split. Only the first shown entries are rendered, and the split
+// must be among them. It returns the summary and the index of the first kept
+// entry.
+func chooseSplit(ctx context.Context, provider model.Provider, modelName string, entries []domain.StreamEntry, shown int) (string, int, error) {
 	prompt := "You are summarizing a conversation history for context compaction. " +
-		"Create a dense, comprehensive summary of the following conversation that preserves:\n" +
-		"- Key decisions and outcomes\n" +
-		"- Important code/files that were created or modified\n" +
-		"- Current state of any ongoing tasks\n" +
-		"- Any instructions or preferences the user expressed\n\n" +
-		"Be thorough but concise. This summary will replace the original messages.\n\n" +
-		"CONVERSATION TO SUMMARIZE:\n"
+		"Choose where to split the conversation below: the entries before the split are replaced by your summary, " +
+		"the entries from the split on are kept verbatim. Summarize roughly the older half, " +
+		"and never split inside a user message (before one of its attachments) or between a tool call and its result. " +
+		"Call the compact_stream tool once with the summary and the ID of the first entry to keep.\n\n" +
+		summaryGuidelines +
+		"CONVERSATION (each entry starts with its ID):\n" +
+		renderEntries(entries[:shown], true)
+	if shown < len(entries) {
+		prompt += fmt.Sprintf("(%d more recent entries follow and are kept.)\n", len(entries)-shown)
+	}
 
-	for _, e := range entriesToCompact {
-		prompt += fmt.Sprintf("[%s] %s\n", e.Role, e.Content)
+	messages := []model.Message{
+		{
+			Role:    domain.RoleUser,
+			Content: []model.Content{{Type: domain.ContentTypeText, Text: prompt}},
+		},
+	}
+
+	var reason string
+	for attempt := 1; attempt <= compactionAttempts; attempt++ {
+		msg, err := fullMessage(ctx, provider, modelName, messages, []model.Tool{compactionTool})
+		if err != nil {
+			return "", 0, err
+		}
+		msg.Role = domain.RoleAssistant
+		messages = append(messages, msg)
+
+		var calls []*domain.ToolCall
+		for _, content := range msg.Content {
+			if content.Type == domain.ContentTypeToolCall && content.ToolCall != nil {
+				calls = append(calls, content.ToolCall)
+			}
+		}
+		if len(calls) == 0 {
+			reason = "no compact_stream tool call"
+			messages = append(messages, model.Message{
+				Role:    domain.RoleUser,
+				Content: []model.Content{{Type: domain.ContentTypeText, Text: "Call the compact_stream tool to compact the conversation."}},
+			})
+			continue
+		}
+
+		// Use the first valid call. Otherwise every call is answered with
+		// why it was rejected, so the retry conversation stays well-formed.
+		var results []model.Message
+		for _, call := range calls {
+			var summary string
+			var splitIdx int
+			summary, splitIdx, reason = checkCompactCall(entries, shown, call)
+			if reason == "" {
+				return summary, splitIdx, nil
+			}
+			results = append(results, model.Message{
+				Role: domain.RoleTool,
+				Content: []model.Content{{
+					Type: domain.ContentTypeToolResult,
+					ToolResult: &domain.ToolResult{
+						ToolCallID: call.ID,
+						Content:    "Invalid split: " + reason + ". Call compact_stream again with a valid first_kept_entry_id.",
+						IsError:    true,
+					},
+				}},
+			})
+		}
+		slog.Debug("Invalid compaction split", "attempt", attempt, "reason", reason)
+		messages = append(messages, results...)
+	}
+	return "", 0, fmt.Errorf("%w after %d attempts: %s", errNoValidSplit, compactionAttempts, reason)
+}
+
+// checkCompactCall checks a tool call in response to a compaction prompt that
+// showed the first shown entries. It returns the summary and the index of the
+// first kept entry, or why the call is invalid.
+func checkCompactCall(entries []domain.StreamEntry, shown int, call *domain.ToolCall) (string, int, string) {
+	if call.Name != compactionTool.Name {
+		return "", 0, fmt.Sprintf("unknown tool %q", call.Name)
+	}
+	summary, _ := call.Input["summary"].(string)
+	firstKeptID, _ := call.Input["first_kept_entry_id"].(string)
+	splitIdx := slices.IndexFunc(entries[:shown], func(e domain.StreamEntry) bool { return e.ID == firstKeptID })
+	if splitIdx < 0 {
+		return "", 0, fmt.Sprintf("entry %q is not in the conversation", firstKeptID)
+	}
+	if reason := validateSplit(entries, splitIdx); reason != "" {
+		return "", 0, reason
+	}
+	if summary == "" {
+		return "", 0, "the summary is empty"
+	}
+	return summary, splitIdx, ""
+}
+
+// validateSplit checks that the stream can be split before entries[idx]. It
+// returns why not, or "" if it can. At least two entries must be compacted,
+// the kept tail must not start with the attachment of a message or with a
+// pinned entry (which may precede the summary's own tail; it is kept anyway),
+// and every tool call before the split must have its result before the split.
+func validateSplit(entries []domain.StreamEntry, idx int) string {
+	if idx < 2 || idx >= len(entries) {
+		return "at least two entries must be summarized and one kept"
+	}
+	first := entries[idx]
+	if first.ContentType == domain.ContentTypeAttachment {
+		return fmt.Sprintf("entry %q is an attachment of the message before it", first.ID)
+	}
+	if first.Pinned {
+		return fmt.Sprintf("entry %q is pinned and kept regardless; keep from a later entry", first.ID)
+	}
+
+	unanswered := make(map[string]bool)
+	var order []string
+	for _, e := range entries[:idx] {
+		switch e.ContentType {
+		case domain.ContentTypeToolCall:
+			var tc domain.ToolCall
+			if err := json.Unmarshal([]byte(e.Content), &tc); err == nil {
+				unanswered[tc.ID] = true
+				order = append(order, tc.ID)
+			}
+		case domain.ContentTypeToolResult:
+			var tr domain.ToolResult
+			if err := json.Unmarshal([]byte(e.Content), &tr); err == nil {
+				delete(unanswered, tr.ToolCallID)
+			}
+		}
+	}
+	for _, id := range order {
+		if unanswered[id] {
+			return fmt.Sprintf("entry %q separates tool call %q from its result", first.ID, id)
+		}
+	}
+	return ""
+}
+
+// heuristicSplit returns the index of the first kept entry of a split that
+// compacts about half of entries, or -1 if there is no valid split there.
+func heuristicSplit(entries []domain.StreamEntry) int {
+	return splitAtOrBefore(entries, len(entries)/2)
+}
+
+// splitAtOrBefore returns the index of the first kept entry of the latest
+// valid split at or before idx, or -1 if there is none.
+func splitAtOrBefore(entries []domain.StreamEntry, idx int) int {
+	for ; idx >= 2; idx-- {
+		if validateSplit(entries, idx) == "" {
+			return idx
+		}
+	}
+	return -1
+}
+
+// fitSplit returns the index of the first kept entry of the earliest valid
+// split from idx to last whose kept entries (see keptTokens) fit in limit
+// tokens. A limit of zero means no limit.
+func fitSplit(op *domain.Operative, entries []domain.StreamEntry, idx, last, limit int) (int, error) {
+	if limit <= 0 {
+		return idx, nil
+	}
+	for ; idx <= last && idx < len(entries); idx++ {
+		if validateSplit(entries, idx) == "" && keptTokens(op, entries, idx) <= limit {
+			return idx, nil
+		}
+	}
+	return 0, fmt.Errorf("no compaction split keeps the stream under %d tokens", limit)
+}
+
+// keptTokens estimates the prompt of the next model call after compacting
+// the entries before entries[idx]: the kept tail, the pinned entries before
+// it, the system instructions and tool declarations (but not the summary).
+func keptTokens(op *domain.Operative, entries []domain.StreamEntry, idx int) int {
+	var kept []domain.StreamEntry
+	for _, e := range entries[:idx] {
+		if e.Pinned {
+			kept = append(kept, e)
+		}
 	}
+	kept = append(kept, entries[idx:]...)
+	return model.EstimateTokens(buildInstructions(op), entriesToMessages(kept), model.DefaultTools)
+}
+
+// fittingEntries returns how many of the oldest entries fit, rendered with
+// IDs, in a compaction prompt of budget tokens.
+func fittingEntries(entries []domain.StreamEntry, budget int) int {
+	tokens := 0
+	for i, e := range entries {
+		tokens += model.EstimateTokens(renderEntry(e, true), nil, nil)
+		if tokens > budget {
+			return i
+		}
+	}
+	return len(entries)
+}
+
+// summarize asks the model for a free-text summary of entries.
+func summarize(ctx context.Context, provider model.Provider, modelName string, entries []domain.StreamEntry) (string, error) {
+	prompt := "You are summarizing a conversation history for context compaction. " +
+		summaryGuidelines +
+		"CONVERSATION TO SUMMARIZE:\n" +
+		renderEntries(entries, false)
 
-	// Call the model to generate the summary.
 	messages := []model.Message{
 		{
 			Role:    domain.RoleUser,
 			Content: []model.Content{{Type: domain.ContentTypeText, Text: prompt}},
 		},
 	}
+	msg, err := fullMessage(ctx, provider, modelName, messages, nil)
+	if err != nil {
+		return "", err
+	}
 
-	stream, err := c.provider.Stream(ctx, compactionModel, "You are a conversation summarizer.", messages)
+	for _, content := range msg.Content {
+		if content.Type == domain.ContentTypeText && content.Text != "" {
+			return content.Text, nil
+		}
+	}
+	return "", fmt.Errorf("model returned empty compaction summary")
+}
+
+// fullMessage calls the compaction model and waits for its whole response.
+func fullMessage(ctx context.Context, provider model.Provider, modelName string, messages []model.Message, tools []model.Tool) (model.Message, error) {
+	stream, err := provider.Stream(ctx, modelName, compactionInstructions, messages, tools)
 	if err != nil {
-		return fmt.Errorf("calling model for compaction: %w", err)
+		return model.Message{}, fmt.Errorf("calling model for compaction: %w", err)
 	}
 	defer stream.Close()
 
 	msg, err := stream.FullMessage()
 	if err != nil {
-		return fmt.Errorf("getting compaction summary: %w", err)
+		return model.Message{}, fmt.Errorf("getting compaction response: %w", err)
 	}
+	return msg, nil
+}
 
-	summary := ""
-	for _, content := range msg.Content {
-		if content.Type == domain.ContentTypeText {
-			summary = content.Text
-			break
-		}
+// renderEntries renders entries as the conversation in a compaction prompt,
+// one line per entry, optionally prefixed with the entry ID.
+func renderEntries(entries []domain.StreamEntry, withIDs bool) string {
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(renderEntry(e, withIDs))
 	}
+	return b.String()
+}
 
-	if summary == "" {
-		return fmt.Errorf("model returned empty compaction summary")
+// renderEntry renders one line of renderEntries. Long contents are
+// truncated to compactionEntryBytes.
+func renderEntry(e domain.StreamEntry, withIDs bool) string {
+	var b strings.Builder
+	if withIDs {
+		fmt.Fprintf(&b, "[%s] ", e.ID)
 	}
-
-	// Append the compaction summary entry. Old entries remain immutable in the DB
-	// but GetEntries will now return entries starting from this compaction entry.
-	return c.stream.Compact(ctx, op.ID, summary)
+	if e.ContentType == domain.ContentTypeAttachment {
+		var a domain.Attachment
+		json.Unmarshal([]byte(e.Content), &a)
+		fmt.Fprintf(&b, "[%s] (%s attachment)\n", e.Role, a.MIMEType)
+		return b.String()
+	}
+	if e.Pinned {
+		b.WriteString("[pinned] ")
+	}
+	fmt.Fprintf(&b, "[%s] %s\n", e.Role, truncateOutput(e.Content, compactionEntryBytes))
+	return b.String()
 }
@@ -0,0 +1,391 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/model"
+	"github.com/nstogner/operative/pkg/store/sqlite"
+)
+
+// withIDs assigns the IDs e0, e1, ... to entries.
+func withIDs(entries ...domain.StreamEntry) []domain.StreamEntry {
+	for i := range entries {
+		entries[i].ID = fmt.Sprintf("e%d", i)
+	}
+	return entries
+}
+
+// compactionEntries is a stream with a tool call/result pair at e3/e4 and a
+// user message with an attachment at e5/e6.
+func compactionEntries() []domain.StreamEntry {
+	return withIDs(
+		textEntry(domain.RoleUser, "start"),
+		textEntry(domain.RoleAssistant, "ok"),
+		textEntry(domain.RoleUser, "calc"),
+		callEntry("c1", "run_ipython_cell"),
+		resultEntry("c1"),
+		textEntry(domain.RoleUser, "look at this"),
+		domain.StreamEntry{Role: domain.RoleUser, ContentType: domain.ContentTypeAttachment, Content: `{"mime_type":"image/png"}`},
+		textEntry(domain.RoleAssistant, "nice"),
+	)
+}
+
+func TestValidateSplit(t *testing.T) {
+	entries := compactionEntries()
+	tests := []struct {
+		idx     int
+		wantErr string
+	}{
+		{idx: 0, wantErr: "at least two entries"},
+		{idx: 1, wantErr: "at least two entries"},
+		{idx: 2},
+		{idx: 3},
+		{idx: 4, wantErr: `separates tool call "c1"`},
+		{idx: 5},
+		{idx: 6, wantErr: "attachment"},
+		{idx: 7},
+		{idx: 8, wantErr: "at least two entries"},
+	}
+	for _, tt := range tests {
+		got := validateSplit(entries, tt.idx)
+		if tt.wantErr == "" && got != "" {
+			t.Errorf("validateSplit(%d) = %q, want valid", tt.idx, got)
+		}
+		if tt.wantErr != "" && !strings.Contains(got, tt.wantErr) {
+			t.Errorf("validateSplit(%d) = %q, want %q", tt.idx, got, tt.wantErr)
+		}
+	}
+
+	// A call that is still pending cannot be compacted.
+	pending := withIDs(
+		textEntry(domain.RoleUser, "a"),
+		textEntry(domain.RoleAssistant, "b"),
+		callEntry("c1", "run_ipython_cell"),
+		textEntry(domain.RoleSystem, "progress"),
+	)
+	if got := validateSplit(pending, 3); got == "" {
+		t.Error("validateSplit accepted a split after a pending call")
+	}
+
+	// Pinned entries are kept anyway, so the tail cannot start with one.
+	entries[5].Pinned = true
+	if got := validateSplit(entries, 5); !strings.Contains(got, "pinned") {
+		t.Errorf("validateSplit(5) with e5 pinned = %q, want pinned", got)
+	}
+	if got := renderEntries(entries[5:6], true); got != "[e5] [pinned] [user] look at this\n" {
+		t.Errorf("renderEntries = %q", got)
+	}
+}
+
+func TestHeuristicSplit(t *testing.T) {
+	// len/2 = 4 splits the call from its result, so the split moves back.
+	if got := heuristicSplit(compactionEntries()); got != 3 {
+		t.Errorf("heuristicSplit = %d, want 3", got)
+	}
+
+	short := withIDs(
+		textEntry(domain.RoleUser, "a"),
+		callEntry("c1", "run_ipython_cell"),
+		resultEntry("c1"),
+	)
+	if got := heuristicSplit(short); got != -1 {
+		t.Errorf("heuristicSplit(short) = %d, want -1", got)
+	}
+}
+
+// scriptedProvider answers each Stream call with the next of its responses
+// and records the requests. The first failures calls fail instead.
+// CountTokens returns count, or countErr.
+type scriptedProvider struct {
+	maxTokens int // of the listed model "m"
+
+	responses []model.Message
+	requests  [][]model.Message
+	tools     [][]model.Tool
+	failures  int
+
+	count    int
+	countErr error
+}
+
+func (p *scriptedProvider) Name() string { return "scripted" }
+func (p *scriptedProvider) List(ctx context.Context) ([]domain.Model, error) {
+	return []domain.Model{{ID: "m", Provider: "scripted", MaxTokens: p.maxTokens}}, nil
+}
+func (p *scriptedProvider) Stream(ctx context.Context, modelName, instructions string, messages []model.Message, tools []model.Tool) (model.ModelStream, error) {
+	if p.failures > 0 {
+		p.failures--
+		return nil, errors.New("prompt is too long")
+	}
+	if len(p.requests) >= len(p.responses) {
+		return nil, errors.New("no more responses")
+	}
+	resp := p.responses[len(p.requests)]
+	p.requests = append(p.requests, append([]model.Message(nil), messages...))
+	p.tools = append(p.tools, tools)
+	return messageStream{resp}, nil
+}
+
+func (p *scriptedProvider) CountTokens(ctx context.Context, modelName, instructions string, messages []model.Message, tools []model.Tool) (int, error) {
+	return p.count, p.countErr
+}
+
+// messageStream is a model.ModelStream of a complete message.
+type messageStream struct{ msg model.Message }
+
+func (s messageStream) Next() (model.Delta, error) {
+	return model.Delta{}, errors.New("not implemented")
+}
+func (s messageStream) FullMessage() (model.Message, error) { return s.msg, nil }
+func (s messageStream) Usage() domain.Usage                 { return domain.Usage{} }
+func (s messageStream) Close() error                        { return nil }
+
+func compactCall(id, summary, firstKeptID string) model.Message {
+	return model.Message{Role: domain.RoleAssistant, Content: []model.Content{{
+		Type: domain.ContentTypeToolCall,
+		ToolCall: &domain.ToolCall{ID: id, Name: compactionTool.Name, Input: map[string]any{
+			"summary":             summary,
+			"first_kept_entry_id": firstKeptID,
+		}},
+	}}}
+}
+
+func TestChooseSplit(t *testing.T) {
+	p := &scriptedProvider{responses: []model.Message{
+		compactCall("t1", "summary", "e4"),
+		{Role: domain.RoleAssistant, Content: []model.Content{{Type: domain.ContentTypeText, Text: "Here is a summary."}}},
+		compactCall("t3", "summary", "e5"),
+	}}
+	summary, idx, err := chooseSplit(context.Background(), p, "m", compactionEntries(), 8)
+	if err != nil {
+		t.Fatalf("chooseSplit: %v", err)
+	}
+	if summary != "summary" || idx != 5 {
+		t.Errorf("chooseSplit = %q, %d; want summary, 5", summary, idx)
+	}
+
+	if len(p.tools[0]) != 1 || p.tools[0][0].Name != compactionTool.Name {
+		t.Errorf("tools = %+v, want only %s", p.tools[0], compactionTool.Name)
+	}
+	if !strings.Contains(p.requests[0][0].Content[0].Text, "[e4] [tool]") {
+		t.Errorf("prompt does not list entry IDs: %q", p.requests[0][0].Content[0].Text)
+	}
+
+	// The invalid split is answered with an error result for the call.
+	retry := p.requests[1]
+	if len(retry) != 3 {
+		t.Fatalf("second request has %d messages, want 3", len(retry))
+	}
+	res := retry[2].Content[0].ToolResult
+	if res == nil || res.ToolCallID != "t1" || !res.IsError || !strings.Contains(res.Content, "separates tool call") {
+		t.Errorf("feedback = %+v", res)
+	}
+
+	// A response without a tool call is answered with a reminder.
+	if last := p.requests[2][4]; last.Role != domain.RoleUser || !strings.Contains(last.Content[0].Text, "compact_stream") {
+		t.Errorf("reminder = %+v", last)
+	}
+}
+
+func TestChooseSplitGivesUp(t *testing.T) {
+	p := &scriptedProvider{responses: []model.Message{
+		compactCall("t1", "summary", "missing"),
+		compactCall("t2", "summary", "e6"),
+		compactCall("t3", "", "e5"),
+	}}
+	_, _, err := chooseSplit(context.Background(), p, "m", compactionEntries(), 8)
+	if !errors.Is(err, errNoValidSplit) {
+		t.Fatalf("chooseSplit error = %v, want errNoValidSplit", err)
+	}
+	if !strings.Contains(err.Error(), "summary is empty") {
+		t.Errorf("error = %v, want the last reason", err)
+	}
+}
+
+func TestChooseSplitAnswersEveryCall(t *testing.T) {
+	two := compactCall("t1", "summary", "e5")
+	two.Content = append(two.Content, compactCall("t2", "summary", "e6").Content...)
+	p := &scriptedProvider{responses: []model.Message{
+		two,
+		compactCall("t3", "summary", "e3"),
+	}}
+	// Only e0..e4 are shown, so e5 cannot be chosen.
+	summary, idx, err := chooseSplit(context.Background(), p, "m", compactionEntries(), 5)
+	if err != nil || summary != "summary" || idx != 3 {
+		t.Fatalf("chooseSplit = %q, %d, %v; want summary, 3", summary, idx, err)
+	}
+	prompt := p.requests[0][0].Content[0].Text
+	if strings.Contains(prompt, "[e5]") || !strings.Contains(prompt, "3 more recent entries") {
+		t.Errorf("prompt does not stop at the shown entries: %q", prompt)
+	}
+
+	retry := p.requests[1]
+	if len(retry) != 4 {
+		t.Fatalf("second request has %d messages, want 4", len(retry))
+	}
+	for i, want := range []string{"t1", "t2"} {
+		res := retry[2+i].Content[0].ToolResult
+		if res == nil || res.ToolCallID != want || !res.IsError {
+			t.Errorf("result %d = %+v, want an error for %s", i, res, want)
+		}
+	}
+}
+
+func TestFittingEntries(t *testing.T) {
+	entries := compactionEntries()
+	if got := fittingEntries(entries, 1000); got != len(entries) {
+		t.Errorf("fittingEntries = %d, want all %d", got, len(entries))
+	}
+	if got := fittingEntries(entries, 20); got == 0 || got >= len(entries) {
+		t.Errorf("fittingEntries with a small budget = %d", got)
+	}
+
+	// Long entries are truncated, so one still fits.
+	long := withIDs(textEntry(domain.RoleUser, strings.Repeat("x", 100000)))
+	if got := fittingEntries(long, 2000); got != 1 {
+		t.Errorf("fittingEntries of a long entry = %d, want 1", got)
+	}
+}
+
+func TestPlanCompactionFallsBack(t *testing.T) {
+	// The split request fails, e.g. because the prompt is too long.
+	p := &scriptedProvider{failures: 1, responses: []model.Message{
+		{Role: domain.RoleAssistant, Content: []model.Content{{Type: domain.ContentTypeText, Text: "fallback summary"}}},
+	}}
+	reg, _ := model.NewRegistry(p)
+	c := &Controller{providers: reg}
+	op := &domain.Operative{ID: "op-1", Model: "m"}
+
+	summary, idx, err := c.planCompaction(context.Background(), op, compactionEntries())
+	if err != nil {
+		t.Fatalf("planCompaction: %v", err)
+	}
+	if summary != "fallback summary" || idx != heuristicSplit(compactionEntries()) {
+		t.Errorf("planCompaction = %q, %d", summary, idx)
+	}
+}
+
+func TestPlanCompactionFallbackFitsThreshold(t *testing.T) {
+	entries := compactionEntries()
+	op := &domain.Operative{ID: "op-1", Model: "m", CompactionThreshold: 1}
+	plan := func(maxTokens int) (int, error) {
+		p := &scriptedProvider{maxTokens: maxTokens, failures: 1, responses: []model.Message{
+			{Role: domain.RoleAssistant, Content: []model.Content{{Type: domain.ContentTypeText, Text: "fallback summary"}}},
+		}}
+		reg, _ := model.NewRegistry(p)
+		c := &Controller{providers: reg}
+		_, idx, err := c.planCompaction(context.Background(), op, entries)
+		return idx, err
+	}
+
+	// The heuristic split keeps e3 on, which does not fit; e5 on does.
+	if keptTokens(op, entries, 3) <= keptTokens(op, entries, 5) {
+		t.Fatal("test entries do not shrink the kept tail")
+	}
+	if idx, err := plan(keptTokens(op, entries, 5)); err != nil || idx != 5 {
+		t.Errorf("planCompaction = %d, %v; want 5", idx, err)
+	}
+
+	// Without a split that fits, compaction fails.
+	if _, err := plan(keptTokens(op, entries, 7) - 1); err == nil {
+		t.Error("planCompaction succeeded without a fitting split")
+	}
+}
+
+func TestPromptTokens(t *testing.T) {
+	p := &scriptedProvider{count: 5000}
+	reg, err := model.NewRegistry(p)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	c := &Controller{providers: reg}
+	op := &domain.Operative{ID: "op-1", Model: "m"}
+	ctx := context.Background()
+
+	// A trailing response with usage: its prompt plus the response.
+	entries := compactionEntries()
+	entries[len(entries)-1].Usage = &domain.Usage{InputTokens: 900, OutputTokens: 100}
+	if got, _ := c.promptTokens(ctx, op, entries); got != 1000 {
+		t.Errorf("promptTokens with usage = %d, want 1000", got)
+	}
+
+	// Otherwise the provider counts.
+	entries = compactionEntries()
+	if got, _ := c.promptTokens(ctx, op, entries); got != 5000 {
+		t.Errorf("promptTokens = %d, want the provider's count", got)
+	}
+
+	// And if that fails, the prompt is estimated.
+	p.countErr = errors.New("unavailable")
+	want := model.EstimateTokens(buildInstructions(op), entriesToMessages(entries), model.DefaultTools)
+	if got, _ := c.promptTokens(ctx, op, entries); got != want || got == 0 {
+		t.Errorf("promptTokens = %d, want estimate %d", got, want)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	st, err := sqlite.New(t.TempDir() + "/test.db")
+	if err != nil {
+		t.Fatalf("sqlite.New: %v", err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	st.Create(ctx, &domain.Operative{ID: "op-1", Name: "test", Model: "m"})
+	for _, e := range compactionEntries() {
+		e.OperativeID = "op-1"
+		if err := st.Append(ctx, &e); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	p := &scriptedProvider{responses: []model.Message{
+		compactCall("t1", "preview", "e5"),
+		compactCall("t2", "summary", "e5"),
+	}}
+	reg, _ := model.NewRegistry(p)
+	c := &Controller{operatives: st, stream: st, providers: reg}
+
+	// A dry run plans the compaction without applying it.
+	preview, err := c.Compact(ctx, "op-1", true)
+	if err != nil {
+		t.Fatalf("Compact dry run: %v", err)
+	}
+	if preview.Summary.ID != "" || preview.Summary.Content != "preview" || preview.Summary.FirstKeptEntryID != "e5" {
+		t.Errorf("preview summary = %+v", preview.Summary)
+	}
+	if preview.FirstEntryID != "e0" || preview.LastEntryID != "e4" || preview.EntryCount != 5 {
+		t.Errorf("preview = %+v", preview)
+	}
+	if compactions, _ := st.ListCompactions(ctx, "op-1"); len(compactions) != 0 {
+		t.Fatalf("dry run compacted the stream: %+v", compactions)
+	}
+
+	// Compaction waits for the operative's step.
+	unlock, _ := c.locks.lock(ctx, "op-1")
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Compact(waitCtx, "op-1", false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Compact during a step: %v, want DeadlineExceeded", err)
+	}
+	unlock()
+
+	got, err := c.Compact(ctx, "op-1", false)
+	if err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	compactions, _ := st.ListCompactions(ctx, "op-1")
+	if len(compactions) != 1 || compactions[0].Summary.ID != got.Summary.ID || compactions[0].EntryCount != got.EntryCount {
+		t.Errorf("ListCompactions = %+v, want %+v", compactions, got)
+	}
+
+	// The compacted view is now too short to compact again.
+	if _, err := c.Compact(ctx, "op-1", true); !errors.Is(err, ErrNothingToCompact) {
+		t.Errorf("Compact of a short stream: %v, want ErrNothingToCompact", err)
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/events"
 	"github.com/nstogner/operative/pkg/model"
 	"github.com/nstogner/operative/pkg/sandbox"
 	"github.com/nstogner/operative/pkg/store"
@@ -21,8 +25,12 @@ type Controller struct {
 	operatives store.OperativeStore
 	stream     store.StreamStore
 	notes      store.NoteStore
-	provider   model.Provider
+	providers  *model.Registry
 	sandbox    sandbox.Manager
+	bus        *events.Bus
+
+	// locks serializes the steps of an operative with manual compactions.
+	locks operativeLocks
 }
 
 // New creates a new Controller.
@@ -30,15 +38,17 @@ func New(
 	operatives store.OperativeStore,
 	stream store.StreamStore,
 	notes store.NoteStore,
-	provider model.Provider,
+	providers *model.Registry,
 	sandbox sandbox.Manager,
+	bus *events.Bus,
 ) *Controller {
 	return &Controller{
 		operatives: operatives,
 		stream:     stream,
 		notes:      notes,
-		provider:   provider,
+		providers:  providers,
 		sandbox:    sandbox,
+		bus:        bus,
 	}
 }
 
@@ -50,6 +60,8 @@ const staticInstructions = `You are an operative — an autonomous agent with ac
 
 You have access to a persistent IPython kernel running in a sandboxed container. You can execute arbitrary Python code using the run_ipython_cell tool. State persists across cells within a single session.
 
+Your working directory is /workspace. Files you keep there persist when the sandbox is restarted; files elsewhere in the container, as well as variables, imports and running processes, do not. Save anything you want to keep (datasets, scripts, results) under /workspace.
+
 The following functions are injected into the IPython namespace and can be called directly from any cell:
 
 - prompt_model(prompt: str) -> str
@@ -80,11 +92,11 @@ The following functions are injected into the IPython namespace and can be calle
 - Update your self-set instructions when you learn important operational preferences.`
 
 // buildInstructions concatenates the three instruction sources:
-// 1. Static environment/tools description
+// 1. Static environment/tools description, plus the sandbox's limits
 // 2. Admin-set instructions
 // 3. Operative self-set instructions
 func buildInstructions(op *domain.Operative) string {
-	parts := []string{staticInstructions}
+	parts := []string{staticInstructions, sandboxInstructions(op.Sandbox.WithDefaults())}
 	if op.AdminInstructions != "" {
 		parts = append(parts, "## Admin Instructions\n\n"+op.AdminInstructions)
 	}
@@ -94,24 +106,213 @@ func buildInstructions(op *domain.Operative) string {
 	return strings.Join(parts, "\n\n")
 }
 
-// Start listens for stream events and triggers the control loop.
+// sandboxInstructions describes the resource limits and network access of
+// the operative's sandbox.
+func sandboxInstructions(cfg domain.SandboxConfig) string {
+	lines := []string{
+		"## Sandbox Limits",
+		"",
+		fmt.Sprintf("Your sandbox has %d MB of memory, %g CPUs and at most %d processes/threads. "+
+			"Exceeding the memory limit kills the sandbox and loses all IPython state.", cfg.MemoryMB, cfg.CPUs, cfg.PidsLimit),
+	}
+	if cfg.ReadOnlyRootFS {
+		lines = append(lines, "The root filesystem is read-only: write files to /workspace (persistent) or /tmp (scratch).")
+	}
+	switch cfg.Network {
+	case domain.NetworkNone:
+		lines = append(lines, "The sandbox has no network access.")
+	case domain.NetworkAllowlist:
+		lines = append(lines, "Network access is limited to HTTP(S) requests to these hosts, through the proxy set in "+
+			"the HTTP_PROXY/HTTPS_PROXY environment variables: "+strings.Join(cfg.AllowedHosts, ", ")+".")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ReconcileInterval is how often the controller looks for operatives with
+// stream entries it has not handled yet.
+const ReconcileInterval = 30 * time.Second
+
+// Start listens for stream events and triggers the control loop. Each
+// operative is stepped by its own worker (see scheduler), so a slow model call
+// or cell only delays the operative it belongs to. Start waits for running
+// steps to return before returning.
+//
+// Stream events are only a fast path: on startup, operatives waiting for a
+// model response are resumed, and the stream is periodically checked for
+// entries that no step has handled (see StreamStore.MarkHandled).
 func (c *Controller) Start(ctx context.Context) error {
-	events := c.stream.Subscribe()
+	events, unsubscribe := c.stream.Subscribe()
+	defer unsubscribe()
+	sched := newScheduler(MaxConcurrentSteps, func(ctx context.Context, operativeID string) {
+		if err := c.step(ctx, operativeID); err != nil {
+			slog.Error("Controller step error", "operativeID", operativeID, "error", err)
+		}
+	})
+	defer sched.wait()
+
+	c.resume(ctx, sched)
+	c.reconcile(ctx, sched)
+
+	ticker := time.NewTicker(ReconcileInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case operativeID := <-events:
-			if err := c.step(ctx, operativeID); err != nil {
-				slog.Error("Controller step error", "operativeID", operativeID, "error", err)
+			sched.wake(ctx, operativeID)
+		case e := <-c.sandbox.Events():
+			c.recordSandboxRestart(ctx, e)
+		case <-ticker.C:
+			c.reconcile(ctx, sched)
+		}
+	}
+}
+
+// recordSandboxRestart tells the operative (and its user) that its sandbox
+// state was lost. The model sees the notice on its next turn.
+func (c *Controller) recordSandboxRestart(ctx context.Context, e sandbox.Event) {
+	err := c.stream.Append(ctx, &domain.StreamEntry{
+		ID:          uuid.New().String(),
+		OperativeID: e.OperativeID,
+		Role:        domain.RoleSystem,
+		ContentType: domain.ContentTypeText,
+		Content: fmt.Sprintf("The IPython sandbox was restarted (%s). All IPython state was lost: "+
+			"variables, imports, running processes and background threads. Files in /workspace were kept.", e.Reason),
+	})
+	if err != nil {
+		slog.Error("Recording sandbox restart failed", "operativeID", e.OperativeID, "error", err)
+	}
+}
+
+// resume re-drives turns that were in flight when the process stopped. It
+// wakes every operative whose stream ends with a user message or a tool
+// result, i.e. that was waiting for the model (including steps that failed,
+// e.g. because the model was unavailable). Tool calls left without a result
+// are resolved first: side-effect-free calls are run again by the next step,
+// the rest are answered with an error since their outcome is unknown.
+func (c *Controller) resume(ctx context.Context, sched *scheduler) {
+	ops, err := c.operatives.List(ctx)
+	if err != nil {
+		slog.Error("Listing operatives to resume failed", "error", err)
+		return
+	}
+	for _, op := range ops {
+		entries, err := c.stream.GetEntries(ctx, op.ID, 0)
+		if err != nil {
+			slog.Error("Loading stream to resume failed", "operativeID", op.ID, "error", err)
+			continue
+		}
+		if calls := pendingToolCalls(entries); len(calls) > 0 {
+			if err := c.abandonToolCalls(ctx, &op, calls); err != nil {
+				slog.Error("Recovering interrupted tool calls failed", "operativeID", op.ID, "error", err)
+				continue
 			}
+			slog.Info("Resuming operative with interrupted tool calls", "operativeID", op.ID, "calls", len(calls))
+			sched.wake(ctx, op.ID)
+			continue
+		}
+		if awaitingModel(entries) {
+			slog.Info("Resuming operative", "operativeID", op.ID)
+			sched.wake(ctx, op.ID)
+		}
+	}
+}
+
+// rerunnableTools have no side effects, so calls to them that were cut short
+// by a restart are simply executed again.
+var rerunnableTools = map[string]bool{
+	"get_note":             true,
+	"keyword_search_notes": true,
+	"vector_search_notes":  true,
+}
+
+// interruptedResults returns error results for the calls that cannot be
+// safely rerun after a restart.
+func interruptedResults(calls []domain.ToolCall) []*domain.ToolResult {
+	var results []*domain.ToolResult
+	for _, tc := range calls {
+		if rerunnableTools[tc.Name] {
+			continue
+		}
+		results = append(results, &domain.ToolResult{
+			ToolCallID: tc.ID,
+			Content: "Error: this tool call was interrupted by a restart of the operative system before it returned. " +
+				"Its outcome is unknown: it may or may not have taken effect (a cell may even still be running in the sandbox). " +
+				"Check the current state before retrying.",
+			IsError: true,
+		})
+	}
+	return results
+}
+
+// abandonToolCalls answers interrupted calls with error results and records
+// a system entry explaining what happened.
+func (c *Controller) abandonToolCalls(ctx context.Context, op *domain.Operative, calls []domain.ToolCall) error {
+	results := interruptedResults(calls)
+	if len(results) == 0 {
+		return nil
+	}
+	for _, result := range results {
+		if err := c.appendToolResult(ctx, op, result); err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for _, tc := range calls {
+		if !rerunnableTools[tc.Name] {
+			names = append(names, tc.Name)
+		}
+	}
+	return c.stream.Append(ctx, &domain.StreamEntry{
+		ID:          uuid.New().String(),
+		OperativeID: op.ID,
+		Role:        domain.RoleSystem,
+		ContentType: domain.ContentTypeText,
+		Content: fmt.Sprintf("The operative system restarted while running %s. The outcome of the interrupted call(s) is unknown.",
+			strings.Join(names, ", ")),
+	})
+}
+
+// reconcile wakes operatives with entries appended after their last step,
+// which covers stream events that were never acted on.
+func (c *Controller) reconcile(ctx context.Context, sched *scheduler) {
+	ids, err := c.stream.ListUnhandled(ctx)
+	if err != nil {
+		slog.Error("Listing unhandled operatives failed", "error", err)
+		return
+	}
+	for _, id := range ids {
+		sched.wake(ctx, id)
+	}
+}
+
+// awaitingModel reports whether the last entry (ignoring system notices) is
+// a user message or a tool result.
+func awaitingModel(entries []domain.StreamEntry) bool {
+	for i := len(entries) - 1; i >= 0; i-- {
+		switch entries[i].Role {
+		case domain.RoleSystem:
+			continue
+		case domain.RoleUser, domain.RoleTool:
+			return true
+		default:
+			return false
 		}
 	}
+	return false
 }
 
 // step executes one step of the control loop for the given operative.
 func (c *Controller) step(ctx context.Context, operativeID string) error {
+	unlock, err := c.locks.lock(ctx, operativeID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Load the operative configuration.
 	op, err := c.operatives.Get(ctx, operativeID)
 	if err != nil {
@@ -128,8 +329,32 @@ func (c *Controller) step(ctx context.Context, operativeID string) error {
 		return nil
 	}
 
-	// Determine what to do based on the last entry.
-	last := entries[len(entries)-1]
+	// Whatever the outcome, this step has acted on the stream up to its
+	// current last entry. Steps cut short by shutdown are redone on restart.
+	lastID := entries[len(entries)-1].ID
+	defer func() {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := c.stream.MarkHandled(ctx, operativeID, lastID); err != nil {
+			slog.Error("Marking stream handled failed", "operativeID", operativeID, "error", err)
+		}
+	}()
+
+	// Execute the tool calls of the latest model turn before anything else;
+	// the model must see a result for every call it made.
+	if calls := pendingToolCalls(entries); len(calls) > 0 {
+		return c.executeTools(ctx, op, calls)
+	}
+
+	// Determine what to do based on the last entry. System notices (e.g.
+	// prompt_self messages) do not require a response of their own.
+	var last domain.StreamEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if last = entries[i]; last.Role != domain.RoleSystem {
+			break
+		}
+	}
 
 	switch {
 	case last.Role == domain.RoleUser:
@@ -144,12 +369,8 @@ func (c *Controller) step(ctx context.Context, operativeID string) error {
 		}
 		return c.checkAndCompact(ctx, op, updatedEntries)
 
-	case last.Role == domain.RoleAssistant && last.ContentType == domain.ContentTypeToolCall:
-		// Model requested a tool call → execute it.
-		return c.executeTool(ctx, op, last)
-
 	case last.Role == domain.RoleTool:
-		// Tool result → call model again with the result.
+		// All tool calls answered → call model again with the results.
 		if err := c.callModel(ctx, op, entries); err != nil {
 			return err
 		}
@@ -173,26 +394,58 @@ func (c *Controller) callModel(ctx context.Context, op *domain.Operative, entrie
 	// Convert stream entries to model messages.
 	messages := entriesToMessages(entries)
 
+	provider, modelName, err := c.providers.Resolve(ctx, op.Provider, op.Model)
+	if err != nil {
+		return fmt.Errorf("resolving model: %w", err)
+	}
+
 	// Call model.
-	stream, err := c.provider.Stream(ctx, op.Model, instructions, messages)
+	stream, err := provider.Stream(ctx, modelName, instructions, messages, model.DefaultTools)
 	if err != nil {
 		return fmt.Errorf("streaming model: %w", err)
 	}
 	defer stream.Close()
 
+	// Publish deltas as they arrive so clients can render the response
+	// before it is persisted. The final Done event tells them to discard it.
+	defer c.bus.Publish(events.Event{
+		Type:        events.TypePartial,
+		OperativeID: op.ID,
+		Partial:     &events.Partial{Done: true},
+	})
+	for {
+		delta, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("getting model response: %w", err)
+		}
+		c.bus.Publish(events.Event{
+			Type:        events.TypePartial,
+			OperativeID: op.ID,
+			Partial:     &events.Partial{Delta: delta},
+		})
+	}
+
 	msg, err := stream.FullMessage()
 	if err != nil {
 		return fmt.Errorf("getting model response: %w", err)
 	}
 
-	// Write the response to the stream.
-	for _, content := range msg.Content {
+	// Write the response to the stream. The usage of the request is
+	// recorded on its last entry.
+	usage := stream.Usage()
+	for i, content := range msg.Content {
 		entry := &domain.StreamEntry{
 			ID:          uuid.New().String(),
 			OperativeID: op.ID,
 			Role:        domain.RoleAssistant,
 			Model:       op.Model,
 		}
+		if i == len(msg.Content)-1 && usage != (domain.Usage{}) {
+			entry.Usage = &usage
+		}
 
 		switch content.Type {
 		case domain.ContentTypeText:
@@ -212,31 +465,127 @@ func (c *Controller) callModel(ctx context.Context, op *domain.Operative, entrie
 	return nil
 }
 
-// executeTool executes a tool call and appends the result.
-func (c *Controller) executeTool(ctx context.Context, op *domain.Operative, entry domain.StreamEntry) error {
-	var tc domain.ToolCall
-	if err := json.Unmarshal([]byte(entry.Content), &tc); err != nil {
-		return fmt.Errorf("parsing tool call: %w", err)
+// pendingToolCalls returns the tool calls of the latest assistant turn that
+// have no result yet, in the order the model made them. The turn is the run
+// of assistant entries preceding the trailing tool (and system) entries.
+func pendingToolCalls(entries []domain.StreamEntry) []domain.ToolCall {
+	end := len(entries)
+	for end > 0 && (entries[end-1].Role == domain.RoleTool || entries[end-1].Role == domain.RoleSystem) {
+		end--
+	}
+	start := end
+	for start > 0 && entries[start-1].Role == domain.RoleAssistant {
+		start--
+	}
+
+	answered := make(map[string]bool)
+	for _, e := range entries[end:] {
+		if e.ContentType != domain.ContentTypeToolResult {
+			continue
+		}
+		var tr domain.ToolResult
+		if err := json.Unmarshal([]byte(e.Content), &tr); err == nil {
+			answered[tr.ToolCallID] = true
+		}
+	}
+
+	var calls []domain.ToolCall
+	for _, e := range entries[start:end] {
+		if e.ContentType != domain.ContentTypeToolCall {
+			continue
+		}
+		var tc domain.ToolCall
+		if err := json.Unmarshal([]byte(e.Content), &tc); err != nil {
+			slog.Error("Skipping malformed tool call", "entryID", e.ID, "error", err)
+			continue
+		}
+		if !answered[tc.ID] {
+			calls = append(calls, tc)
+		}
+	}
+	return calls
+}
+
+// sequentialTools are tools whose calls must not overlap: the sandbox runs
+// one cell at a time (in the order the model wrote them), and instruction
+// updates overwrite each other.
+var sequentialTools = map[string]bool{
+	"run_ipython_cell":    true,
+	"update_instructions": true,
+}
+
+// executeTools executes tool calls and appends one result per call, in call
+// order. Calls to sequentialTools run one after another; the rest run
+// concurrently with them.
+func (c *Controller) executeTools(ctx context.Context, op *domain.Operative, calls []domain.ToolCall) error {
+	results := make([]*domain.ToolResult, len(calls))
+	var wg sync.WaitGroup
+	for i := range calls {
+		if sequentialTools[calls[i].Name] {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.executeTool(ctx, op, &calls[i])
+		}()
+	}
+	for i := range calls {
+		if sequentialTools[calls[i].Name] {
+			results[i] = c.executeTool(ctx, op, &calls[i])
+		}
 	}
+	wg.Wait()
 
-	result, err := c.dispatchTool(ctx, op, &tc)
+	for _, result := range results {
+		if err := c.appendToolResult(ctx, op, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeTool executes a tool call. Failures are returned as error results
+// so the model can react to them.
+func (c *Controller) executeTool(ctx context.Context, op *domain.Operative, tc *domain.ToolCall) *domain.ToolResult {
+	result, err := c.dispatchTool(ctx, op, tc)
 	if err != nil {
-		// Record the error as a tool result.
-		result = &domain.ToolResult{
+		return &domain.ToolResult{
 			ToolCallID: tc.ID,
 			Content:    fmt.Sprintf("Error: %v", err),
 			IsError:    true,
 		}
 	}
+	return result
+}
 
+// appendToolResult appends a tool result entry, followed by an entry for
+// each of its attachments.
+func (c *Controller) appendToolResult(ctx context.Context, op *domain.Operative, result *domain.ToolResult) error {
 	resultJSON, _ := json.Marshal(result)
-	return c.stream.Append(ctx, &domain.StreamEntry{
+	if err := c.stream.Append(ctx, &domain.StreamEntry{
 		ID:          uuid.New().String(),
 		OperativeID: op.ID,
 		Role:        domain.RoleTool,
 		ContentType: domain.ContentTypeToolResult,
 		Content:     string(resultJSON),
-	})
+	}); err != nil {
+		return fmt.Errorf("appending tool result: %w", err)
+	}
+
+	for _, a := range result.Attachments {
+		attachmentJSON, _ := json.Marshal(a)
+		if err := c.stream.Append(ctx, &domain.StreamEntry{
+			ID:          uuid.New().String(),
+			OperativeID: op.ID,
+			Role:        domain.RoleTool,
+			ContentType: domain.ContentTypeAttachment,
+			Content:     string(attachmentJSON),
+		}); err != nil {
+			return fmt.Errorf("appending attachment: %w", err)
+		}
+	}
+	return nil
 }
 
 // dispatchTool routes a tool call to the appropriate handler.
@@ -262,9 +611,20 @@ func (c *Controller) dispatchTool(ctx context.Context, op *domain.Operative, tc
 }
 
 // entriesToMessages converts stream entries to model messages.
+//
+// System entries (sandbox restarts, prompt_self messages, recovery notices)
+// are shown to the model as user messages marked "[System]". Providers
+// require tool calls to be followed directly by their results, so system
+// entries recorded while calls were outstanding are moved after the results.
 func entriesToMessages(entries []domain.StreamEntry) []model.Message {
-	var messages []model.Message
+	var messages, deferred []model.Message
+	outstanding := make(map[string]bool)
 	for _, e := range entries {
+		if e.Role != domain.RoleTool && e.Role != domain.RoleSystem {
+			messages = append(messages, deferred...)
+			deferred = nil
+		}
+
 		msg := model.Message{Role: e.Role}
 		switch e.ContentType {
 		case domain.ContentTypeText:
@@ -272,13 +632,30 @@ func entriesToMessages(entries []domain.StreamEntry) []model.Message {
 		case domain.ContentTypeToolCall:
 			var tc domain.ToolCall
 			json.Unmarshal([]byte(e.Content), &tc)
+			outstanding[tc.ID] = true
 			msg.Content = []model.Content{{Type: domain.ContentTypeToolCall, ToolCall: &tc}}
 		case domain.ContentTypeToolResult:
 			var tr domain.ToolResult
 			json.Unmarshal([]byte(e.Content), &tr)
+			delete(outstanding, tr.ToolCallID)
 			msg.Content = []model.Content{{Type: domain.ContentTypeToolResult, ToolResult: &tr}}
+		case domain.ContentTypeAttachment:
+			var a domain.Attachment
+			json.Unmarshal([]byte(e.Content), &a)
+			msg.Content = []model.Content{{Type: domain.ContentTypeAttachment, Attachment: &a}}
+		}
+
+		if e.Role == domain.RoleSystem {
+			msg = model.Message{
+				Role:    domain.RoleUser,
+				Content: []model.Content{{Type: domain.ContentTypeText, Text: "[System] " + e.Content}},
+			}
+			if len(outstanding) > 0 || len(deferred) > 0 {
+				deferred = append(deferred, msg)
+				continue
+			}
 		}
 		messages = append(messages, msg)
 	}
-	return messages
+	return append(messages, deferred...)
 }
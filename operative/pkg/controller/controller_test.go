@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nstogner/operative/pkg/domain"
+)
+
+func callEntry(id, name string) domain.StreamEntry {
+	b, _ := json.Marshal(domain.ToolCall{ID: id, Name: name})
+	return domain.StreamEntry{Role: domain.RoleAssistant, ContentType: domain.ContentTypeToolCall, Content: string(b)}
+}
+
+func resultEntry(id string) domain.StreamEntry {
+	b, _ := json.Marshal(domain.ToolResult{ToolCallID: id, Content: "ok"})
+	return domain.StreamEntry{Role: domain.RoleTool, ContentType: domain.ContentTypeToolResult, Content: string(b)}
+}
+
+func textEntry(role domain.Role, text string) domain.StreamEntry {
+	return domain.StreamEntry{Role: role, ContentType: domain.ContentTypeText, Content: text}
+}
+
+func TestPendingToolCalls(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []domain.StreamEntry
+		want    []string
+	}{
+		{
+			name: "all calls of the turn",
+			entries: []domain.StreamEntry{
+				textEntry(domain.RoleUser, "go"),
+				textEntry(domain.RoleAssistant, "Working."),
+				callEntry("a", "run_ipython_cell"),
+				callEntry("b", "get_note"),
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "partially answered",
+			entries: []domain.StreamEntry{
+				callEntry("a", "run_ipython_cell"),
+				callEntry("b", "get_note"),
+				resultEntry("b"),
+				textEntry(domain.RoleSystem, "progress"),
+			},
+			want: []string{"a"},
+		},
+		{
+			name: "fully answered",
+			entries: []domain.StreamEntry{
+				callEntry("a", "run_ipython_cell"),
+				callEntry("b", "get_note"),
+				resultEntry("a"),
+				resultEntry("b"),
+			},
+		},
+		{
+			name: "earlier turns are ignored",
+			entries: []domain.StreamEntry{
+				callEntry("old", "get_note"),
+				textEntry(domain.RoleUser, "never mind"),
+				textEntry(domain.RoleAssistant, "OK."),
+			},
+		},
+		{
+			name: "user message ends the turn",
+			entries: []domain.StreamEntry{
+				callEntry("a", "get_note"),
+				textEntry(domain.RoleUser, "stop"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, tc := range pendingToolCalls(tt.entries) {
+				got = append(got, tc.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("pendingToolCalls = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("pendingToolCalls = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestInterruptedResults(t *testing.T) {
+	calls := []domain.ToolCall{
+		{ID: "a", Name: "run_ipython_cell"},
+		{ID: "b", Name: "get_note"},
+		{ID: "c", Name: "store_note"},
+		{ID: "d", Name: "keyword_search_notes"},
+	}
+	results := interruptedResults(calls)
+
+	// Side-effect-free calls are left pending to be run again.
+	if len(results) != 2 || results[0].ToolCallID != "a" || results[1].ToolCallID != "c" {
+		t.Fatalf("interruptedResults = %+v, want results for a and c", results)
+	}
+	for _, r := range results {
+		if !r.IsError {
+			t.Errorf("result for %s is not an error", r.ToolCallID)
+		}
+	}
+}
+
+func TestEntriesToMessagesSystemEntries(t *testing.T) {
+	attachment, _ := json.Marshal(domain.Attachment{ToolCallID: "a", MIMEType: "image/png"})
+	entries := []domain.StreamEntry{
+		textEntry(domain.RoleUser, "go"),
+		callEntry("a", "run_ipython_cell"),
+		// Recorded by prompt_self while the cell was running.
+		textEntry(domain.RoleSystem, "progress"),
+		resultEntry("a"),
+		{Role: domain.RoleTool, ContentType: domain.ContentTypeAttachment, Content: string(attachment)},
+		textEntry(domain.RoleAssistant, "Done."),
+		textEntry(domain.RoleSystem, "sandbox restarted"),
+	}
+	msgs := entriesToMessages(entries)
+
+	var got []string
+	for _, m := range msgs {
+		c := m.Content[0]
+		got = append(got, string(m.Role)+":"+c.Type+":"+c.Text)
+	}
+	want := []string{
+		"user:text:go",
+		"assistant:tool_call:",
+		"tool:tool_result:",
+		"tool:attachment:",
+		"user:text:[System] progress",
+		"assistant:text:Done.",
+		"user:text:[System] sandbox restarted",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("messages = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("messages[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSandboxInstructions(t *testing.T) {
+	got := sandboxInstructions(domain.SandboxConfig{
+		Network:      domain.NetworkAllowlist,
+		AllowedHosts: []string{"pypi.org", "*.github.com"},
+	}.WithDefaults())
+	for _, want := range []string{"2048 MB", "2 CPUs", "pypi.org, *.github.com"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("instructions missing %q:\n%s", want, got)
+		}
+	}
+	if got := sandboxInstructions(domain.SandboxConfig{}.WithDefaults()); strings.Contains(got, "network") {
+		t.Errorf("unrestricted sandbox mentions network limits:\n%s", got)
+	}
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"sync"
+)
+
+// MaxConcurrentSteps bounds how many operatives are stepped at the same time.
+const MaxConcurrentSteps = 8
+
+// scheduler runs steps for operatives. Each operative has at most one worker,
+// so its steps never overlap and run in wakeup order, while different
+// operatives proceed independently (up to the concurrency limit). Wakeups that
+// arrive while a step is running are coalesced into a single follow-up step.
+type scheduler struct {
+	step func(ctx context.Context, operativeID string)
+	sem  chan struct{}
+
+	mu      sync.Mutex
+	workers map[string]*worker
+	wg      sync.WaitGroup
+}
+
+// worker tracks the goroutine stepping one operative.
+type worker struct {
+	// dirty is set when a wakeup arrives while a step is running.
+	dirty bool
+}
+
+func newScheduler(maxConcurrent int, step func(ctx context.Context, operativeID string)) *scheduler {
+	return &scheduler{
+		step:    step,
+		sem:     make(chan struct{}, maxConcurrent),
+		workers: make(map[string]*worker),
+	}
+}
+
+// wake schedules a step for the operative. It never blocks.
+func (s *scheduler) wake(ctx context.Context, operativeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.workers[operativeID]; ok {
+		w.dirty = true
+		return
+	}
+	s.workers[operativeID] = &worker{}
+	s.wg.Add(1)
+	go s.run(ctx, operativeID)
+}
+
+// run steps the operative until no wakeups are outstanding.
+func (s *scheduler) run(ctx context.Context, operativeID string) {
+	defer s.wg.Done()
+	for {
+		select {
+		case s.sem <- struct{}{}:
+		case <-ctx.Done():
+			s.mu.Lock()
+			delete(s.workers, operativeID)
+			s.mu.Unlock()
+			return
+		}
+		s.step(ctx, operativeID)
+		<-s.sem
+
+		s.mu.Lock()
+		w := s.workers[operativeID]
+		if !w.dirty || ctx.Err() != nil {
+			delete(s.workers, operativeID)
+			s.mu.Unlock()
+			return
+		}
+		w.dirty = false
+		s.mu.Unlock()
+	}
+}
+
+// operativeLocks holds a lock per operative, for work that must not overlap
+// with its steps. The zero value is ready to use.
+type operativeLocks struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+// lock waits until the operative's lock is free or ctx is done, and returns
+// the function that releases it.
+func (l *operativeLocks) lock(ctx context.Context, operativeID string) (func(), error) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]chan struct{})
+	}
+	ch, ok := l.locks[operativeID]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		l.locks[operativeID] = ch
+	}
+	l.mu.Unlock()
+
+	select {
+	case ch <- struct{}{}:
+		return func() { <-ch }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// wait blocks until all workers have exited.
+func (s *scheduler) wait() {
+	s.wg.Wait()
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOperativeLocks(t *testing.T) {
+	var l operativeLocks
+	ctx := context.Background()
+
+	unlock, err := l.lock(ctx, "a")
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	// Other operatives are not blocked.
+	unlockB, err := l.lock(ctx, "b")
+	if err != nil {
+		t.Fatalf("lock b: %v", err)
+	}
+	unlockB()
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := l.lock(ctx, "a")
+		if err == nil {
+			release()
+		}
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	<-acquired
+}
+
+func TestSchedulerCoalescesWakeups(t *testing.T) {
+	release := make(chan struct{})
+	var steps atomic.Int32
+	s := newScheduler(2, func(ctx context.Context, operativeID string) {
+		if steps.Add(1) == 1 {
+			<-release
+		}
+	})
+	ctx := context.Background()
+
+	s.wake(ctx, "a")
+	waitFor(t, func() bool { return steps.Load() == 1 })
+	// A burst of wakeups during the first step results in one more step.
+	for i := 0; i < 10; i++ {
+		s.wake(ctx, "a")
+	}
+	close(release)
+	s.wait()
+
+	if got := steps.Load(); got != 2 {
+		t.Errorf("steps = %d, want 2", got)
+	}
+}
+
+func TestSchedulerSerializesPerOperative(t *testing.T) {
+	var mu sync.Mutex
+	running := map[string]int{}
+	var overlap, maxRunning atomic.Int32
+	var total atomic.Int32
+	s := newScheduler(2, func(ctx context.Context, operativeID string) {
+		mu.Lock()
+		running[operativeID]++
+		if running[operativeID] > 1 {
+			overlap.Store(1)
+		}
+		n := int32(0)
+		for _, c := range running {
+			n += int32(c)
+		}
+		if n > maxRunning.Load() {
+			maxRunning.Store(n)
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+		total.Add(1)
+
+		mu.Lock()
+		running[operativeID]--
+		mu.Unlock()
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		for _, id := range []string{"a", "b", "c", "d"} {
+			s.wake(ctx, id)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	s.wait()
+
+	if overlap.Load() != 0 {
+		t.Error("steps for the same operative overlapped")
+	}
+	if got := maxRunning.Load(); got > 2 {
+		t.Errorf("max concurrent steps = %d, want <= 2", got)
+	}
+	if total.Load() < 4 {
+		t.Errorf("steps = %d, want every operative stepped", total.Load())
+	}
+}
+
+func TestSchedulerIndependentOperatives(t *testing.T) {
+	block := make(chan struct{})
+	done := make(chan string, 1)
+	s := newScheduler(2, func(ctx context.Context, operativeID string) {
+		if operativeID == "slow" {
+			<-block
+			return
+		}
+		done <- operativeID
+	})
+	ctx := context.Background()
+
+	s.wake(ctx, "slow")
+	s.wake(ctx, "fast")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a slow operative blocked another")
+	}
+	close(block)
+	s.wait()
+}
+
+// waitFor polls cond until it is true or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -4,12 +4,32 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/events"
 	"github.com/nstogner/operative/pkg/sandbox"
 )
 
+const (
+	// DefaultCellTimeout bounds a run_ipython_cell call when the operative
+	// does not set CellTimeoutSeconds.
+	DefaultCellTimeout = 5 * time.Minute
+
+	// DefaultMaxCellOutputBytes bounds the cell output kept in the tool
+	// result when the operative does not set MaxCellOutputBytes.
+	DefaultMaxCellOutputBytes = 16 * 1024
+
+	// cellInterruptGrace is how long a timed-out cell has to respond to the
+	// interrupt before the call is abandoned.
+	cellInterruptGrace = 10 * time.Second
+)
+
 // toolRunIPythonCell executes code in the operative's sandbox.
 func (c *Controller) toolRunIPythonCell(ctx context.Context, op *domain.Operative, tc *domain.ToolCall) (*domain.ToolResult, error) {
 	code, _ := tc.Input["code"].(string)
@@ -22,13 +42,42 @@ func (c *Controller) toolRunIPythonCell(ctx context.Context, op *domain.Operativ
 	}
 
 	delegate := &controllerDelegate{
-		ctx:  ctx,
-		ctrl: c,
-		op:   op,
+		ctx:        ctx,
+		ctrl:       c,
+		op:         op,
+		toolCallID: tc.ID,
+	}
+
+	timeout := DefaultCellTimeout
+	if op.CellTimeoutSeconds > 0 {
+		timeout = time.Duration(op.CellTimeoutSeconds) * time.Second
 	}
 
-	result, err := c.sandbox.RunCell(ctx, op.ID, code, delegate)
+	// On timeout, interrupt the cell like a user would. If it ignores the
+	// KeyboardInterrupt (e.g. stuck in native code), give up on it after a
+	// grace period.
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		slog.Warn("Cell timed out, interrupting", "operativeID", op.ID, "timeout", timeout)
+		if err := c.sandbox.Interrupt(runCtx, op.ID); err != nil {
+			slog.Warn("Failed to interrupt timed-out cell", "operativeID", op.ID, "error", err)
+		}
+		time.AfterFunc(cellInterruptGrace, cancel)
+	})
+	defer timer.Stop()
+
+	result, err := c.sandbox.RunCell(runCtx, op.ID, code, delegate)
 	if err != nil {
+		if timedOut.Load() && ctx.Err() == nil {
+			return &domain.ToolResult{
+				ToolCallID: tc.ID,
+				Content:    fmt.Sprintf("Error: execution timed out after %s and did not respond to an interrupt; its output was lost. The sandbox may still be busy.", timeout),
+				IsError:    true,
+			}, nil
+		}
 		return nil, fmt.Errorf("running cell: %w", err)
 	}
 
@@ -40,12 +89,95 @@ func (c *Controller) toolRunIPythonCell(ctx context.Context, op *domain.Operativ
 		}
 	}
 
+	maxOutput := DefaultMaxCellOutputBytes
+	if op.MaxCellOutputBytes > 0 {
+		maxOutput = op.MaxCellOutputBytes
+	}
+	output = truncateOutput(output, maxOutput)
+
+	var attachments []domain.Attachment
+	for _, d := range result.Displays {
+		attachments = append(attachments, domain.Attachment{
+			ToolCallID: tc.ID,
+			MIMEType:   d.MIMEType,
+			Data:       d.Data,
+		})
+	}
+
+	if result.Interrupted {
+		// Record the interruption as a failed call so the model knows the
+		// cell did not run to completion.
+		note := "[Execution was interrupted by the user.]"
+		if timedOut.Load() {
+			note = fmt.Sprintf("[Execution timed out after %s and was interrupted.]", timeout)
+		}
+		return &domain.ToolResult{
+			ToolCallID:  tc.ID,
+			Content:     joinOutput(output, note),
+			IsError:     true,
+			Attachments: attachments,
+		}, nil
+	}
+
+	if !result.Success && result.Error != nil {
+		return &domain.ToolResult{
+			ToolCallID:  tc.ID,
+			Content:     joinOutput(output, formatCellError(result.Error)),
+			IsError:     true,
+			Attachments: attachments,
+		}, nil
+	}
+
 	return &domain.ToolResult{
-		ToolCallID: tc.ID,
-		Content:    output,
+		ToolCallID:  tc.ID,
+		Content:     output,
+		Attachments: attachments,
 	}, nil
 }
 
+// maxTracebackBytes bounds the traceback appended to a failed cell's result.
+const maxTracebackBytes = 4 * 1024
+
+// formatCellError renders a cell exception for the model: the traceback if
+// the sandbox provided one, otherwise just the exception.
+func formatCellError(e *sandbox.CellError) string {
+	if tb := strings.TrimSpace(e.Traceback); tb != "" {
+		return truncateOutput(tb, maxTracebackBytes)
+	}
+	if e.Value == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Value
+}
+
+// joinOutput appends a trailing section to cell output.
+func joinOutput(output, section string) string {
+	output = strings.TrimRight(output, "\n")
+	if output == "" {
+		return section
+	}
+	return output + "\n\n" + section
+}
+
+// truncateOutput shortens s to roughly max bytes by keeping its head and
+// tail, which usually hold the most useful parts of long output (what the
+// cell started doing and how it ended), joined by a marker.
+func truncateOutput(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	head := max / 2
+	tail := len(s) - (max - head)
+	// Do not split multi-byte characters.
+	for head > 0 && !utf8.RuneStart(s[head]) {
+		head--
+	}
+	for tail < len(s) && !utf8.RuneStart(s[tail]) {
+		tail++
+	}
+	return fmt.Sprintf("%s\n\n[... %d bytes truncated ...]\n\n%s", s[:head], tail-head, s[tail:])
+}
+
 // toolUpdateInstructions updates the operative's self-set instructions.
 func (c *Controller) toolUpdateInstructions(ctx context.Context, op *domain.Operative, tc *domain.ToolCall) (*domain.ToolResult, error) {
 	instructions, _ := tc.Input["instructions"].(string)
@@ -163,6 +295,8 @@ type controllerDelegate struct {
 	ctx  context.Context
 	ctrl *Controller
 	op   *domain.Operative
+	// toolCallID is the run_ipython_cell call whose cell is running.
+	toolCallID string
 }
 
 var _ sandbox.Delegate = (*controllerDelegate)(nil)
@@ -173,7 +307,13 @@ func (d *controllerDelegate) PromptModel(ctx context.Context, prompt string) (st
 	}
 	messages := entriesToMessages(msgs)
 
-	stream, err := d.ctrl.provider.Stream(ctx, d.op.Model, "", messages)
+	provider, modelName, err := d.ctrl.providers.Resolve(ctx, d.op.Provider, d.op.Model)
+	if err != nil {
+		return "", err
+	}
+
+	// The cell expects a text answer, so no tools are declared.
+	stream, err := provider.Stream(ctx, modelName, "", messages, nil)
 	if err != nil {
 		return "", err
 	}
@@ -201,3 +341,15 @@ func (d *controllerDelegate) PromptSelf(ctx context.Context, message string) err
 		Content:     message,
 	})
 }
+
+func (d *controllerDelegate) Output(ctx context.Context, text string, isStderr bool) {
+	d.ctrl.bus.Publish(events.Event{
+		Type:        events.TypeCellOutput,
+		OperativeID: d.op.ID,
+		CellOutput: &events.CellOutput{
+			ToolCallID: d.toolCallID,
+			Text:       text,
+			IsStderr:   isStderr,
+		},
+	})
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nstogner/operative/pkg/sandbox"
+)
+
+func TestTruncateOutput(t *testing.T) {
+	if got := truncateOutput("short", 100); got != "short" {
+		t.Errorf("truncateOutput(short) = %q", got)
+	}
+
+	s := strings.Repeat("a", 50) + strings.Repeat("b", 1000) + strings.Repeat("c", 50)
+	got := truncateOutput(s, 100)
+	if !strings.HasPrefix(got, strings.Repeat("a", 50)) || !strings.HasSuffix(got, strings.Repeat("c", 50)) {
+		t.Errorf("head/tail not kept: %q", got)
+	}
+	if !strings.Contains(got, "[... 1000 bytes truncated ...]") {
+		t.Errorf("missing truncation marker: %q", got)
+	}
+
+	// Multi-byte characters are never split.
+	s = strings.Repeat("é", 100)
+	got = truncateOutput(s, 51)
+	if !strings.HasPrefix(got, strings.Repeat("é", 12)+"\n") {
+		t.Errorf("head split a character: %q", got)
+	}
+	if !strings.HasSuffix(got, "\n"+strings.Repeat("é", 13)) {
+		t.Errorf("tail split a character: %q", got)
+	}
+}
+
+func TestFormatCellError(t *testing.T) {
+	tb := "Traceback (most recent call last):\n  File \"<cell>\", line 1\nZeroDivisionError: division by zero\n"
+	if got := formatCellError(&sandbox.CellError{Name: "ZeroDivisionError", Value: "division by zero", Traceback: tb}); got != strings.TrimSpace(tb) {
+		t.Errorf("with traceback = %q", got)
+	}
+	if got := formatCellError(&sandbox.CellError{Name: "ZeroDivisionError", Value: "division by zero"}); got != "ZeroDivisionError: division by zero" {
+		t.Errorf("without traceback = %q", got)
+	}
+
+	if got := joinOutput("partial\n", "KeyboardInterrupt"); got != "partial\n\nKeyboardInterrupt" {
+		t.Errorf("joinOutput = %q", got)
+	}
+	if got := joinOutput("", "KeyboardInterrupt"); got != "KeyboardInterrupt" {
+		t.Errorf("joinOutput with empty output = %q", got)
+	}
+}
@@ -21,4 +21,5 @@ const (
 	ContentTypeText       = "text"
 	ContentTypeToolCall   = "tool_call"
 	ContentTypeToolResult = "tool_result"
+	ContentTypeAttachment = "attachment"
 )
@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sandbox network modes.
+const (
+	// NetworkBridge gives the sandbox unrestricted network access.
+	NetworkBridge = "bridge"
+	// NetworkNone cuts the sandbox off from the network.
+	NetworkNone = "none"
+	// NetworkAllowlist only allows HTTP(S) requests to AllowedHosts, through
+	// a proxy set in the sandbox's HTTP_PROXY/HTTPS_PROXY variables.
+	NetworkAllowlist = "allowlist"
+)
+
+// Sandbox defaults, used for zero-valued SandboxConfig fields.
+const (
+	DefaultSandboxMemoryMB  = 2048
+	DefaultSandboxCPUs      = 2.0
+	DefaultSandboxPidsLimit = 512
+	DefaultSandboxNetwork   = NetworkBridge
+)
+
+// SandboxConfig holds the resource limits and network policy of an
+// operative's sandbox container. Zero values select the defaults.
+type SandboxConfig struct {
+	MemoryMB       int64    `json:"memory_mb,omitempty"`
+	CPUs           float64  `json:"cpus,omitempty"`
+	PidsLimit      int64    `json:"pids_limit,omitempty"`
+	ReadOnlyRootFS bool     `json:"read_only_rootfs,omitempty"` // only /workspace and scratch dirs are writable
+	Network        string   `json:"network,omitempty"`          // NetworkBridge, NetworkNone or NetworkAllowlist
+	AllowedHosts   []string `json:"allowed_hosts,omitempty"`    // for NetworkAllowlist; "*.example.com" matches subdomains
+}
+
+// WithDefaults returns the config with zero values replaced by defaults.
+func (c SandboxConfig) WithDefaults() SandboxConfig {
+	if c.MemoryMB == 0 {
+		c.MemoryMB = DefaultSandboxMemoryMB
+	}
+	if c.CPUs == 0 {
+		c.CPUs = DefaultSandboxCPUs
+	}
+	if c.PidsLimit == 0 {
+		c.PidsLimit = DefaultSandboxPidsLimit
+	}
+	if c.Network == "" {
+		c.Network = DefaultSandboxNetwork
+	}
+	return c
+}
+
+// Validate checks user-supplied sandbox settings.
+func (c SandboxConfig) Validate() error {
+	if c.MemoryMB < 0 || (c.MemoryMB > 0 && c.MemoryMB < 64) {
+		return errors.New("sandbox memory_mb must be at least 64")
+	}
+	if c.CPUs < 0 {
+		return errors.New("sandbox cpus must not be negative")
+	}
+	if c.PidsLimit < 0 {
+		return errors.New("sandbox pids_limit must not be negative")
+	}
+	switch c.Network {
+	case "", NetworkBridge, NetworkNone:
+		if len(c.AllowedHosts) > 0 {
+			return errors.New("sandbox allowed_hosts requires the allowlist network")
+		}
+	case NetworkAllowlist:
+		if len(c.AllowedHosts) == 0 {
+			return errors.New("sandbox allowlist network requires allowed_hosts")
+		}
+		for _, h := range c.AllowedHosts {
+			if h == "" || strings.ContainsAny(h, ",/: ") {
+				return fmt.Errorf("invalid allowed host %q: want a host name like example.com or *.example.com", h)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown sandbox network %q", c.Network)
+	}
+	return nil
+}
@@ -5,26 +5,55 @@ import "time"
 // Operative represents a long-running agent with a container sandbox,
 // a configurable model, and a rolling message stream.
 type Operative struct {
-	ID                    string    `json:"id"`
-	Name                  string    `json:"name"`
-	AdminInstructions     string    `json:"admin_instructions"`
-	OperativeInstructions string    `json:"operative_instructions"`
-	Model                 string    `json:"model"`
-	CompactionModel       string    `json:"compaction_model,omitempty"`
-	CompactionThreshold   float64   `json:"compaction_threshold,omitempty"` // 0-1, fraction of max context window
-	CreatedAt             time.Time `json:"created_at"`
-	UpdatedAt             time.Time `json:"updated_at"`
+	ID                    string        `json:"id"`
+	Name                  string        `json:"name"`
+	AdminInstructions     string        `json:"admin_instructions"`
+	OperativeInstructions string        `json:"operative_instructions"`
+	Provider              string        `json:"provider,omitempty"` // model provider name; empty = default or "provider/" prefix on Model
+	Model                 string        `json:"model"`
+	CompactionModel       string        `json:"compaction_model,omitempty"`
+	CompactionThreshold   float64       `json:"compaction_threshold,omitempty"`  // 0-1, fraction of max context window
+	CellTimeoutSeconds    int           `json:"cell_timeout_seconds,omitempty"`  // run_ipython_cell timeout; 0 = default
+	MaxCellOutputBytes    int           `json:"max_cell_output_bytes,omitempty"` // cell output kept in the tool result; 0 = default
+	Image                 string        `json:"image,omitempty"`                 // sandbox container image; empty = default
+	Sandbox               SandboxConfig `json:"sandbox"`
+	CreatedAt             time.Time     `json:"created_at"`
+	UpdatedAt             time.Time     `json:"updated_at"`
 }
 
 // StreamEntry represents a single entry in an operative's message stream.
 type StreamEntry struct {
-	ID          string    `json:"id"`
-	OperativeID string    `json:"operative_id"`
-	Role        Role      `json:"role"`
-	ContentType string    `json:"content_type"` // "text", "tool_call", "tool_result"
-	Content     string    `json:"content"`      // Text content or JSON-encoded tool call/result
-	Model       string    `json:"model,omitempty"`
-	Timestamp   time.Time `json:"timestamp"`
+	ID          string `json:"id"`
+	OperativeID string `json:"operative_id"`
+	Role        Role   `json:"role"`
+	ContentType string `json:"content_type"` // "text", "tool_call", "tool_result", "attachment"
+	Content     string `json:"content"`      // Text content or JSON-encoded tool call/result/attachment
+	Model       string `json:"model,omitempty"`
+	// FirstKeptEntryID is set on compaction summaries: the oldest entry that
+	// the summary does not cover and that stays in the compacted view.
+	FirstKeptEntryID string `json:"first_kept_entry_id,omitempty"`
+	// Usage is set on the last entry of a model response: the tokens of the
+	// request and of the whole response.
+	Usage *Usage `json:"usage,omitempty"`
+	// Pinned entries stay in the compacted view verbatim: compaction does
+	// not replace them, and they follow the summary that covers them. Only
+	// user messages can be pinned.
+	Pinned    bool      `json:"pinned,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Compaction is a compaction summary and the range of entries it replaced.
+// Each summary also replaces the previous one, so the ranges of consecutive
+// compactions follow each other.
+type Compaction struct {
+	Summary StreamEntry `json:"summary"`
+	// FirstEntryID and LastEntryID are the oldest and newest entries the
+	// summary replaced, besides the previous summary and pinned entries.
+	// Empty if there are none.
+	FirstEntryID string `json:"first_entry_id,omitempty"`
+	LastEntryID  string `json:"last_entry_id,omitempty"`
+	// EntryCount is the number of entries in that range.
+	EntryCount int `json:"entry_count"`
 }
 
 // Note is a persistent, searchable text entry attached to an operative.
@@ -51,6 +80,15 @@ type Model struct {
 	MaxTokens int    `json:"max_tokens,omitempty"`
 }
 
+// Usage is the token usage of a model request as reported by the provider.
+type Usage struct {
+	// InputTokens is the size of the prompt, including the system
+	// instructions and tool declarations.
+	InputTokens int `json:"input_tokens"`
+	// OutputTokens is the size of the response.
+	OutputTokens int `json:"output_tokens"`
+}
+
 // ToolCall represents a tool invocation by the model.
 type ToolCall struct {
 	ID    string         `json:"id"`
@@ -63,4 +101,16 @@ type ToolResult struct {
 	ToolCallID string `json:"tool_call_id"`
 	Content    string `json:"content"`
 	IsError    bool   `json:"is_error"`
+
+	// Attachments are rich outputs of the call. They are not part of the
+	// result entry; each is stored as its own attachment entry after it.
+	Attachments []Attachment `json:"-"`
+}
+
+// Attachment is a binary output of a tool call, such as a plot or an HTML
+// table displayed by an IPython cell.
+type Attachment struct {
+	ToolCallID string `json:"tool_call_id"`
+	MIMEType   string `json:"mime_type"`
+	Data       []byte `json:"data"` // base64-encoded in JSON
 }
@@ -0,0 +1,118 @@
+package events
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/nstogner/operative/pkg/model"
+)
+
+// Event types.
+const (
+	// TypePartial carries an incremental piece of a model response that has
+	// not yet been persisted to the stream.
+	TypePartial = "partial"
+
+	// TypeCellOutput carries a chunk of stdout/stderr from an IPython cell
+	// that is still running.
+	TypeCellOutput = "cell_output"
+)
+
+// Event is a transient notification about an operative. Unlike stream
+// entries, events are not persisted; clients that are not subscribed when
+// an event is published never see it.
+type Event struct {
+	Type        string `json:"event"`
+	OperativeID string `json:"operative_id"`
+
+	// Partial is set for TypePartial events.
+	Partial *Partial `json:"partial,omitempty"`
+
+	// CellOutput is set for TypeCellOutput events.
+	CellOutput *CellOutput `json:"cell_output,omitempty"`
+}
+
+// Partial is a delta of the model response currently being generated.
+// Done is set once the response has been persisted (or has failed), after
+// which clients should discard the partial response they have accumulated.
+type Partial struct {
+	model.Delta
+	Done bool `json:"done,omitempty"`
+}
+
+// CellOutput is a chunk of output from the cell run by the tool call
+// ToolCallID. The complete output arrives later as the call's tool result.
+type CellOutput struct {
+	ToolCallID string `json:"tool_call_id"`
+	Text       string `json:"text"`
+	IsStderr   bool   `json:"is_stderr,omitempty"`
+}
+
+// Bus fans out events to subscribers interested in an operative.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[chan Event]string
+}
+
+// NewBus creates an empty Bus.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]string)}
+}
+
+// subscriberBuffer is how many events a subscriber can fall behind before it
+// is dropped.
+const subscriberBuffer = 1024
+
+// Subscribe returns a channel of events for the given operative and a
+// function that cancels the subscription. The channel is closed if the
+// subscriber falls too far behind (see Publish).
+func (b *Bus) Subscribe(operativeID string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = operativeID
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// Publish sends an event to all subscribers of its operative. It never
+// blocks. A subscriber whose buffer is full would miss the event, which may
+// be the one that ends a partial response, so it is unsubscribed and its
+// channel closed instead; it should re-sync from the persisted stream, the
+// source of truth, and subscribe again.
+func (b *Bus) Publish(e Event) {
+	var lagging []chan Event
+	b.mu.RLock()
+	for ch, id := range b.subs {
+		if id != e.OperativeID {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+			lagging = append(lagging, ch)
+		}
+	}
+	b.mu.RUnlock()
+
+	if len(lagging) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range lagging {
+		if _, ok := b.subs[ch]; !ok {
+			continue
+		}
+		slog.Warn("Dropping lagging event subscriber", "operativeID", e.OperativeID, "eventType", e.Type)
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,63 @@
+package events
+
+import "testing"
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	a, unsubscribeA := b.Subscribe("op-a")
+	other, unsubscribeOther := b.Subscribe("op-b")
+	defer unsubscribeOther()
+
+	b.Publish(Event{Type: TypePartial, OperativeID: "op-a", Partial: &Partial{Done: true}})
+
+	select {
+	case e := <-a:
+		if e.OperativeID != "op-a" || e.Partial == nil || !e.Partial.Done {
+			t.Errorf("event = %+v", e)
+		}
+	default:
+		t.Fatal("subscriber did not receive event")
+	}
+	select {
+	case e := <-other:
+		t.Errorf("subscriber for another operative received %+v", e)
+	default:
+	}
+
+	unsubscribeA()
+	unsubscribeA() // idempotent
+	b.Publish(Event{Type: TypePartial, OperativeID: "op-a"})
+	select {
+	case e := <-a:
+		t.Errorf("unsubscribed channel received %+v", e)
+	default:
+	}
+}
+
+func TestBusDropsLaggingSubscriber(t *testing.T) {
+	b := NewBus()
+	slow, unsubscribeSlow := b.Subscribe("op-a")
+	defer unsubscribeSlow()
+
+	for i := 0; i < subscriberBuffer; i++ {
+		b.Publish(Event{Type: TypePartial, OperativeID: "op-a"})
+	}
+	fast, unsubscribeFast := b.Subscribe("op-a")
+	defer unsubscribeFast()
+	b.Publish(Event{Type: TypePartial, OperativeID: "op-a", Partial: &Partial{Done: true}})
+
+	// The slow subscriber gets what fit in its buffer, then a closed channel.
+	for i := 0; i < subscriberBuffer; i++ {
+		<-slow
+	}
+	if e, ok := <-slow; ok {
+		t.Errorf("lagging subscriber received %+v, want a closed channel", e)
+	}
+	if e := <-fast; e.Partial == nil || !e.Partial.Done {
+		t.Errorf("other subscriber received %+v", e)
+	}
+
+	// Later events and unsubscribing do not touch the closed channel.
+	b.Publish(Event{Type: TypePartial, OperativeID: "op-a"})
+	unsubscribeSlow()
+}
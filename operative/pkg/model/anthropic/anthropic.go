@@ -0,0 +1,655 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/model"
+)
+
+const (
+	// DefaultBaseURL is the Anthropic API endpoint used when no base URL is configured.
+	DefaultBaseURL = "https://api.anthropic.com"
+	// APIVersion is the value sent in the anthropic-version header.
+	APIVersion = "2023-06-01"
+	// DefaultMaxOutputTokens is the max_tokens sent with each request when not configured.
+	DefaultMaxOutputTokens = 8192
+	// DefaultContextWindow is reported as MaxTokens for listed models, since the
+	// models endpoint does not include context window sizes.
+	DefaultContextWindow = 200000
+)
+
+// Config configures the Anthropic provider.
+type Config struct {
+	// APIKey is sent in the x-api-key header.
+	APIKey string
+	// BaseURL is the API root without the version prefix. Defaults to DefaultBaseURL.
+	BaseURL string
+	// MaxOutputTokens caps the length of each response. Defaults to DefaultMaxOutputTokens.
+	MaxOutputTokens int
+	// HTTPClient is used for all requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Provider implements model.Provider using the Anthropic Messages API.
+type Provider struct {
+	apiKey          string
+	baseURL         string
+	maxOutputTokens int
+	client          *http.Client
+}
+
+// Verify interface compliance.
+var _ model.Provider = (*Provider)(nil)
+
+// New creates a new Anthropic provider.
+func New(cfg Config) (*Provider, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("anthropic API key is required")
+	}
+	p := &Provider{
+		apiKey:          cfg.APIKey,
+		baseURL:         strings.TrimRight(cfg.BaseURL, "/"),
+		maxOutputTokens: cfg.MaxOutputTokens,
+		client:          cfg.HTTPClient,
+	}
+	if p.baseURL == "" {
+		p.baseURL = DefaultBaseURL
+	}
+	if p.maxOutputTokens <= 0 {
+		p.maxOutputTokens = DefaultMaxOutputTokens
+	}
+	if p.client == nil {
+		p.client = http.DefaultClient
+	}
+	return p, nil
+}
+
+// Name returns the provider identifier.
+func (p *Provider) Name() string { return "anthropic" }
+
+// List returns available Claude models.
+func (p *Provider) List(ctx context.Context) ([]domain.Model, error) {
+	var models []domain.Model
+	afterID := ""
+	for {
+		q := url.Values{"limit": {"100"}}
+		if afterID != "" {
+			q.Set("after_id", afterID)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/v1/models?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := p.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Data []struct {
+				ID          string `json:"id"`
+				DisplayName string `json:"display_name"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding model list: %w", err)
+		}
+
+		for _, m := range page.Data {
+			models = append(models, domain.Model{
+				ID:        m.ID,
+				Name:      m.DisplayName,
+				Provider:  "anthropic",
+				MaxTokens: DefaultContextWindow,
+			})
+		}
+		if !page.HasMore || page.LastID == "" {
+			return models, nil
+		}
+		afterID = page.LastID
+	}
+}
+
+// Stream sends a conversation context to the LLM and returns a stream.
+func (p *Provider) Stream(ctx context.Context, modelName, instructions string, messages []model.Message, tools []model.Tool) (model.ModelStream, error) {
+	slog.Debug("Anthropic.Stream", "model", modelName, "messageCount", len(messages))
+
+	mreq := messagesRequest{
+		Model:     modelName,
+		MaxTokens: p.maxOutputTokens,
+		System:    buildSystem(instructions),
+		Messages:  buildMessages(messages),
+		Tools:     buildTools(tools),
+		Stream:    true,
+	}
+
+	body, err := json.Marshal(mreq)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodPost, p.baseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return newAnthropicStream(resp.Body, cancel), nil
+}
+
+// CountTokens counts the prompt tokens of a request with the Messages API's
+// token counting endpoint.
+func (p *Provider) CountTokens(ctx context.Context, modelName, instructions string, messages []model.Message, tools []model.Tool) (int, error) {
+	body, err := json.Marshal(countTokensRequest{
+		Model:    modelName,
+		System:   buildSystem(instructions),
+		Messages: buildMessages(messages),
+		Tools:    buildTools(tools),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("encoding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/v1/messages/count_tokens", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var count struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&count); err != nil {
+		return 0, fmt.Errorf("decoding token count: %w", err)
+	}
+	return count.InputTokens, nil
+}
+
+// do sends an authenticated request and converts non-2xx responses to errors.
+func (p *Provider) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("x-api-key", p.apiKey)
+	req.Header.Set("anthropic-version", APIVersion)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// --- wire types ---
+
+type messagesRequest struct {
+	Model     string    `json:"model"`
+	MaxTokens int       `json:"max_tokens"`
+	System    []block   `json:"system,omitempty"`
+	Messages  []message `json:"messages"`
+	Tools     []tool    `json:"tools,omitempty"`
+	Stream    bool      `json:"stream"`
+}
+
+type countTokensRequest struct {
+	Model    string    `json:"model"`
+	System   []block   `json:"system,omitempty"`
+	Messages []message `json:"messages"`
+	Tools    []tool    `json:"tools,omitempty"`
+}
+
+type message struct {
+	Role    string  `json:"role"` // "user" or "assistant"
+	Content []block `json:"content"`
+}
+
+// block is a content block. Only the fields relevant to Type are set.
+type block struct {
+	Type string `json:"type"` // "text", "tool_use", "tool_result", "image"
+
+	// text
+	Text string `json:"text,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+	IsError   bool   `json:"is_error,omitempty"`
+
+	// image
+	Source *imageSource `json:"source,omitempty"`
+
+	CacheControl *cacheControl `json:"cache_control,omitempty"`
+}
+
+type imageSource struct {
+	Type      string `json:"type"` // "base64"
+	MediaType string `json:"media_type"`
+	Data      []byte `json:"data"` // base64-encoded by encoding/json
+}
+
+type cacheControl struct {
+	Type string `json:"type"`
+}
+
+type tool struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	InputSchema *model.Schema `json:"input_schema"`
+}
+
+// buildSystem converts the instructions into the system prompt. The system
+// prompt is static across turns, so it is marked for prompt caching. The
+// cached prefix covers the tool declarations as well.
+func buildSystem(instructions string) []block {
+	if instructions == "" {
+		return nil
+	}
+	return []block{{
+		Type:         "text",
+		Text:         instructions,
+		CacheControl: &cacheControl{Type: "ephemeral"},
+	}}
+}
+
+func buildTools(tools []model.Tool) []tool {
+	var out []tool
+	for _, t := range tools {
+		out = append(out, tool{
+			Name:        t.Name,
+			Description: t.Description,
+			InputSchema: t.Parameters,
+		})
+	}
+	return out
+}
+
+// buildMessages converts model messages into Anthropic messages.
+//
+// Anthropic requires strictly alternating user/assistant turns that start with
+// a user turn, and every tool_use block must be answered by a tool_result
+// block (matched by ID) in the very next user turn. The stream stores one
+// entry per part, and compaction may cut between a call and its result, so
+// consecutive same-role messages are merged and unpaired tool_use/tool_result
+// blocks are downgraded to plain text.
+func buildMessages(messages []model.Message) []message {
+	var out []message
+	add := func(role string, blocks []block) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(out); n > 0 && out[n-1].Role == role {
+			out[n-1].Content = append(out[n-1].Content, blocks...)
+			return
+		}
+		out = append(out, message{Role: role, Content: blocks})
+	}
+
+	for _, msg := range messages {
+		var blocks []block
+		switch msg.Role {
+		case domain.RoleCompactionSummary:
+			// The summary always starts the compacted view, and Anthropic
+			// conversations must start with a user turn.
+			for _, c := range msg.Content {
+				if c.Text != "" {
+					blocks = append(blocks, block{Type: "text", Text: "Summary of the earlier conversation:\n\n" + c.Text})
+				}
+			}
+			add("user", blocks)
+
+		case domain.RoleAssistant:
+			for _, c := range msg.Content {
+				switch c.Type {
+				case domain.ContentTypeText:
+					if c.Text != "" {
+						blocks = append(blocks, block{Type: "text", Text: c.Text})
+					}
+				case domain.ContentTypeToolCall:
+					if c.ToolCall == nil {
+						continue
+					}
+					input, _ := json.Marshal(c.ToolCall.Input)
+					if c.ToolCall.Input == nil {
+						input = []byte("{}")
+					}
+					blocks = append(blocks, block{
+						Type:  "tool_use",
+						ID:    c.ToolCall.ID,
+						Name:  c.ToolCall.Name,
+						Input: input,
+					})
+				}
+			}
+			add("assistant", blocks)
+
+		case domain.RoleTool:
+			for _, c := range msg.Content {
+				switch {
+				case c.Type == domain.ContentTypeToolResult && c.ToolResult != nil:
+					blocks = append(blocks, block{
+						Type:      "tool_result",
+						ToolUseID: c.ToolResult.ToolCallID,
+						Content:   c.ToolResult.Content,
+						IsError:   c.ToolResult.IsError,
+					})
+				case c.Type == domain.ContentTypeAttachment && c.Attachment != nil && model.IsImage(c.Attachment.MIMEType):
+					// Images follow the tool results in the same user turn.
+					blocks = append(blocks, block{
+						Type: "image",
+						Source: &imageSource{
+							Type:      "base64",
+							MediaType: c.Attachment.MIMEType,
+							Data:      c.Attachment.Data,
+						},
+					})
+				}
+			}
+			add("user", blocks)
+
+		default:
+			for _, c := range msg.Content {
+				if c.Type == domain.ContentTypeText && c.Text != "" {
+					blocks = append(blocks, block{Type: "text", Text: c.Text})
+				}
+			}
+			add("user", blocks)
+		}
+	}
+
+	pairToolBlocks(out)
+
+	if len(out) > 0 && out[0].Role != "user" {
+		out = append([]message{{Role: "user", Content: []block{{Type: "text", Text: "(conversation continues)"}}}}, out...)
+	}
+	return out
+}
+
+// pairToolBlocks enforces tool_use/tool_result pairing by ID between each
+// assistant turn and the user turn that follows it. Unpaired blocks are
+// converted to text so their information is kept without violating the API
+// contract. Within a user turn, tool_result blocks are moved to the front.
+func pairToolBlocks(msgs []message) {
+	for i := range msgs {
+		if msgs[i].Role != "user" {
+			continue
+		}
+
+		// Tool use IDs offered by the preceding assistant turn.
+		offered := map[string]bool{}
+		if i > 0 {
+			for _, b := range msgs[i-1].Content {
+				if b.Type == "tool_use" {
+					offered[b.ID] = true
+				}
+			}
+		}
+
+		var results, rest []block
+		for _, b := range msgs[i].Content {
+			switch {
+			case b.Type == "tool_result" && offered[b.ToolUseID]:
+				results = append(results, b)
+			case b.Type == "tool_result":
+				rest = append(rest, block{Type: "text", Text: fmt.Sprintf("[Result of tool call %s]\n%s", b.ToolUseID, b.Content)})
+			default:
+				rest = append(rest, b)
+			}
+		}
+		msgs[i].Content = append(results, rest...)
+	}
+
+	for i := range msgs {
+		if msgs[i].Role != "assistant" {
+			continue
+		}
+		answered := map[string]bool{}
+		if i+1 < len(msgs) {
+			for _, b := range msgs[i+1].Content {
+				if b.Type == "tool_result" {
+					answered[b.ToolUseID] = true
+				}
+			}
+		}
+		for j, b := range msgs[i].Content {
+			if b.Type == "tool_use" && !answered[b.ID] {
+				msgs[i].Content[j] = block{Type: "text", Text: fmt.Sprintf("[Called tool %s with input %s]", b.Name, b.Input)}
+			}
+		}
+	}
+}
+
+// streamEvent is a server-sent event from the Messages streaming API.
+type streamEvent struct {
+	Type         string `json:"type"`
+	Index        int    `json:"index"`
+	ContentBlock *struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Text string `json:"text"`
+	} `json:"content_block,omitempty"`
+	Delta *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	// Message is set on message_start and Usage on message_delta.
+	Message *struct {
+		Usage usage `json:"usage"`
+	} `json:"message,omitempty"`
+	Usage *usage `json:"usage,omitempty"`
+}
+
+// usage is the token usage of a message. Cached prompt tokens are not
+// included in InputTokens.
+type usage struct {
+	InputTokens              int `json:"input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+}
+
+// anthropicStream reads a server-sent event stream of message events.
+type anthropicStream struct {
+	body    io.ReadCloser
+	cancel  context.CancelFunc
+	scanner *bufio.Scanner
+	done    bool
+
+	blocks []*partialBlock // indexed by content block index
+	usage  domain.Usage
+}
+
+// partialBlock accumulates a content block as its deltas arrive.
+type partialBlock struct {
+	typ   string
+	id    string
+	name  string
+	text  strings.Builder
+	input strings.Builder
+}
+
+func newAnthropicStream(body io.ReadCloser, cancel context.CancelFunc) *anthropicStream {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	return &anthropicStream{body: body, cancel: cancel, scanner: scanner}
+}
+
+func (s *anthropicStream) Next() (model.Delta, error) {
+	for !s.done {
+		d, ok, err := s.readEvent()
+		if err != nil {
+			return model.Delta{}, err
+		}
+		if ok {
+			return d, nil
+		}
+	}
+	return model.Delta{}, io.EOF
+}
+
+// readEvent reads the next event from the stream and accumulates it. ok
+// reports whether the event carried a delta for the caller.
+func (s *anthropicStream) readEvent() (d model.Delta, ok bool, err error) {
+	var data string
+	for {
+		if !s.scanner.Scan() {
+			if err := s.scanner.Err(); err != nil {
+				return d, false, err
+			}
+			// A complete stream ends with message_stop.
+			return d, false, fmt.Errorf("stream ended before message_stop: %w", io.ErrUnexpectedEOF)
+		}
+		if line, found := strings.CutPrefix(s.scanner.Text(), "data:"); found {
+			data = line
+			break
+		}
+	}
+
+	var ev streamEvent
+	if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &ev); err != nil {
+		return d, false, fmt.Errorf("decoding stream event: %w", err)
+	}
+
+	switch ev.Type {
+	case "error":
+		if ev.Error != nil {
+			return d, false, fmt.Errorf("stream error: %s: %s", ev.Error.Type, ev.Error.Message)
+		}
+		return d, false, fmt.Errorf("stream error")
+	case "message_stop":
+		s.done = true
+	case "message_start":
+		if ev.Message != nil {
+			u := ev.Message.Usage
+			s.usage.InputTokens = u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+			s.usage.OutputTokens = u.OutputTokens
+		}
+	case "message_delta":
+		// The output token count is cumulative.
+		if ev.Usage != nil {
+			s.usage.OutputTokens = ev.Usage.OutputTokens
+		}
+	case "content_block_start":
+		for len(s.blocks) <= ev.Index {
+			s.blocks = append(s.blocks, &partialBlock{})
+		}
+		if cb := ev.ContentBlock; cb != nil {
+			b := s.blocks[ev.Index]
+			b.typ, b.id, b.name = cb.Type, cb.ID, cb.Name
+			b.text.WriteString(cb.Text)
+			switch b.typ {
+			case "text":
+				return model.Delta{Text: cb.Text}, cb.Text != "", nil
+			case "tool_use":
+				return model.Delta{ToolCall: &model.ToolCallDelta{Index: ev.Index, ID: cb.ID, Name: cb.Name}}, true, nil
+			}
+		}
+	case "content_block_delta":
+		if ev.Index >= len(s.blocks) || ev.Delta == nil {
+			return d, false, nil
+		}
+		b := s.blocks[ev.Index]
+		b.text.WriteString(ev.Delta.Text)
+		b.input.WriteString(ev.Delta.PartialJSON)
+		switch ev.Delta.Type {
+		case "text_delta":
+			return model.Delta{Text: ev.Delta.Text}, true, nil
+		case "input_json_delta":
+			return model.Delta{ToolCall: &model.ToolCallDelta{Index: ev.Index, Arguments: ev.Delta.PartialJSON}}, true, nil
+		}
+	}
+	return d, false, nil
+}
+
+func (s *anthropicStream) FullMessage() (model.Message, error) {
+	for {
+		if _, err := s.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return model.Message{}, err
+		}
+	}
+
+	var text strings.Builder
+	var toolCalls []model.Content
+	for _, b := range s.blocks {
+		switch b.typ {
+		case "text":
+			text.WriteString(b.text.String())
+		case "tool_use":
+			input := map[string]any{}
+			if raw := strings.TrimSpace(b.input.String()); raw != "" {
+				if err := json.Unmarshal([]byte(raw), &input); err != nil {
+					return model.Message{}, fmt.Errorf("parsing input for tool call %s: %w", b.name, err)
+				}
+			}
+			toolCalls = append(toolCalls, model.Content{
+				Type: domain.ContentTypeToolCall,
+				ToolCall: &domain.ToolCall{
+					ID:    b.id,
+					Name:  b.name,
+					Input: input,
+				},
+			})
+		}
+	}
+
+	var content []model.Content
+	if text.Len() > 0 {
+		content = append(content, model.Content{
+			Type: domain.ContentTypeText,
+			Text: text.String(),
+		})
+	}
+	content = append(content, toolCalls...)
+
+	return model.Message{
+		Role:    domain.RoleAssistant,
+		Content: content,
+	}, nil
+}
+
+func (s *anthropicStream) Usage() domain.Usage { return s.usage }
+
+func (s *anthropicStream) Close() error {
+	s.cancel()
+	return s.body.Close()
+}
@@ -0,0 +1,396 @@
+package anthropic_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/model"
+	"github.com/nstogner/operative/pkg/model/anthropic"
+)
+
+// newTestProvider starts an httptest server with the given handler and
+// returns a provider pointed at it.
+func newTestProvider(t *testing.T, handler http.HandlerFunc) *anthropic.Provider {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	p, err := anthropic.New(anthropic.Config{APIKey: "test-key", BaseURL: srv.URL})
+	if err != nil {
+		t.Fatalf("anthropic.New: %v", err)
+	}
+	return p
+}
+
+// writeSSE writes each event as a server-sent event.
+func writeSSE(w http.ResponseWriter, events ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, e := range events {
+		var typ struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(e), &typ)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ.Type, e)
+	}
+}
+
+func textMsg(role domain.Role, text string) model.Message {
+	return model.Message{Role: role, Content: []model.Content{{Type: domain.ContentTypeText, Text: text}}}
+}
+
+func toolCallMsg(id, name string) model.Message {
+	return model.Message{Role: domain.RoleAssistant, Content: []model.Content{{
+		Type:     domain.ContentTypeToolCall,
+		ToolCall: &domain.ToolCall{ID: id, Name: name, Input: map[string]any{"code": "1+1"}},
+	}}}
+}
+
+func toolResultMsg(id, content string) model.Message {
+	return model.Message{Role: domain.RoleTool, Content: []model.Content{{
+		Type:       domain.ContentTypeToolResult,
+		ToolResult: &domain.ToolResult{ToolCallID: id, Content: content},
+	}}}
+}
+
+func TestNewRequiresAPIKey(t *testing.T) {
+	if _, err := anthropic.New(anthropic.Config{}); err == nil {
+		t.Error("expected error without API key")
+	}
+}
+
+func TestList(t *testing.T) {
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") != anthropic.APIVersion {
+			t.Errorf("missing auth headers: %v", r.Header)
+		}
+		if r.URL.Query().Get("after_id") == "" {
+			fmt.Fprint(w, `{"data":[{"id":"claude-a","display_name":"Claude A"}],"has_more":true,"last_id":"claude-a"}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":[{"id":"claude-b","display_name":"Claude B"}],"has_more":false}`)
+	})
+
+	models, err := p.List(context.Background())
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("List len = %d, want 2", len(models))
+	}
+	for _, m := range models {
+		if m.Provider != "anthropic" || m.MaxTokens != anthropic.DefaultContextWindow {
+			t.Errorf("model = %+v", m)
+		}
+	}
+}
+
+func TestStreamTextAndToolUse(t *testing.T) {
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w,
+			`{"type":"message_start","message":{"id":"msg_1","role":"assistant","content":[],"usage":{"input_tokens":10,"cache_read_input_tokens":90,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me "}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"check."}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"run_ipython_cell","input":{}}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"code\": "}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"9*9\"}"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":25}}`,
+			`{"type":"message_stop"}`,
+		)
+	})
+
+	stream, err := p.Stream(context.Background(), "claude-a", "", []model.Message{textMsg(domain.RoleUser, "calc")}, model.DefaultTools)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+
+	msg, err := stream.FullMessage()
+	if err != nil {
+		t.Fatalf("FullMessage: %v", err)
+	}
+	if len(msg.Content) != 2 {
+		t.Fatalf("Content len = %d, want 2: %+v", len(msg.Content), msg.Content)
+	}
+	if msg.Content[0].Text != "Let me check." {
+		t.Errorf("text = %q", msg.Content[0].Text)
+	}
+	tc := msg.Content[1].ToolCall
+	if tc == nil || tc.ID != "toolu_1" || tc.Name != "run_ipython_cell" || tc.Input["code"] != "9*9" {
+		t.Errorf("tool call = %+v", tc)
+	}
+	// Cached prompt tokens count as input.
+	if u := stream.Usage(); u != (domain.Usage{InputTokens: 100, OutputTokens: 25}) {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func TestStreamNext(t *testing.T) {
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w,
+			`{"type":"message_start","message":{"id":"msg_1","role":"assistant","content":[]}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_note","input":{}}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"id\": \"n1\"}"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"message_stop"}`,
+		)
+	})
+
+	stream, err := p.Stream(context.Background(), "claude-a", "", []model.Message{textMsg(domain.RoleUser, "hi")}, nil)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+
+	var deltas []model.Delta
+	for {
+		d, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		deltas = append(deltas, d)
+	}
+
+	if len(deltas) != 3 {
+		t.Fatalf("got %d deltas, want 3: %+v", len(deltas), deltas)
+	}
+	if deltas[0].Text != "Hi" {
+		t.Errorf("deltas[0] = %+v", deltas[0])
+	}
+	if tc := deltas[1].ToolCall; tc == nil || tc.Index != 1 || tc.ID != "toolu_1" || tc.Name != "get_note" {
+		t.Errorf("deltas[1] = %+v", deltas[1].ToolCall)
+	}
+	if tc := deltas[2].ToolCall; tc == nil || tc.Index != 1 || tc.Arguments != `{"id": "n1"}` {
+		t.Errorf("deltas[2] = %+v", deltas[2].ToolCall)
+	}
+
+	// FullMessage after Next has consumed everything still returns the response.
+	msg, err := stream.FullMessage()
+	if err != nil {
+		t.Fatalf("FullMessage: %v", err)
+	}
+	if len(msg.Content) != 2 || msg.Content[1].ToolCall.Input["id"] != "n1" {
+		t.Errorf("Content = %+v", msg.Content)
+	}
+}
+
+func TestStreamErrorEvent(t *testing.T) {
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	})
+
+	stream, err := p.Stream(context.Background(), "claude-a", "", []model.Message{textMsg(domain.RoleUser, "hi")}, nil)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+	if _, err := stream.FullMessage(); err == nil {
+		t.Error("expected error from error event")
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w,
+			`{"type":"message_start","message":{"usage":{"input_tokens":10,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}`,
+		)
+	})
+
+	stream, err := p.Stream(context.Background(), "claude-a", "", []model.Message{textMsg(domain.RoleUser, "hi")}, nil)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+	if _, err := stream.FullMessage(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("FullMessage error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+// captured is the subset of the Messages request inspected by tests.
+type captured struct {
+	MaxTokens int `json:"max_tokens"`
+	System    []struct {
+		Text         string `json:"text"`
+		CacheControl *struct {
+			Type string `json:"type"`
+		} `json:"cache_control"`
+	} `json:"system"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content []struct {
+			Type      string `json:"type"`
+			Text      string `json:"text"`
+			ID        string `json:"id"`
+			ToolUseID string `json:"tool_use_id"`
+			Source    *struct {
+				Type      string `json:"type"`
+				MediaType string `json:"media_type"`
+				Data      string `json:"data"`
+			} `json:"source"`
+		} `json:"content"`
+	} `json:"messages"`
+}
+
+// captureRequest streams msgs through a provider and returns the decoded request.
+func captureRequest(t *testing.T, instructions string, msgs []model.Message) captured {
+	t.Helper()
+	var got captured
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		writeSSE(w, `{"type":"message_stop"}`)
+	})
+	stream, err := p.Stream(context.Background(), "claude-a", instructions, msgs, nil)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+	if _, err := stream.FullMessage(); err != nil {
+		t.Fatalf("FullMessage: %v", err)
+	}
+	return got
+}
+
+func TestStreamRequestMapping(t *testing.T) {
+	got := captureRequest(t, "static instructions", []model.Message{
+		textMsg(domain.RoleCompactionSummary, "earlier work"),
+		textMsg(domain.RoleUser, "calc"),
+		textMsg(domain.RoleAssistant, "Running."),
+		toolCallMsg("toolu_1", "run_ipython_cell"),
+		toolCallMsg("toolu_2", "get_note"),
+		toolResultMsg("toolu_1", "2"),
+		toolResultMsg("toolu_2", "note"),
+	})
+
+	if got.MaxTokens != anthropic.DefaultMaxOutputTokens {
+		t.Errorf("max_tokens = %d, want %d", got.MaxTokens, anthropic.DefaultMaxOutputTokens)
+	}
+	if len(got.System) != 1 || got.System[0].Text != "static instructions" ||
+		got.System[0].CacheControl == nil || got.System[0].CacheControl.Type != "ephemeral" {
+		t.Errorf("system = %+v, want cached static instructions", got.System)
+	}
+
+	// summary + user merge into one user turn; text + 2 tool_use merge into
+	// one assistant turn; both tool results merge into the final user turn.
+	if len(got.Messages) != 3 {
+		t.Fatalf("messages len = %d, want 3: %+v", len(got.Messages), got.Messages)
+	}
+	if got.Messages[0].Role != "user" || len(got.Messages[0].Content) != 2 {
+		t.Errorf("messages[0] = %+v", got.Messages[0])
+	}
+	assistant := got.Messages[1]
+	if assistant.Role != "assistant" || len(assistant.Content) != 3 ||
+		assistant.Content[1].Type != "tool_use" || assistant.Content[2].ID != "toolu_2" {
+		t.Errorf("messages[1] = %+v", assistant)
+	}
+	results := got.Messages[2]
+	if results.Role != "user" || len(results.Content) != 2 ||
+		results.Content[0].ToolUseID != "toolu_1" || results.Content[1].ToolUseID != "toolu_2" {
+		t.Errorf("messages[2] = %+v", results)
+	}
+}
+
+func attachmentMsg(id, mimeType string, data []byte) model.Message {
+	return model.Message{Role: domain.RoleTool, Content: []model.Content{{
+		Type:       domain.ContentTypeAttachment,
+		Attachment: &domain.Attachment{ToolCallID: id, MIMEType: mimeType, Data: data},
+	}}}
+}
+
+func TestStreamImageAttachments(t *testing.T) {
+	got := captureRequest(t, "", []model.Message{
+		textMsg(domain.RoleUser, "plot"),
+		toolCallMsg("toolu_1", "run_ipython_cell"),
+		toolResultMsg("toolu_1", "<Figure>"),
+		attachmentMsg("toolu_1", "image/png", []byte("png-bytes")),
+		attachmentMsg("toolu_1", "text/html", []byte("<table></table>")),
+	})
+
+	if len(got.Messages) != 3 {
+		t.Fatalf("messages len = %d, want 3: %+v", len(got.Messages), got.Messages)
+	}
+	// The image follows the tool result; HTML is not sent.
+	results := got.Messages[2].Content
+	if len(results) != 2 || results[0].Type != "tool_result" || results[1].Type != "image" {
+		t.Fatalf("messages[2] = %+v", results)
+	}
+	src := results[1].Source
+	if src == nil || src.Type != "base64" || src.MediaType != "image/png" ||
+		src.Data != base64.StdEncoding.EncodeToString([]byte("png-bytes")) {
+		t.Errorf("image source = %+v", src)
+	}
+}
+
+func TestStreamUnpairedToolBlocks(t *testing.T) {
+	got := captureRequest(t, "", []model.Message{
+		// A result whose call was compacted away.
+		toolResultMsg("toolu_gone", "orphaned"),
+		textMsg(domain.RoleUser, "next"),
+		// A call that never received a result.
+		toolCallMsg("toolu_open", "run_ipython_cell"),
+		textMsg(domain.RoleUser, "continue"),
+	})
+
+	for _, m := range got.Messages {
+		for _, c := range m.Content {
+			if c.Type != "text" {
+				t.Errorf("unpaired block %q survived in %s turn", c.Type, m.Role)
+			}
+		}
+	}
+	if len(got.Messages) != 3 || got.Messages[0].Role != "user" {
+		t.Errorf("messages = %+v", got.Messages)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	var got struct {
+		Model    string            `json:"model"`
+		System   []json.RawMessage `json:"system"`
+		Messages []json.RawMessage `json:"messages"`
+		Tools    []json.RawMessage `json:"tools"`
+		Stream   *bool             `json:"stream"`
+	}
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages/count_tokens" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		fmt.Fprint(w, `{"input_tokens":1234}`)
+	})
+
+	n, err := p.CountTokens(context.Background(), "claude-a", "be helpful", []model.Message{textMsg(domain.RoleUser, "hi")}, model.DefaultTools)
+	if err != nil {
+		t.Fatalf("CountTokens: %v", err)
+	}
+	if n != 1234 {
+		t.Errorf("CountTokens = %d, want 1234", n)
+	}
+	if got.Model != "claude-a" || len(got.System) != 1 || len(got.Messages) != 1 || len(got.Tools) != len(model.DefaultTools) {
+		t.Errorf("request = %+v", got)
+	}
+	if got.Stream != nil {
+		t.Error("count request must not set stream")
+	}
+}
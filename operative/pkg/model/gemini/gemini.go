@@ -2,7 +2,10 @@ package gemini
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"iter"
 	"log/slog"
 	"strings"
 
@@ -70,32 +73,71 @@ func (p *Provider) List(ctx context.Context) ([]domain.Model, error) {
 }
 
 // Stream sends a conversation context to the LLM and returns a stream.
-func (p *Provider) Stream(ctx context.Context, modelName, instructions string, messages []model.Message) (model.ModelStream, error) {
+func (p *Provider) Stream(ctx context.Context, modelName, instructions string, messages []model.Message, tools []model.Tool) (model.ModelStream, error) {
 	slog.Debug("Gemini.Stream", "model", modelName, "messageCount", len(messages))
 
-	// Build tool declarations from the tools provided.
-	tools := buildToolDeclarations()
+	contents := buildContents(messages)
 
-	// Convert messages to genai.Content.
-	var contents []*genai.Content
 	var systemInstruction *genai.Content
-	toolNameMap := make(map[string]string) // tool call ID -> name
-
 	if instructions != "" {
 		systemInstruction = &genai.Content{
 			Parts: []*genai.Part{{Text: instructions}},
 		}
 	}
 
+	config := &genai.GenerateContentConfig{
+		SystemInstruction: systemInstruction,
+	}
+	if len(tools) > 0 {
+		config.Tools = buildToolDeclarations(tools)
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	seq := p.client.Models.GenerateContentStream(streamCtx, modelName, contents, config)
+
+	return newGeminiStream(seq, cancel), nil
+}
+
+// CountTokens counts the prompt tokens of a request with the countTokens
+// API. The Gemini API does not accept a system instruction or tools there, so
+// they are counted as text parts of the first turn instead.
+func (p *Provider) CountTokens(ctx context.Context, modelName, instructions string, messages []model.Message, tools []model.Tool) (int, error) {
+	var preamble []*genai.Part
+	if instructions != "" {
+		preamble = append(preamble, &genai.Part{Text: instructions})
+	}
+	if len(tools) > 0 {
+		decls, err := json.Marshal(buildToolDeclarations(tools))
+		if err != nil {
+			return 0, fmt.Errorf("encoding tool declarations: %w", err)
+		}
+		preamble = append(preamble, &genai.Part{Text: string(decls)})
+	}
+	contents := buildContents(messages)
+	if len(preamble) > 0 {
+		contents = append([]*genai.Content{{Role: "user", Parts: preamble}}, contents...)
+	}
+
+	resp, err := p.client.Models.CountTokens(ctx, modelName, contents, nil)
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.TotalTokens), nil
+}
+
+// buildContents converts messages to Gemini contents. Compaction summaries
+// are treated as model turns.
+func buildContents(messages []model.Message) []*genai.Content {
+	var contents []*genai.Content
+	toolNameMap := make(map[string]string) // tool call ID -> name
+
 	for _, msg := range messages {
-		if msg.Role == domain.RoleSystem || msg.Role == domain.RoleCompactionSummary {
-			// System role is handled via instructions; compaction summaries are treated as assistant context.
-			if msg.Role == domain.RoleCompactionSummary {
-				contents = append(contents, &genai.Content{
-					Role:  "model",
-					Parts: []*genai.Part{{Text: msg.Content[0].Text}},
-				})
-			}
+		if msg.Role == domain.RoleCompactionSummary {
+			// Compaction summaries are treated as assistant context.
+			contents = append(contents, &genai.Content{
+				Role:  "model",
+				Parts: []*genai.Part{{Text: msg.Content[0].Text}},
+			})
 			continue
 		}
 
@@ -135,6 +177,15 @@ func (p *Provider) Stream(ctx context.Context, modelName, instructions string, m
 						},
 					})
 				}
+			case domain.ContentTypeAttachment:
+				if c.Attachment != nil && model.IsImage(c.Attachment.MIMEType) {
+					parts = append(parts, &genai.Part{
+						InlineData: &genai.Blob{
+							MIMEType: c.Attachment.MIMEType,
+							Data:     c.Attachment.Data,
+						},
+					})
+				}
 			}
 		}
 
@@ -145,7 +196,11 @@ func (p *Provider) Stream(ctx context.Context, modelName, instructions string, m
 			role = "user"
 		}
 
-		if len(parts) > 0 {
+		// Attachments are stored as entries of their own; keep them in the
+		// same turn as the function responses they belong to.
+		if n := len(contents); n > 0 && len(parts) > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+		} else if len(parts) > 0 {
 			contents = append(contents, &genai.Content{
 				Role:  role,
 				Parts: parts,
@@ -153,165 +208,149 @@ func (p *Provider) Stream(ctx context.Context, modelName, instructions string, m
 		}
 	}
 
-	config := &genai.GenerateContentConfig{
-		Tools:             tools,
-		SystemInstruction: systemInstruction,
-	}
-
-	streamCtx, cancel := context.WithCancel(ctx)
-	iter := p.client.Models.GenerateContentStream(streamCtx, modelName, contents, config)
+	return contents
+}
 
-	return &geminiStream{
-		iter:   iter,
-		cancel: cancel,
-	}, nil
+// buildToolDeclarations converts the provider-neutral tool list into
+// Gemini function declarations.
+func buildToolDeclarations(tools []model.Tool) []*genai.Tool {
+	var decls []*genai.FunctionDeclaration
+	for _, t := range tools {
+		decls = append(decls, &genai.FunctionDeclaration{
+			Name:        t.Name,
+			Description: t.Description,
+			Parameters:  toGenaiSchema(t.Parameters),
+		})
+	}
+	return []*genai.Tool{{FunctionDeclarations: decls}}
 }
 
-func buildToolDeclarations() []*genai.Tool {
-	return []*genai.Tool{
-		{
-			FunctionDeclarations: []*genai.FunctionDeclaration{
-				{
-					Name:        "run_ipython_cell",
-					Description: "Run a cell of code in the IPython kernel. Returns the result.",
-					Parameters: &genai.Schema{
-						Type: genai.TypeObject,
-						Properties: map[string]*genai.Schema{
-							"code": {Type: genai.TypeString, Description: "The code to run."},
-						},
-						Required: []string{"code"},
-					},
-				},
-				{
-					Name:        "update_instructions",
-					Description: "Update the operative's self-set instructions.",
-					Parameters: &genai.Schema{
-						Type: genai.TypeObject,
-						Properties: map[string]*genai.Schema{
-							"instructions": {Type: genai.TypeString, Description: "The new instructions."},
-						},
-						Required: []string{"instructions"},
-					},
-				},
-				{
-					Name:        "store_note",
-					Description: "Store a searchable note.",
-					Parameters: &genai.Schema{
-						Type: genai.TypeObject,
-						Properties: map[string]*genai.Schema{
-							"title":   {Type: genai.TypeString, Description: "The note title."},
-							"content": {Type: genai.TypeString, Description: "The note content."},
-						},
-						Required: []string{"title", "content"},
-					},
-				},
-				{
-					Name:        "keyword_search_notes",
-					Description: "Search notes by keyword.",
-					Parameters: &genai.Schema{
-						Type: genai.TypeObject,
-						Properties: map[string]*genai.Schema{
-							"query": {Type: genai.TypeString, Description: "The search query."},
-						},
-						Required: []string{"query"},
-					},
-				},
-				{
-					Name:        "vector_search_notes",
-					Description: "Search notes by semantic similarity (vector search).",
-					Parameters: &genai.Schema{
-						Type: genai.TypeObject,
-						Properties: map[string]*genai.Schema{
-							"query": {Type: genai.TypeString, Description: "The search query."},
-						},
-						Required: []string{"query"},
-					},
-				},
-				{
-					Name:        "get_note",
-					Description: "Retrieve a note by its ID.",
-					Parameters: &genai.Schema{
-						Type: genai.TypeObject,
-						Properties: map[string]*genai.Schema{
-							"id": {Type: genai.TypeString, Description: "The note ID."},
-						},
-						Required: []string{"id"},
-					},
-				},
-				{
-					Name:        "delete_note",
-					Description: "Delete a note by its ID.",
-					Parameters: &genai.Schema{
-						Type: genai.TypeObject,
-						Properties: map[string]*genai.Schema{
-							"id": {Type: genai.TypeString, Description: "The note ID."},
-						},
-						Required: []string{"id"},
-					},
-				},
-			},
-		},
+// toGenaiSchema converts a JSON Schema subset into the Gemini schema type.
+func toGenaiSchema(s *model.Schema) *genai.Schema {
+	if s == nil {
+		return nil
+	}
+	out := &genai.Schema{
+		Type:        genai.Type(strings.ToUpper(s.Type)),
+		Description: s.Description,
+		Required:    s.Required,
 	}
+	if len(s.Properties) > 0 {
+		out.Properties = make(map[string]*genai.Schema, len(s.Properties))
+		for name, prop := range s.Properties {
+			out.Properties[name] = toGenaiSchema(prop)
+		}
+	}
+	return out
 }
 
 // geminiStream wraps the Gemini streaming iterator.
 type geminiStream struct {
-	iter   func(yield func(*genai.GenerateContentResponse, error) bool)
+	next   func() (*genai.GenerateContentResponse, error, bool)
+	stop   func()
 	cancel context.CancelFunc
+
+	// pending holds deltas decoded from the last response chunk but not yet
+	// returned by Next.
+	pending []model.Delta
+
+	text          strings.Builder
+	textSignature []byte
+	toolCalls     []model.Content
+	usage         domain.Usage
 }
 
-func (s *geminiStream) FullMessage() (model.Message, error) {
-	var fullText strings.Builder
-	var toolCalls []model.Content
-	var textSignature []byte
+func newGeminiStream(seq iter.Seq2[*genai.GenerateContentResponse, error], cancel context.CancelFunc) *geminiStream {
+	next, stop := iter.Pull2(seq)
+	return &geminiStream{next: next, stop: stop, cancel: cancel}
+}
 
-	for resp, err := range s.iter {
+func (s *geminiStream) Next() (model.Delta, error) {
+	for len(s.pending) == 0 {
+		resp, err, ok := s.next()
+		if !ok {
+			return model.Delta{}, io.EOF
+		}
 		if err != nil {
-			return model.Message{}, err
+			return model.Delta{}, err
 		}
-		if resp == nil {
-			continue
+		if resp != nil {
+			s.accumulate(resp)
 		}
+	}
+	d := s.pending[0]
+	s.pending = s.pending[1:]
+	return d, nil
+}
 
-		for _, cand := range resp.Candidates {
-			if cand.Content != nil {
-				for _, part := range cand.Content.Parts {
-					if part.Text != "" {
-						if len(part.ThoughtSignature) > 0 {
-							textSignature = part.ThoughtSignature
-						}
-						fullText.WriteString(part.Text)
-					}
-					if part.FunctionCall != nil {
-						fc := part.FunctionCall
-						id := fc.ID
-						if id == "" {
-							id = "call-" + uuid.New().String()
-						}
-						toolCalls = append(toolCalls, model.Content{
-							Type: domain.ContentTypeToolCall,
-							ToolCall: &domain.ToolCall{
-								ID:    id,
-								Name:  fc.Name,
-								Input: fc.Args,
-							},
-							ThoughtSignature: part.ThoughtSignature,
-						})
-					}
+// accumulate records the parts of a response chunk and queues their deltas.
+// Gemini delivers each function call whole, so a tool call is a single delta.
+func (s *geminiStream) accumulate(resp *genai.GenerateContentResponse) {
+	// Usage metadata is cumulative; the last chunk has the final counts.
+	// Thinking tokens are part of the response.
+	if u := resp.UsageMetadata; u != nil {
+		s.usage = domain.Usage{
+			InputTokens:  int(u.PromptTokenCount),
+			OutputTokens: int(u.CandidatesTokenCount + u.ThoughtsTokenCount),
+		}
+	}
+	for _, cand := range resp.Candidates {
+		if cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			if part.Text != "" {
+				if len(part.ThoughtSignature) > 0 {
+					s.textSignature = part.ThoughtSignature
 				}
+				s.text.WriteString(part.Text)
+				s.pending = append(s.pending, model.Delta{Text: part.Text})
 			}
+			if part.FunctionCall != nil {
+				fc := part.FunctionCall
+				id := fc.ID
+				if id == "" {
+					id = "call-" + uuid.New().String()
+				}
+				args, _ := json.Marshal(fc.Args)
+				s.pending = append(s.pending, model.Delta{ToolCall: &model.ToolCallDelta{
+					Index:     len(s.toolCalls),
+					ID:        id,
+					Name:      fc.Name,
+					Arguments: string(args),
+				}})
+				s.toolCalls = append(s.toolCalls, model.Content{
+					Type: domain.ContentTypeToolCall,
+					ToolCall: &domain.ToolCall{
+						ID:    id,
+						Name:  fc.Name,
+						Input: fc.Args,
+					},
+					ThoughtSignature: part.ThoughtSignature,
+				})
+			}
+		}
+	}
+}
+
+func (s *geminiStream) FullMessage() (model.Message, error) {
+	for {
+		if _, err := s.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return model.Message{}, err
 		}
 	}
 
 	var content []model.Content
-	if fullText.Len() > 0 {
+	if s.text.Len() > 0 {
 		content = append(content, model.Content{
 			Type:             domain.ContentTypeText,
-			Text:             fullText.String(),
-			ThoughtSignature: textSignature,
+			Text:             s.text.String(),
+			ThoughtSignature: s.textSignature,
 		})
 	}
-	content = append(content, toolCalls...)
+	content = append(content, s.toolCalls...)
 
 	return model.Message{
 		Role:    domain.RoleAssistant,
@@ -319,7 +358,10 @@ func (s *geminiStream) FullMessage() (model.Message, error) {
 	}, nil
 }
 
+func (s *geminiStream) Usage() domain.Usage { return s.usage }
+
 func (s *geminiStream) Close() error {
+	s.stop()
 	s.cancel()
 	return nil
 }
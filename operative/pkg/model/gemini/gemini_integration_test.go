@@ -80,7 +80,7 @@ func TestIntegrationGeminiStreamBasic(t *testing.T) {
 		},
 	}
 
-	stream, err := p.Stream(ctx, "gemini-2.0-flash", "", msgs)
+	stream, err := p.Stream(ctx, "gemini-2.0-flash", "", msgs, nil)
 	if err != nil {
 		t.Fatalf("Stream: %v", err)
 	}
@@ -124,7 +124,7 @@ func TestIntegrationGeminiStreamWithSystemInstruction(t *testing.T) {
 	}
 
 	instructions := "You are a helpful assistant named TestBot. Always introduce yourself by name."
-	stream, err := p.Stream(ctx, "gemini-2.0-flash", instructions, msgs)
+	stream, err := p.Stream(ctx, "gemini-2.0-flash", instructions, msgs, nil)
 	if err != nil {
 		t.Fatalf("Stream: %v", err)
 	}
@@ -158,7 +158,7 @@ func TestIntegrationGeminiStreamToolCall(t *testing.T) {
 		},
 	}
 
-	stream, err := p.Stream(ctx, "gemini-2.0-flash", "Use the run_ipython_cell tool to execute code when asked to calculate.", msgs)
+	stream, err := p.Stream(ctx, "gemini-2.0-flash", "Use the run_ipython_cell tool to execute code when asked to calculate.", msgs, model.DefaultTools)
 	if err != nil {
 		t.Fatalf("Stream: %v", err)
 	}
@@ -211,7 +211,7 @@ func TestIntegrationGeminiMultiTurn(t *testing.T) {
 		},
 	}
 
-	stream, err := p.Stream(ctx, "gemini-2.0-flash", "", msgs)
+	stream, err := p.Stream(ctx, "gemini-2.0-flash", "", msgs, nil)
 	if err != nil {
 		t.Fatalf("Stream: %v", err)
 	}
@@ -228,3 +228,43 @@ func TestIntegrationGeminiMultiTurn(t *testing.T) {
 	}
 	t.Logf("Response: %s", text)
 }
+
+// TestIntegrationGeminiCountTokens verifies that CountTokens includes the
+// system instruction and tool declarations, and that responses report usage.
+func TestIntegrationGeminiCountTokens(t *testing.T) {
+	p := setupProvider(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	msgs := []model.Message{
+		{
+			Role:    domain.RoleUser,
+			Content: []model.Content{{Type: domain.ContentTypeText, Text: "Say hello."}},
+		},
+	}
+
+	bare, err := p.CountTokens(ctx, "gemini-2.0-flash", "", msgs, nil)
+	if err != nil {
+		t.Fatalf("CountTokens: %v", err)
+	}
+	full, err := p.CountTokens(ctx, "gemini-2.0-flash", "You are a helpful assistant.", msgs, model.DefaultTools)
+	if err != nil {
+		t.Fatalf("CountTokens: %v", err)
+	}
+	if bare <= 0 || full <= bare {
+		t.Errorf("CountTokens = %d without and %d with instructions and tools", bare, full)
+	}
+
+	stream, err := p.Stream(ctx, "gemini-2.0-flash", "", msgs, nil)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+	if _, err := stream.FullMessage(); err != nil {
+		t.Fatalf("FullMessage: %v", err)
+	}
+	if u := stream.Usage(); u.InputTokens <= 0 || u.OutputTokens <= 0 {
+		t.Errorf("Usage = %+v", u)
+	}
+}
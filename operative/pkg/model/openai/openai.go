@@ -0,0 +1,589 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/model"
+)
+
+// DefaultBaseURL is the OpenAI API endpoint used when no base URL is configured.
+const DefaultBaseURL = "https://api.openai.com/v1"
+
+// Config configures an OpenAI-compatible provider.
+type Config struct {
+	// Name is the provider identifier (defaults to "openai"). Set this when
+	// talking to a self-hosted server (e.g. "vllm", "ollama").
+	Name string
+	// BaseURL is the API root including the version prefix
+	// (e.g. "http://localhost:8000/v1"). Defaults to DefaultBaseURL.
+	BaseURL string
+	// APIKey is sent as a bearer token. May be empty for local servers.
+	APIKey string
+	// DefaultMaxTokens is reported as the context window for models whose
+	// listing does not include one. Zero means unknown.
+	DefaultMaxTokens int
+	// HTTPClient is used for all requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Provider implements model.Provider against the OpenAI Chat Completions API.
+// It works with OpenAI itself and with compatible servers such as vLLM,
+// llama.cpp and Ollama.
+type Provider struct {
+	name             string
+	baseURL          string
+	apiKey           string
+	defaultMaxTokens int
+	client           *http.Client
+
+	// noStreamUsage is set once the endpoint rejected stream_options.
+	noStreamUsage atomic.Bool
+}
+
+// Verify interface compliance.
+var _ model.Provider = (*Provider)(nil)
+
+// New creates a new OpenAI-compatible provider.
+func New(cfg Config) (*Provider, error) {
+	p := &Provider{
+		name:             cfg.Name,
+		baseURL:          strings.TrimRight(cfg.BaseURL, "/"),
+		apiKey:           cfg.APIKey,
+		defaultMaxTokens: cfg.DefaultMaxTokens,
+		client:           cfg.HTTPClient,
+	}
+	if p.name == "" {
+		p.name = "openai"
+	}
+	if p.baseURL == "" {
+		p.baseURL = DefaultBaseURL
+	}
+	if !strings.HasPrefix(p.baseURL, "http://") && !strings.HasPrefix(p.baseURL, "https://") {
+		return nil, fmt.Errorf("invalid base URL %q: must start with http:// or https://", cfg.BaseURL)
+	}
+	if p.client == nil {
+		p.client = http.DefaultClient
+	}
+	return p, nil
+}
+
+// Name returns the provider identifier.
+func (p *Provider) Name() string { return p.name }
+
+// List returns the models served by the endpoint.
+func (p *Provider) List(ctx context.Context) ([]domain.Model, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data []struct {
+			ID string `json:"id"`
+			// Context window fields reported by various compatible servers.
+			MaxModelLen   int `json:"max_model_len"`  // vLLM
+			ContextLength int `json:"context_length"` // OpenRouter, LM Studio
+			ContextWindow int `json:"context_window"` // Groq and others
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding model list: %w", err)
+	}
+
+	var models []domain.Model
+	for _, m := range body.Data {
+		maxTokens := p.defaultMaxTokens
+		switch {
+		case m.MaxModelLen > 0:
+			maxTokens = m.MaxModelLen
+		case m.ContextLength > 0:
+			maxTokens = m.ContextLength
+		case m.ContextWindow > 0:
+			maxTokens = m.ContextWindow
+		}
+		models = append(models, domain.Model{
+			ID:        m.ID,
+			Name:      m.ID,
+			Provider:  p.name,
+			MaxTokens: maxTokens,
+		})
+	}
+	return models, nil
+}
+
+// Stream sends a conversation context to the LLM and returns a stream.
+func (p *Provider) Stream(ctx context.Context, modelName, instructions string, messages []model.Message, tools []model.Tool) (model.ModelStream, error) {
+	slog.Debug("OpenAI.Stream", "provider", p.name, "model", modelName, "messageCount", len(messages))
+
+	req := chatRequest{
+		Model:    modelName,
+		Messages: buildMessages(instructions, messages),
+		Tools:    buildTools(tools),
+		Stream:   true,
+	}
+	if !p.noStreamUsage.Load() {
+		// Ask for a final chunk with the token usage of the request.
+		req.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	resp, err := p.postChat(streamCtx, req)
+	var apiErr *apiError
+	if req.StreamOptions != nil && errors.As(err, &apiErr) && apiErr.code == http.StatusBadRequest && strings.Contains(apiErr.body, "stream_options") {
+		// Some compatible servers reject stream_options; usage is then
+		// estimated by the caller.
+		slog.Info("Endpoint does not support stream_options, retrying without", "provider", p.name)
+		p.noStreamUsage.Store(true)
+		req.StreamOptions = nil
+		resp, err = p.postChat(streamCtx, req)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return newOpenAIStream(resp.Body, cancel), nil
+}
+
+// postChat sends a streaming Chat Completions request.
+func (p *Provider) postChat(ctx context.Context, chat chatRequest) (*http.Response, error) {
+	body, err := json.Marshal(chat)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	return p.do(req)
+}
+
+// CountTokens estimates the prompt tokens of a request. The Chat Completions
+// API has no token counting endpoint.
+func (p *Provider) CountTokens(ctx context.Context, modelName, instructions string, messages []model.Message, tools []model.Tool) (int, error) {
+	return model.EstimateTokens(instructions, messages, tools), nil
+}
+
+// do sends an authenticated request and converts non-2xx responses to errors.
+func (p *Provider) do(req *http.Request) (*http.Response, error) {
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &apiError{
+			method: req.Method,
+			path:   req.URL.Path,
+			status: resp.Status,
+			code:   resp.StatusCode,
+			body:   strings.TrimSpace(string(msg)),
+		}
+	}
+	return resp, nil
+}
+
+// apiError is a non-2xx response.
+type apiError struct {
+	method, path, status string
+	code                 int
+	body                 string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", e.method, e.path, e.status, e.body)
+}
+
+// --- wire types ---
+
+type chatRequest struct {
+	Model         string         `json:"model"`
+	Messages      []chatMessage  `json:"messages"`
+	Tools         []chatTool     `json:"tools,omitempty"`
+	Stream        bool           `json:"stream"`
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatMessage struct {
+	Role       string         `json:"role"`
+	Content    *string        `json:"content"`
+	ToolCalls  []chatToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+
+	// Parts replaces Content with an array of content parts when set.
+	Parts []chatContentPart `json:"-"`
+}
+
+// MarshalJSON encodes Parts, if any, as the message content.
+func (m chatMessage) MarshalJSON() ([]byte, error) {
+	type plain chatMessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Content []chatContentPart `json:"content"`
+	}{plain(m), m.Parts})
+}
+
+type chatContentPart struct {
+	Type     string        `json:"type"` // "text", "image_url"
+	Text     string        `json:"text,omitempty"`
+	ImageURL *chatImageURL `json:"image_url,omitempty"`
+}
+
+type chatImageURL struct {
+	URL string `json:"url"`
+}
+
+type chatTool struct {
+	Type     string       `json:"type"`
+	Function chatFunction `json:"function"`
+}
+
+type chatFunction struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Parameters  *model.Schema `json:"parameters,omitempty"`
+}
+
+type chatToolCall struct {
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function chatFunctionCall `json:"function"`
+}
+
+type chatFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// chatToolCallDelta is a fragment of a tool call in a streamed chunk.
+// Fragments with the same index belong to the same call.
+type chatToolCallDelta struct {
+	Index    int              `json:"index"`
+	ID       string           `json:"id,omitempty"`
+	Function chatFunctionCall `json:"function"`
+}
+
+type chatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string              `json:"content"`
+			ToolCalls []chatToolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	// Usage is set on the last chunk, which has no choices.
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage,omitempty"`
+}
+
+func buildTools(tools []model.Tool) []chatTool {
+	var out []chatTool
+	for _, t := range tools {
+		out = append(out, chatTool{
+			Type: "function",
+			Function: chatFunction{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  t.Parameters,
+			},
+		})
+	}
+	return out
+}
+
+// buildMessages converts model messages into Chat Completions messages.
+// Consecutive assistant messages are merged because the controller stores
+// each part of a model response (text, tool calls) as a separate entry,
+// while the API expects them in a single assistant message.
+//
+// Tool messages can only hold text, so image attachments are sent in a user
+// message after the tool messages of the turn.
+func buildMessages(instructions string, messages []model.Message) []chatMessage {
+	var out []chatMessage
+	if instructions != "" {
+		out = append(out, chatMessage{Role: "system", Content: &instructions})
+	}
+
+	appendAssistant := func(text string, calls []chatToolCall) {
+		if n := len(out); n > 0 && out[n-1].Role == "assistant" {
+			last := &out[n-1]
+			if text != "" {
+				if last.Content != nil && *last.Content != "" {
+					text = *last.Content + "\n\n" + text
+				}
+				last.Content = &text
+			}
+			last.ToolCalls = append(last.ToolCalls, calls...)
+			return
+		}
+		msg := chatMessage{Role: "assistant", ToolCalls: calls}
+		if text != "" {
+			msg.Content = &text
+		}
+		out = append(out, msg)
+	}
+
+	var images []chatContentPart
+	flushImages := func() {
+		if len(images) == 0 {
+			return
+		}
+		parts := append([]chatContentPart{{Type: "text", Text: "Images output by the tool calls above:"}}, images...)
+		out = append(out, chatMessage{Role: "user", Parts: parts})
+		images = nil
+	}
+
+	for _, msg := range messages {
+		if msg.Role != domain.RoleTool {
+			flushImages()
+		}
+		switch msg.Role {
+		case domain.RoleCompactionSummary:
+			// The summary starts the compacted view; some servers require
+			// the conversation to start with a user message.
+			if len(msg.Content) > 0 {
+				content := "Summary of the earlier conversation:\n\n" + msg.Content[0].Text
+				out = append(out, chatMessage{Role: "user", Content: &content})
+			}
+
+		case domain.RoleAssistant:
+			var text strings.Builder
+			var calls []chatToolCall
+			for _, c := range msg.Content {
+				switch c.Type {
+				case domain.ContentTypeText:
+					text.WriteString(c.Text)
+				case domain.ContentTypeToolCall:
+					if c.ToolCall == nil {
+						continue
+					}
+					args, _ := json.Marshal(c.ToolCall.Input)
+					calls = append(calls, chatToolCall{
+						ID:   c.ToolCall.ID,
+						Type: "function",
+						Function: chatFunctionCall{
+							Name:      c.ToolCall.Name,
+							Arguments: string(args),
+						},
+					})
+				}
+			}
+			appendAssistant(text.String(), calls)
+
+		case domain.RoleTool:
+			for _, c := range msg.Content {
+				switch {
+				case c.Type == domain.ContentTypeToolResult && c.ToolResult != nil:
+					content := c.ToolResult.Content
+					out = append(out, chatMessage{
+						Role:       "tool",
+						Content:    &content,
+						ToolCallID: c.ToolResult.ToolCallID,
+					})
+				case c.Type == domain.ContentTypeAttachment && c.Attachment != nil && model.IsImage(c.Attachment.MIMEType):
+					images = append(images, chatContentPart{
+						Type: "image_url",
+						ImageURL: &chatImageURL{
+							URL: "data:" + c.Attachment.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(c.Attachment.Data),
+						},
+					})
+				}
+			}
+
+		default:
+			var text strings.Builder
+			for _, c := range msg.Content {
+				if c.Type == domain.ContentTypeText {
+					text.WriteString(c.Text)
+				}
+			}
+			content := text.String()
+			out = append(out, chatMessage{Role: "user", Content: &content})
+		}
+	}
+	flushImages()
+	return out
+}
+
+// openaiStream reads a server-sent event stream of chat completion chunks.
+type openaiStream struct {
+	body    io.ReadCloser
+	cancel  context.CancelFunc
+	scanner *bufio.Scanner
+
+	// pending holds deltas decoded from the last chunk but not yet returned by Next.
+	pending []model.Delta
+	done    bool
+
+	text  strings.Builder
+	calls []*chatToolCall // indexed by the chunk's tool call index
+	usage domain.Usage
+}
+
+func newOpenAIStream(body io.ReadCloser, cancel context.CancelFunc) *openaiStream {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	return &openaiStream{body: body, cancel: cancel, scanner: scanner}
+}
+
+func (s *openaiStream) Next() (model.Delta, error) {
+	for len(s.pending) == 0 {
+		if s.done {
+			return model.Delta{}, io.EOF
+		}
+		if err := s.readChunk(); err != nil {
+			return model.Delta{}, err
+		}
+	}
+	d := s.pending[0]
+	s.pending = s.pending[1:]
+	return d, nil
+}
+
+// readChunk reads the next chunk from the stream, accumulating its content
+// and queueing its deltas.
+func (s *openaiStream) readChunk() error {
+	for s.scanner.Scan() {
+		data, ok := strings.CutPrefix(s.scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			s.done = true
+			return nil
+		}
+
+		var chunk chatChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("decoding stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("stream error: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			s.usage = domain.Usage{
+				InputTokens:  chunk.Usage.PromptTokens,
+				OutputTokens: chunk.Usage.CompletionTokens,
+			}
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				s.text.WriteString(choice.Delta.Content)
+				s.pending = append(s.pending, model.Delta{Text: choice.Delta.Content})
+			}
+			for _, tc := range choice.Delta.ToolCalls {
+				for len(s.calls) <= tc.Index {
+					s.calls = append(s.calls, &chatToolCall{})
+				}
+				call := s.calls[tc.Index]
+				if tc.ID != "" {
+					call.ID = tc.ID
+				}
+				call.Function.Name += tc.Function.Name
+				call.Function.Arguments += tc.Function.Arguments
+				s.pending = append(s.pending, model.Delta{ToolCall: &model.ToolCallDelta{
+					Index:     tc.Index,
+					ID:        tc.ID,
+					Name:      tc.Function.Name,
+					Arguments: tc.Function.Arguments,
+				}})
+			}
+		}
+		return nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return err
+	}
+	s.done = true
+	return nil
+}
+
+func (s *openaiStream) FullMessage() (model.Message, error) {
+	for {
+		if _, err := s.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return model.Message{}, err
+		}
+	}
+
+	var content []model.Content
+	if s.text.Len() > 0 {
+		content = append(content, model.Content{
+			Type: domain.ContentTypeText,
+			Text: s.text.String(),
+		})
+	}
+	for _, call := range s.calls {
+		if call.Function.Name == "" {
+			continue
+		}
+		input := map[string]any{}
+		if strings.TrimSpace(call.Function.Arguments) != "" {
+			if err := json.Unmarshal([]byte(call.Function.Arguments), &input); err != nil {
+				return model.Message{}, fmt.Errorf("parsing arguments for tool call %s: %w", call.Function.Name, err)
+			}
+		}
+		id := call.ID
+		if id == "" {
+			id = "call-" + uuid.New().String()
+		}
+		content = append(content, model.Content{
+			Type: domain.ContentTypeToolCall,
+			ToolCall: &domain.ToolCall{
+				ID:    id,
+				Name:  call.Function.Name,
+				Input: input,
+			},
+		})
+	}
+
+	return model.Message{
+		Role:    domain.RoleAssistant,
+		Content: content,
+	}, nil
+}
+
+func (s *openaiStream) Usage() domain.Usage { return s.usage }
+
+func (s *openaiStream) Close() error {
+	s.cancel()
+	return s.body.Close()
+}
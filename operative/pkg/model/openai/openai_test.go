@@ -0,0 +1,407 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/model"
+	"github.com/nstogner/operative/pkg/model/openai"
+)
+
+// newTestProvider starts an httptest server with the given handler and
+// returns a provider pointed at it.
+func newTestProvider(t *testing.T, handler http.HandlerFunc) *openai.Provider {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	p, err := openai.New(openai.Config{BaseURL: srv.URL + "/v1", APIKey: "test-key"})
+	if err != nil {
+		t.Fatalf("openai.New: %v", err)
+	}
+	return p
+}
+
+// writeSSE writes each chunk as a server-sent event followed by [DONE].
+func writeSSE(w http.ResponseWriter, chunks ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, c := range chunks {
+		fmt.Fprintf(w, "data: %s\n\n", c)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func TestName(t *testing.T) {
+	p, _ := openai.New(openai.Config{})
+	if p.Name() != "openai" {
+		t.Errorf("Name() = %q, want %q", p.Name(), "openai")
+	}
+	p, _ = openai.New(openai.Config{Name: "vllm", BaseURL: "http://localhost:8000/v1"})
+	if p.Name() != "vllm" {
+		t.Errorf("Name() = %q, want %q", p.Name(), "vllm")
+	}
+	if _, err := openai.New(openai.Config{BaseURL: "localhost:8000"}); err == nil {
+		t.Error("expected error for base URL without scheme")
+	}
+}
+
+func TestList(t *testing.T) {
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			t.Errorf("path = %q, want /v1/models", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer test-key")
+		}
+		fmt.Fprint(w, `{"data":[{"id":"gpt-4o"},{"id":"qwen","max_model_len":32768}]}`)
+	})
+
+	models, err := p.List(context.Background())
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("List len = %d, want 2", len(models))
+	}
+	if models[0].ID != "gpt-4o" || models[0].Provider != "openai" || models[0].MaxTokens != 0 {
+		t.Errorf("models[0] = %+v", models[0])
+	}
+	if models[1].MaxTokens != 32768 {
+		t.Errorf("models[1].MaxTokens = %d, want 32768", models[1].MaxTokens)
+	}
+}
+
+func TestStreamText(t *testing.T) {
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w,
+			`{"choices":[{"delta":{"role":"assistant","content":"Hel"}}]}`,
+			`{"choices":[{"delta":{"content":"lo"}}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":2}}`,
+		)
+	})
+
+	stream, err := p.Stream(context.Background(), "gpt-4o", "", []model.Message{
+		{Role: domain.RoleUser, Content: []model.Content{{Type: domain.ContentTypeText, Text: "Hi"}}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+
+	msg, err := stream.FullMessage()
+	if err != nil {
+		t.Fatalf("FullMessage: %v", err)
+	}
+	if msg.Role != domain.RoleAssistant {
+		t.Errorf("Role = %q, want %q", msg.Role, domain.RoleAssistant)
+	}
+	if len(msg.Content) != 1 || msg.Content[0].Text != "Hello" {
+		t.Errorf("Content = %+v, want single text %q", msg.Content, "Hello")
+	}
+	if u := stream.Usage(); u != (domain.Usage{InputTokens: 12, OutputTokens: 2}) {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func TestStreamToolCall(t *testing.T) {
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"run_ipython_cell","arguments":""}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"code\":"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"9*9\"}"}}]}}]}`,
+		)
+	})
+
+	stream, err := p.Stream(context.Background(), "gpt-4o", "", []model.Message{
+		{Role: domain.RoleUser, Content: []model.Content{{Type: domain.ContentTypeText, Text: "calc"}}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+
+	msg, err := stream.FullMessage()
+	if err != nil {
+		t.Fatalf("FullMessage: %v", err)
+	}
+	if len(msg.Content) != 1 || msg.Content[0].Type != domain.ContentTypeToolCall {
+		t.Fatalf("Content = %+v, want single tool call", msg.Content)
+	}
+	tc := msg.Content[0].ToolCall
+	if tc.ID != "call_1" || tc.Name != "run_ipython_cell" || tc.Input["code"] != "9*9" {
+		t.Errorf("ToolCall = %+v", tc)
+	}
+}
+
+func TestStreamNext(t *testing.T) {
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w,
+			`{"choices":[{"delta":{"content":"Let me "}}]}`,
+			`{"choices":[{"delta":{"content":"check."}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"get_note","arguments":"{\"id\":"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"n1\"}"}}]}}]}`,
+		)
+	})
+
+	stream, err := p.Stream(context.Background(), "gpt-4o", "", []model.Message{
+		{Role: domain.RoleUser, Content: []model.Content{{Type: domain.ContentTypeText, Text: "look"}}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+
+	// Consume the first two deltas, then let FullMessage drain the rest.
+	for _, want := range []string{"Let me ", "check."} {
+		d, err := stream.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if d.Text != want {
+			t.Errorf("Next().Text = %q, want %q", d.Text, want)
+		}
+	}
+	d, err := stream.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if d.ToolCall == nil || d.ToolCall.ID != "call_1" || d.ToolCall.Name != "get_note" {
+		t.Errorf("Next().ToolCall = %+v", d.ToolCall)
+	}
+
+	msg, err := stream.FullMessage()
+	if err != nil {
+		t.Fatalf("FullMessage: %v", err)
+	}
+	if len(msg.Content) != 2 || msg.Content[0].Text != "Let me check." ||
+		msg.Content[1].ToolCall == nil || msg.Content[1].ToolCall.Input["id"] != "n1" {
+		t.Errorf("Content = %+v", msg.Content)
+	}
+	if _, err := stream.Next(); err != io.EOF {
+		t.Errorf("Next after end = %v, want io.EOF", err)
+	}
+}
+
+func TestStreamRequestMapping(t *testing.T) {
+	var got struct {
+		Model         string `json:"model"`
+		Stream        bool   `json:"stream"`
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+		Messages []struct {
+			Role       string  `json:"role"`
+			Content    *string `json:"content"`
+			ToolCallID string  `json:"tool_call_id"`
+			ToolCalls  []struct {
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"messages"`
+		Tools []struct {
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		} `json:"tools"`
+	}
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %q, want /v1/chat/completions", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		writeSSE(w, `{"choices":[{"delta":{"content":"done"}}]}`)
+	})
+
+	msgs := []model.Message{
+		{Role: domain.RoleCompactionSummary, Content: []model.Content{{Type: domain.ContentTypeText, Text: "summary"}}},
+		{Role: domain.RoleUser, Content: []model.Content{{Type: domain.ContentTypeText, Text: "calc"}}},
+		{Role: domain.RoleAssistant, Content: []model.Content{{Type: domain.ContentTypeText, Text: "Running."}}},
+		{Role: domain.RoleAssistant, Content: []model.Content{{
+			Type:     domain.ContentTypeToolCall,
+			ToolCall: &domain.ToolCall{ID: "call_1", Name: "run_ipython_cell", Input: map[string]any{"code": "9*9"}},
+		}}},
+		{Role: domain.RoleTool, Content: []model.Content{{
+			Type:       domain.ContentTypeToolResult,
+			ToolResult: &domain.ToolResult{ToolCallID: "call_1", Content: "81"},
+		}}},
+	}
+
+	stream, err := p.Stream(context.Background(), "gpt-4o", "be helpful", msgs, model.DefaultTools)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+	if _, err := stream.FullMessage(); err != nil {
+		t.Fatalf("FullMessage: %v", err)
+	}
+
+	if got.Model != "gpt-4o" || !got.Stream || !got.StreamOptions.IncludeUsage {
+		t.Errorf("model = %q stream = %v stream_options = %+v", got.Model, got.Stream, got.StreamOptions)
+	}
+	if len(got.Tools) != len(model.DefaultTools) {
+		t.Errorf("tools len = %d, want %d", len(got.Tools), len(model.DefaultTools))
+	}
+
+	wantRoles := []string{"system", "user", "user", "assistant", "tool"}
+	if len(got.Messages) != len(wantRoles) {
+		t.Fatalf("messages len = %d, want %d", len(got.Messages), len(wantRoles))
+	}
+	for i, role := range wantRoles {
+		if got.Messages[i].Role != role {
+			t.Errorf("messages[%d].Role = %q, want %q", i, got.Messages[i].Role, role)
+		}
+	}
+
+	// The summary is sent as user context.
+	if summary := got.Messages[1]; summary.Content == nil || *summary.Content != "Summary of the earlier conversation:\n\nsummary" {
+		t.Errorf("summary content = %v", summary.Content)
+	}
+
+	// The assistant text and tool call must be merged into one message.
+	assistant := got.Messages[3]
+	if assistant.Content == nil || *assistant.Content != "Running." {
+		t.Errorf("assistant content = %v, want %q", assistant.Content, "Running.")
+	}
+	if len(assistant.ToolCalls) != 1 || assistant.ToolCalls[0].ID != "call_1" ||
+		assistant.ToolCalls[0].Function.Arguments != `{"code":"9*9"}` {
+		t.Errorf("assistant tool calls = %+v", assistant.ToolCalls)
+	}
+
+	tool := got.Messages[4]
+	if tool.ToolCallID != "call_1" || tool.Content == nil || *tool.Content != "81" {
+		t.Errorf("tool message = %+v", tool)
+	}
+}
+
+func TestStreamWithoutStreamOptions(t *testing.T) {
+	var requests []map[string]any
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		requests = append(requests, req)
+		if _, ok := req["stream_options"]; ok {
+			http.Error(w, `{"error":{"message":"unknown field stream_options"}}`, http.StatusBadRequest)
+			return
+		}
+		writeSSE(w, `{"choices":[{"delta":{"content":"done"}}]}`)
+	})
+
+	for range 2 {
+		stream, err := p.Stream(context.Background(), "m", "", []model.Message{{Role: domain.RoleUser, Content: []model.Content{{Type: domain.ContentTypeText, Text: "hi"}}}}, nil)
+		if err != nil {
+			t.Fatalf("Stream: %v", err)
+		}
+		msg, err := stream.FullMessage()
+		stream.Close()
+		if err != nil || msg.Content[0].Text != "done" {
+			t.Fatalf("FullMessage = %+v, %v", msg, err)
+		}
+	}
+	// The first request is retried without stream_options, which is not
+	// sent again.
+	if len(requests) != 3 {
+		t.Errorf("requests = %d, want 3", len(requests))
+	}
+}
+
+func TestStreamImageAttachments(t *testing.T) {
+	var got struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		writeSSE(w, `{"choices":[{"delta":{"content":"done"}}]}`)
+	})
+
+	toolCall := func(id string) model.Message {
+		return model.Message{Role: domain.RoleAssistant, Content: []model.Content{{
+			Type:     domain.ContentTypeToolCall,
+			ToolCall: &domain.ToolCall{ID: id, Name: "run_ipython_cell", Input: map[string]any{"code": "plot()"}},
+		}}}
+	}
+	toolResult := func(id string) model.Message {
+		return model.Message{Role: domain.RoleTool, Content: []model.Content{{
+			Type:       domain.ContentTypeToolResult,
+			ToolResult: &domain.ToolResult{ToolCallID: id, Content: "<Figure>"},
+		}}}
+	}
+	attachment := func(id, mimeType string) model.Message {
+		return model.Message{Role: domain.RoleTool, Content: []model.Content{{
+			Type:       domain.ContentTypeAttachment,
+			Attachment: &domain.Attachment{ToolCallID: id, MIMEType: mimeType, Data: []byte("img")},
+		}}}
+	}
+	msgs := []model.Message{
+		{Role: domain.RoleUser, Content: []model.Content{{Type: domain.ContentTypeText, Text: "plot"}}},
+		toolCall("call_1"),
+		toolCall("call_2"),
+		toolResult("call_1"),
+		attachment("call_1", "image/png"),
+		attachment("call_1", "text/html"),
+		toolResult("call_2"),
+	}
+
+	stream, err := p.Stream(context.Background(), "gpt-4o", "", msgs, nil)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+	if _, err := stream.FullMessage(); err != nil {
+		t.Fatalf("FullMessage: %v", err)
+	}
+
+	// The image must not split the tool messages of the turn.
+	wantRoles := []string{"user", "assistant", "tool", "tool", "user"}
+	if len(got.Messages) != len(wantRoles) {
+		t.Fatalf("messages len = %d, want %d", len(got.Messages), len(wantRoles))
+	}
+	for i, role := range wantRoles {
+		if got.Messages[i].Role != role {
+			t.Errorf("messages[%d].Role = %q, want %q", i, got.Messages[i].Role, role)
+		}
+	}
+
+	var parts []struct {
+		Type     string `json:"type"`
+		ImageURL *struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+	}
+	if err := json.Unmarshal(got.Messages[4].Content, &parts); err != nil {
+		t.Fatalf("decoding image message content: %v", err)
+	}
+	want := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("img"))
+	if len(parts) != 2 || parts[1].ImageURL == nil || parts[1].ImageURL.URL != want {
+		t.Errorf("image message parts = %+v", parts)
+	}
+}
+
+func TestStreamHTTPError(t *testing.T) {
+	p := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"model not found"}}`, http.StatusNotFound)
+	})
+
+	_, err := p.Stream(context.Background(), "missing", "", nil, nil)
+	if err == nil {
+		t.Fatal("expected error for 404 response")
+	}
+}
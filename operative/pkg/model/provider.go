@@ -8,7 +8,8 @@ import (
 
 // Message represents a message in the model's conversation context.
 type Message struct {
-	// Role indicates the sender (user, assistant, tool, system).
+	// Role indicates the sender (user, assistant, tool, compaction_summary).
+	// The controller sends system stream entries as user messages.
 	Role domain.Role
 	// Content holds the message parts.
 	Content []Content
@@ -16,7 +17,7 @@ type Message struct {
 
 // Content represents a single component of a message.
 type Content struct {
-	Type string // "text", "tool_call", "tool_result"
+	Type string // "text", "tool_call", "tool_result", "attachment"
 
 	// Text content (when Type == "text").
 	Text string `json:"text,omitempty"`
@@ -27,11 +28,26 @@ type Content struct {
 	// Tool result (when Type == "tool_result").
 	ToolResult *domain.ToolResult `json:"tool_result,omitempty"`
 
+	// Attachment output by a tool call (when Type == "attachment"). Providers
+	// send images (see IsImage) to the model and skip other MIME types,
+	// whose text fallback is already part of the tool result.
+	Attachment *domain.Attachment `json:"attachment,omitempty"`
+
 	// ThoughtSignature is an opaque signature for the model's internal state.
 	// Must be round-tripped back to the model on the next request.
 	ThoughtSignature []byte `json:"thought_signature,omitempty"`
 }
 
+// IsImage reports whether an attachment MIME type is an image format that
+// multimodal models accept as input.
+func IsImage(mimeType string) bool {
+	switch mimeType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
 // Provider represents a service that provides LLMs (e.g. Gemini, OpenAI).
 type Provider interface {
 	// Name returns the provider's identifier (e.g. "gemini", "openai").
@@ -44,14 +60,49 @@ type Provider interface {
 	// modelName identifies which model to use (e.g. "gemini-2.0-flash").
 	// instructions is the system prompt.
 	// messages is the conversation history.
-	Stream(ctx context.Context, modelName, instructions string, messages []Message) (ModelStream, error)
+	// tools are the tools declared to the model; it cannot call any if empty.
+	Stream(ctx context.Context, modelName, instructions string, messages []Message, tools []Tool) (ModelStream, error)
+
+	// CountTokens returns the size in tokens of the prompt that Stream would
+	// send for the same arguments. Providers without a counting API estimate
+	// it (see EstimateTokens).
+	CountTokens(ctx context.Context, modelName, instructions string, messages []Message, tools []Tool) (int, error)
+}
+
+// Delta is an incremental piece of a streamed response.
+type Delta struct {
+	// Text is newly generated text.
+	Text string `json:"text,omitempty"`
+
+	// ToolCall is a fragment of a tool call being generated.
+	ToolCall *ToolCallDelta `json:"tool_call,omitempty"`
+}
+
+// ToolCallDelta is a fragment of a tool call. Fragments with the same Index
+// belong to the same call; ID and Name are set on the first fragment.
+type ToolCallDelta struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+
+	// Arguments is the next chunk of the JSON-encoded call arguments.
+	Arguments string `json:"arguments,omitempty"`
 }
 
 // ModelStream abstracts the stream of responses from the model.
 type ModelStream interface {
-	// FullMessage blocks until the complete response is available and returns it.
+	// Next blocks until the next delta is available. It returns io.EOF once
+	// the response is complete.
+	Next() (Delta, error)
+
+	// FullMessage blocks until the complete response is available and returns
+	// it. Deltas not yet consumed by Next are drained.
 	FullMessage() (Message, error)
 
+	// Usage returns the token usage reported for the response. It is only
+	// complete once the response is, and zero if the provider reported none.
+	Usage() domain.Usage
+
 	// Close releases resources associated with this stream.
 	Close() error
 }
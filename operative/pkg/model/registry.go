@@ -0,0 +1,217 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nstogner/operative/pkg/domain"
+)
+
+// DefaultCatalogTTL is how long the models listed by a provider are cached.
+const DefaultCatalogTTL = 10 * time.Minute
+
+// Registry holds the configured providers, keyed by Provider.Name().
+// The first registered provider is the default, used when an operative does
+// not name a provider.
+//
+// The registry caches the model catalog: each provider is listed at most once
+// per CatalogTTL.
+type Registry struct {
+	// CatalogTTL is how long listed models are cached. Defaults to
+	// DefaultCatalogTTL; zero disables the cache.
+	CatalogTTL time.Duration
+
+	providers map[string]Provider
+	order     []string
+
+	mu      sync.Mutex
+	catalog map[string]catalogEntry // keyed by provider name
+}
+
+// catalogEntry is the cached model list of a provider.
+type catalogEntry struct {
+	models  []domain.Model
+	fetched time.Time
+}
+
+// NewRegistry creates a registry from the given providers.
+// At least one provider is required and names must be unique.
+func NewRegistry(providers ...Provider) (*Registry, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("at least one model provider is required")
+	}
+	r := &Registry{
+		CatalogTTL: DefaultCatalogTTL,
+		providers:  make(map[string]Provider, len(providers)),
+		catalog:    make(map[string]catalogEntry),
+	}
+	for _, p := range providers {
+		name := p.Name()
+		if _, ok := r.providers[name]; ok {
+			return nil, fmt.Errorf("duplicate model provider: %s", name)
+		}
+		r.providers[name] = p
+		r.order = append(r.order, name)
+	}
+	return r, nil
+}
+
+// Get returns the provider with the given name.
+func (r *Registry) Get(name string) (Provider, error) {
+	p, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown model provider: %s", name)
+	}
+	return p, nil
+}
+
+// Default returns the default provider.
+func (r *Registry) Default() Provider {
+	return r.providers[r.order[0]]
+}
+
+// Providers returns all providers in registration order.
+func (r *Registry) Providers() []Provider {
+	out := make([]Provider, 0, len(r.order))
+	for _, name := range r.order {
+		out = append(out, r.providers[name])
+	}
+	return out
+}
+
+// Resolve returns the provider and provider-local model name for a model
+// selection. If providerName is set, it is used and modelName is passed
+// through unchanged. Otherwise modelName may carry a "provider/" prefix naming
+// a registered provider (e.g. "anthropic/claude-sonnet-4"), which is stripped
+// if that provider lists the rest of the name; failing that, the name is
+// looked up whole (model IDs may contain slashes, e.g. "Qwen/Qwen2.5-7B") in
+// the catalogs of the providers, the default first. Names no catalog lists
+// are an error, unless a catalog could not be listed: then the prefix, or
+// else the default provider, is assumed.
+func (r *Registry) Resolve(ctx context.Context, providerName, modelName string) (Provider, string, error) {
+	p, modelName, _, err := r.resolve(ctx, providerName, modelName)
+	return p, modelName, err
+}
+
+// resolve implements Resolve. It also returns the model's catalog entry if
+// it was found in one.
+func (r *Registry) resolve(ctx context.Context, providerName, modelName string) (Provider, string, *domain.Model, error) {
+	if providerName != "" {
+		p, err := r.Get(providerName)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return p, modelName, nil, nil
+	}
+
+	listed := make(map[string][]domain.Model)
+	var unlisted []string // providers whose catalog could not be listed
+	find := func(p Provider, id string) *domain.Model {
+		models, ok := listed[p.Name()]
+		if !ok {
+			var err error
+			if models, err = r.models(ctx, p); err != nil {
+				unlisted = append(unlisted, p.Name())
+			}
+			listed[p.Name()] = models
+		}
+		if i := slices.IndexFunc(models, func(m domain.Model) bool { return m.ID == id }); i >= 0 {
+			return &models[i]
+		}
+		return nil
+	}
+
+	prefix, rest, _ := strings.Cut(modelName, "/")
+	prefixed, ok := r.providers[prefix]
+	if ok && rest != "" {
+		if m := find(prefixed, rest); m != nil {
+			return prefixed, rest, m, nil
+		}
+	}
+	for _, name := range r.order {
+		p := r.providers[name]
+		if m := find(p, modelName); m != nil {
+			return p, modelName, m, nil
+		}
+	}
+	if len(unlisted) == 0 {
+		return nil, "", nil, fmt.Errorf("no model provider lists model %q; set the provider explicitly", modelName)
+	}
+
+	p, name := r.Default(), modelName
+	if ok && rest != "" {
+		p, name = prefixed, rest
+	}
+	slog.Warn("Model catalogs unavailable, assuming provider", "model", modelName, "provider", p.Name(), "unlisted", unlisted)
+	return p, name, nil, nil
+}
+
+// Model returns the catalog entry of a model selection (see Resolve). ok is
+// false if the provider does not list the model.
+func (r *Registry) Model(ctx context.Context, providerName, modelName string) (m domain.Model, ok bool, err error) {
+	p, modelName, entry, err := r.resolve(ctx, providerName, modelName)
+	if err != nil {
+		return domain.Model{}, false, err
+	}
+	if entry != nil {
+		return *entry, true, nil
+	}
+	models, err := r.models(ctx, p)
+	if err != nil {
+		return domain.Model{}, false, err
+	}
+	for _, m := range models {
+		if m.ID == modelName {
+			return m, true, nil
+		}
+	}
+	return domain.Model{}, false, nil
+}
+
+// models returns the models of a provider from the catalog, listing them
+// if they are not cached or the cache expired. Failed listings are not
+// cached.
+func (r *Registry) models(ctx context.Context, p Provider) ([]domain.Model, error) {
+	name := p.Name()
+	r.mu.Lock()
+	entry, ok := r.catalog[name]
+	r.mu.Unlock()
+	if ok && time.Since(entry.fetched) < r.CatalogTTL {
+		return entry.models, nil
+	}
+
+	models, err := p.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.catalog[name] = catalogEntry{models: models, fetched: time.Now()}
+	r.mu.Unlock()
+	return models, nil
+}
+
+// List returns the models of all providers. Providers that fail to list are
+// logged and skipped; an error is returned only if every provider fails.
+func (r *Registry) List(ctx context.Context) ([]domain.Model, error) {
+	var models []domain.Model
+	var errs []error
+	for _, name := range r.order {
+		ms, err := r.models(ctx, r.providers[name])
+		if err != nil {
+			slog.Warn("Listing models failed", "provider", name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		models = append(models, ms...)
+	}
+	if len(errs) == len(r.order) {
+		return nil, errors.Join(errs...)
+	}
+	return models, nil
+}
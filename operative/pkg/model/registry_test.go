@@ -0,0 +1,164 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nstogner/operative/pkg/domain"
+)
+
+// fakeProvider is a Provider that only supports Name and List.
+type fakeProvider struct {
+	name    string
+	models  []domain.Model
+	listErr error
+	lists   int // number of List calls
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+func (p *fakeProvider) List(ctx context.Context) ([]domain.Model, error) {
+	p.lists++
+	return p.models, p.listErr
+}
+func (p *fakeProvider) Stream(ctx context.Context, modelName, instructions string, messages []Message, tools []Tool) (ModelStream, error) {
+	return nil, errors.New("not implemented")
+}
+func (p *fakeProvider) CountTokens(ctx context.Context, modelName, instructions string, messages []Message, tools []Tool) (int, error) {
+	return 0, errors.New("not implemented")
+}
+
+func TestNewRegistry(t *testing.T) {
+	if _, err := NewRegistry(); err == nil {
+		t.Error("expected error for empty registry")
+	}
+	if _, err := NewRegistry(&fakeProvider{name: "a"}, &fakeProvider{name: "a"}); err == nil {
+		t.Error("expected error for duplicate provider names")
+	}
+}
+
+func TestRegistryResolve(t *testing.T) {
+	gemini := &fakeProvider{name: "gemini", models: []domain.Model{{ID: "models/gemini-2.0-flash"}}}
+	anthropic := &fakeProvider{name: "anthropic", models: []domain.Model{{ID: "claude-sonnet-4"}}}
+	vllm := &fakeProvider{name: "vllm", models: []domain.Model{{ID: "Qwen/Qwen2.5-7B"}, {ID: "anthropic/some-finetune"}}}
+	r, err := NewRegistry(gemini, anthropic, vllm)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		provider, model string
+		wantProvider    Provider
+		wantModel       string
+	}{
+		// Bare model name of the default provider.
+		{"", "models/gemini-2.0-flash", gemini, "models/gemini-2.0-flash"},
+		// A registered prefix selects the provider that lists the rest.
+		{"", "anthropic/claude-sonnet-4", anthropic, "claude-sonnet-4"},
+		// An explicit provider wins and the model name is passed through,
+		// even if it happens to contain a registered prefix.
+		{"vllm", "anthropic/some-finetune", vllm, "anthropic/some-finetune"},
+		// A registered prefix whose provider does not list the rest is part
+		// of the model name of the provider that lists it.
+		{"", "anthropic/some-finetune", vllm, "anthropic/some-finetune"},
+		// Unregistered prefixes are part of the model name.
+		{"", "Qwen/Qwen2.5-7B", vllm, "Qwen/Qwen2.5-7B"},
+	}
+	for _, tt := range tests {
+		p, m, err := r.Resolve(ctx, tt.provider, tt.model)
+		if err != nil {
+			t.Errorf("Resolve(%q, %q): %v", tt.provider, tt.model, err)
+			continue
+		}
+		if p != tt.wantProvider || m != tt.wantModel {
+			t.Errorf("Resolve(%q, %q) = (%s, %q), want (%s, %q)",
+				tt.provider, tt.model, p.Name(), m, tt.wantProvider.Name(), tt.wantModel)
+		}
+	}
+
+	if _, _, err := r.Resolve(ctx, "missing", "x"); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+	if _, _, err := r.Resolve(ctx, "", "anthropic/unknown"); err == nil {
+		t.Error("expected error for a model no provider lists")
+	}
+
+	// Without catalogs, prefixes are trusted and bare names go to the
+	// default provider.
+	down := &fakeProvider{name: "down", listErr: errors.New("unreachable")}
+	r, _ = NewRegistry(down, anthropic)
+	for _, tt := range []struct {
+		model        string
+		wantProvider Provider
+		wantModel    string
+	}{
+		{"x", down, "x"},
+		{"anthropic/claude-x", anthropic, "claude-x"},
+		{"anthropic/claude-sonnet-4", anthropic, "claude-sonnet-4"},
+	} {
+		p, m, err := r.Resolve(ctx, "", tt.model)
+		if err != nil || p != tt.wantProvider || m != tt.wantModel {
+			t.Errorf("Resolve(%q) with a failing catalog = (%v, %q, %v), want (%s, %q)",
+				tt.model, p, m, err, tt.wantProvider.Name(), tt.wantModel)
+		}
+	}
+}
+
+func TestRegistryList(t *testing.T) {
+	r, _ := NewRegistry(
+		&fakeProvider{name: "a", models: []domain.Model{{ID: "a1", Provider: "a"}}},
+		&fakeProvider{name: "b", listErr: errors.New("unreachable")},
+		&fakeProvider{name: "c", models: []domain.Model{{ID: "c1", Provider: "c"}, {ID: "c2", Provider: "c"}}},
+	)
+
+	models, err := r.List(context.Background())
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(models) != 3 {
+		t.Errorf("List len = %d, want 3", len(models))
+	}
+
+	r, _ = NewRegistry(&fakeProvider{name: "b", listErr: errors.New("unreachable")})
+	if _, err := r.List(context.Background()); err == nil {
+		t.Error("expected error when every provider fails")
+	}
+}
+
+func TestRegistryModel(t *testing.T) {
+	a := &fakeProvider{name: "a", models: []domain.Model{{ID: "a1", Provider: "a", MaxTokens: 1000}}}
+	b := &fakeProvider{name: "b", listErr: errors.New("unreachable")}
+	r, _ := NewRegistry(a, b)
+	ctx := context.Background()
+
+	m, ok, err := r.Model(ctx, "", "a1")
+	if err != nil || !ok || m.MaxTokens != 1000 {
+		t.Errorf("Model(a1) = %+v, %v, %v", m, ok, err)
+	}
+	if _, ok, err := r.Model(ctx, "", "a/missing"); err != nil || ok {
+		t.Errorf("Model(missing) = %v, %v, want not found", ok, err)
+	}
+	if _, _, err := r.Model(ctx, "b", "b1"); err == nil {
+		t.Error("expected error when the provider fails to list")
+	}
+
+	// The catalog is listed once and shared with List.
+	if _, err := r.List(ctx); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if a.lists != 1 {
+		t.Errorf("provider listed %d times, want 1", a.lists)
+	}
+	// Failures are not cached: b was listed to resolve "a/missing", for
+	// Model(b1) and for List.
+	if b.lists != 3 {
+		t.Errorf("failing provider listed %d times, want 3", b.lists)
+	}
+
+	r.CatalogTTL = 0
+	r.Model(ctx, "", "a1")
+	if a.lists != 2 {
+		t.Errorf("provider listed %d times without cache, want 2", a.lists)
+	}
+}
@@ -0,0 +1,34 @@
+package model
+
+import "encoding/json"
+
+// AttachmentTokens is the estimated cost of an attachment in the model
+// context, regardless of its encoded size.
+const AttachmentTokens = 1000
+
+// EstimateTokens estimates the size of a prompt (rough heuristic: ~4 chars
+// per token). Tool declarations are counted by their JSON encoding.
+func EstimateTokens(instructions string, messages []Message, tools []Tool) int {
+	chars := len(instructions)
+	tokens := 0
+	for _, msg := range messages {
+		for _, c := range msg.Content {
+			switch {
+			case c.Attachment != nil:
+				tokens += AttachmentTokens
+			case c.ToolCall != nil:
+				b, _ := json.Marshal(c.ToolCall)
+				chars += len(b)
+			case c.ToolResult != nil:
+				chars += len(c.ToolResult.Content)
+			default:
+				chars += len(c.Text)
+			}
+		}
+	}
+	if len(tools) > 0 {
+		b, _ := json.Marshal(tools)
+		chars += len(b)
+	}
+	return tokens + chars/4
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nstogner/operative/pkg/domain"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	msgs := []Message{
+		{Role: domain.RoleUser, Content: []Content{{Type: domain.ContentTypeText, Text: strings.Repeat("a", 400)}}},
+		{Role: domain.RoleTool, Content: []Content{
+			{Type: domain.ContentTypeToolResult, ToolResult: &domain.ToolResult{ToolCallID: "c1", Content: strings.Repeat("b", 400)}},
+			{Type: domain.ContentTypeAttachment, Attachment: &domain.Attachment{MIMEType: "image/png", Data: make([]byte, 1<<20)}},
+		}},
+	}
+	if got, want := EstimateTokens("", msgs, nil), 200+AttachmentTokens; got != want {
+		t.Errorf("EstimateTokens = %d, want %d", got, want)
+	}
+
+	// Instructions and tool declarations are part of the prompt.
+	if got := EstimateTokens(strings.Repeat("c", 400), msgs, DefaultTools); got <= 300+AttachmentTokens {
+		t.Errorf("EstimateTokens with instructions and tools = %d", got)
+	}
+}
@@ -0,0 +1,109 @@
+package model
+
+// Tool describes a function that the model may call.
+type Tool struct {
+	// Name is the function name (e.g. "run_ipython_cell").
+	Name string
+	// Description explains to the model what the tool does.
+	Description string
+	// Parameters describes the tool's input object.
+	Parameters *Schema
+}
+
+// Schema is the subset of JSON Schema used to describe tool parameters.
+// It marshals directly to the JSON Schema wire format used by most providers.
+type Schema struct {
+	Type        string             `json:"type"` // "object", "string", ...
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+}
+
+// JSON Schema types used in tool declarations.
+const (
+	TypeObject = "object"
+	TypeString = "string"
+)
+
+// DefaultTools are the tools every operative has access to. The controller
+// declares these to the model on each turn.
+var DefaultTools = []Tool{
+	{
+		Name:        "run_ipython_cell",
+		Description: "Run a cell of code in the IPython kernel. Returns the result.",
+		Parameters: &Schema{
+			Type: TypeObject,
+			Properties: map[string]*Schema{
+				"code": {Type: TypeString, Description: "The code to run."},
+			},
+			Required: []string{"code"},
+		},
+	},
+	{
+		Name:        "update_instructions",
+		Description: "Update the operative's self-set instructions.",
+		Parameters: &Schema{
+			Type: TypeObject,
+			Properties: map[string]*Schema{
+				"instructions": {Type: TypeString, Description: "The new instructions."},
+			},
+			Required: []string{"instructions"},
+		},
+	},
+	{
+		Name:        "store_note",
+		Description: "Store a searchable note.",
+		Parameters: &Schema{
+			Type: TypeObject,
+			Properties: map[string]*Schema{
+				"title":   {Type: TypeString, Description: "The note title."},
+				"content": {Type: TypeString, Description: "The note content."},
+			},
+			Required: []string{"title", "content"},
+		},
+	},
+	{
+		Name:        "keyword_search_notes",
+		Description: "Search notes by keyword.",
+		Parameters: &Schema{
+			Type: TypeObject,
+			Properties: map[string]*Schema{
+				"query": {Type: TypeString, Description: "The search query."},
+			},
+			Required: []string{"query"},
+		},
+	},
+	{
+		Name:        "vector_search_notes",
+		Description: "Search notes by semantic similarity (vector search).",
+		Parameters: &Schema{
+			Type: TypeObject,
+			Properties: map[string]*Schema{
+				"query": {Type: TypeString, Description: "The search query."},
+			},
+			Required: []string{"query"},
+		},
+	},
+	{
+		Name:        "get_note",
+		Description: "Retrieve a note by its ID.",
+		Parameters: &Schema{
+			Type: TypeObject,
+			Properties: map[string]*Schema{
+				"id": {Type: TypeString, Description: "The note ID."},
+			},
+			Required: []string{"id"},
+		},
+	},
+	{
+		Name:        "delete_note",
+		Description: "Delete a note by its ID.",
+		Parameters: &Schema{
+			Type: TypeObject,
+			Properties: map[string]*Schema{
+				"id": {Type: TypeString, Description: "The note ID."},
+			},
+			Required: []string{"id"},
+		},
+	},
+}
@@ -29,6 +29,8 @@ type ClientMessage struct {
 	//	*ClientMessage_RunCell
 	//	*ClientMessage_PromptModelResponse
 	//	*ClientMessage_Cancel
+	//	*ClientMessage_SaveNamespace
+	//	*ClientMessage_LoadNamespace
 	Payload       isClientMessage_Payload `protobuf_oneof:"payload"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -98,6 +100,24 @@ func (x *ClientMessage) GetCancel() *CancelRequest {
 	return nil
 }
 
+func (x *ClientMessage) GetSaveNamespace() *SaveNamespaceRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*ClientMessage_SaveNamespace); ok {
+			return x.SaveNamespace
+		}
+	}
+	return nil
+}
+
+func (x *ClientMessage) GetLoadNamespace() *LoadNamespaceRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*ClientMessage_LoadNamespace); ok {
+			return x.LoadNamespace
+		}
+	}
+	return nil
+}
+
 type isClientMessage_Payload interface {
 	isClientMessage_Payload()
 }
@@ -114,12 +134,24 @@ type ClientMessage_Cancel struct {
 	Cancel *CancelRequest `protobuf:"bytes,3,opt,name=cancel,proto3,oneof"`
 }
 
+type ClientMessage_SaveNamespace struct {
+	SaveNamespace *SaveNamespaceRequest `protobuf:"bytes,4,opt,name=save_namespace,json=saveNamespace,proto3,oneof"`
+}
+
+type ClientMessage_LoadNamespace struct {
+	LoadNamespace *LoadNamespaceRequest `protobuf:"bytes,5,opt,name=load_namespace,json=loadNamespace,proto3,oneof"`
+}
+
 func (*ClientMessage_RunCell) isClientMessage_Payload() {}
 
 func (*ClientMessage_PromptModelResponse) isClientMessage_Payload() {}
 
 func (*ClientMessage_Cancel) isClientMessage_Payload() {}
 
+func (*ClientMessage_SaveNamespace) isClientMessage_Payload() {}
+
+func (*ClientMessage_LoadNamespace) isClientMessage_Payload() {}
+
 // ServerMessage is a message sent from the Python sandbox to the Go agent.
 type ServerMessage struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
@@ -129,6 +161,7 @@ type ServerMessage struct {
 	//	*ServerMessage_Output
 	//	*ServerMessage_PromptModel
 	//	*ServerMessage_PromptSelf
+	//	*ServerMessage_NamespaceResult
 	Payload       isServerMessage_Payload `protobuf_oneof:"payload"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -207,6 +240,15 @@ func (x *ServerMessage) GetPromptSelf() *PromptSelfRequest {
 	return nil
 }
 
+func (x *ServerMessage) GetNamespaceResult() *NamespaceResult {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_NamespaceResult); ok {
+			return x.NamespaceResult
+		}
+	}
+	return nil
+}
+
 type isServerMessage_Payload interface {
 	isServerMessage_Payload()
 }
@@ -227,6 +269,10 @@ type ServerMessage_PromptSelf struct {
 	PromptSelf *PromptSelfRequest `protobuf:"bytes,4,opt,name=prompt_self,json=promptSelf,proto3,oneof"`
 }
 
+type ServerMessage_NamespaceResult struct {
+	NamespaceResult *NamespaceResult `protobuf:"bytes,5,opt,name=namespace_result,json=namespaceResult,proto3,oneof"` // Result of a save/load namespace request
+}
+
 func (*ServerMessage_RunCellResult) isServerMessage_Payload() {}
 
 func (*ServerMessage_Output) isServerMessage_Payload() {}
@@ -235,6 +281,8 @@ func (*ServerMessage_PromptModel) isServerMessage_Payload() {}
 
 func (*ServerMessage_PromptSelf) isServerMessage_Payload() {}
 
+func (*ServerMessage_NamespaceResult) isServerMessage_Payload() {}
+
 type RunCellRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Code          string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
@@ -281,10 +329,15 @@ func (x *RunCellRequest) GetCode() string {
 
 type RunCellResult struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Output        string                 `protobuf:"bytes,1,opt,name=output,proto3" json:"output,omitempty"` // Combined output (legacy support)
-	Stdout        string                 `protobuf:"bytes,2,opt,name=stdout,proto3" json:"stdout,omitempty"` // Combined stdout
-	Stderr        string                 `protobuf:"bytes,3,opt,name=stderr,proto3" json:"stderr,omitempty"` // Combined stderr
-	Success       bool                   `protobuf:"varint,4,opt,name=success,proto3" json:"success,omitempty"`
+	Output        string                 `protobuf:"bytes,1,opt,name=output,proto3" json:"output,omitempty"`                           // Combined output (legacy support)
+	Stdout        string                 `protobuf:"bytes,2,opt,name=stdout,proto3" json:"stdout,omitempty"`                           // Combined stdout
+	Stderr        string                 `protobuf:"bytes,3,opt,name=stderr,proto3" json:"stderr,omitempty"`                           // Combined stderr
+	Success       bool                   `protobuf:"varint,4,opt,name=success,proto3" json:"success,omitempty"`                        // False if the cell raised (including syntax errors)
+	Interrupted   bool                   `protobuf:"varint,5,opt,name=interrupted,proto3" json:"interrupted,omitempty"`                // The cell was stopped by a CancelRequest
+	ErrorName     string                 `protobuf:"bytes,6,opt,name=error_name,json=errorName,proto3" json:"error_name,omitempty"`    // Exception type when !success, e.g. "ZeroDivisionError"
+	ErrorValue    string                 `protobuf:"bytes,7,opt,name=error_value,json=errorValue,proto3" json:"error_value,omitempty"` // str() of the exception
+	Traceback     string                 `protobuf:"bytes,8,opt,name=traceback,proto3" json:"traceback,omitempty"`                     // Plain-text traceback (not included in stdout/stderr)
+	Displays      []*DisplayData         `protobuf:"bytes,9,rep,name=displays,proto3" json:"displays,omitempty"`                       // Rich outputs, in the order they were shown
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -347,6 +400,97 @@ func (x *RunCellResult) GetSuccess() bool {
 	return false
 }
 
+func (x *RunCellResult) GetInterrupted() bool {
+	if x != nil {
+		return x.Interrupted
+	}
+	return false
+}
+
+func (x *RunCellResult) GetErrorName() string {
+	if x != nil {
+		return x.ErrorName
+	}
+	return ""
+}
+
+func (x *RunCellResult) GetErrorValue() string {
+	if x != nil {
+		return x.ErrorValue
+	}
+	return ""
+}
+
+func (x *RunCellResult) GetTraceback() string {
+	if x != nil {
+		return x.Traceback
+	}
+	return ""
+}
+
+func (x *RunCellResult) GetDisplays() []*DisplayData {
+	if x != nil {
+		return x.Displays
+	}
+	return nil
+}
+
+// DisplayData is a rich output (display_data or execute_result) in the richest
+// MIME type the sandbox could capture, e.g. a matplotlib PNG or a DataFrame's
+// HTML table. Images are raw bytes, not base64. The text/plain fallback is
+// written to stdout as usual.
+type DisplayData struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MimeType      string                 `protobuf:"bytes,1,opt,name=mime_type,json=mimeType,proto3" json:"mime_type,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DisplayData) Reset() {
+	*x = DisplayData{}
+	mi := &file_sandbox_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DisplayData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisplayData) ProtoMessage() {}
+
+func (x *DisplayData) ProtoReflect() protoreflect.Message {
+	mi := &file_sandbox_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisplayData.ProtoReflect.Descriptor instead.
+func (*DisplayData) Descriptor() ([]byte, []int) {
+	return file_sandbox_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DisplayData) GetMimeType() string {
+	if x != nil {
+		return x.MimeType
+	}
+	return ""
+}
+
+func (x *DisplayData) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
 type Output struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Text          string                 `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
@@ -357,7 +501,7 @@ type Output struct {
 
 func (x *Output) Reset() {
 	*x = Output{}
-	mi := &file_sandbox_proto_msgTypes[4]
+	mi := &file_sandbox_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -369,7 +513,7 @@ func (x *Output) String() string {
 func (*Output) ProtoMessage() {}
 
 func (x *Output) ProtoReflect() protoreflect.Message {
-	mi := &file_sandbox_proto_msgTypes[4]
+	mi := &file_sandbox_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -382,7 +526,7 @@ func (x *Output) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Output.ProtoReflect.Descriptor instead.
 func (*Output) Descriptor() ([]byte, []int) {
-	return file_sandbox_proto_rawDescGZIP(), []int{4}
+	return file_sandbox_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *Output) GetText() string {
@@ -409,7 +553,7 @@ type PromptModelRequest struct {
 
 func (x *PromptModelRequest) Reset() {
 	*x = PromptModelRequest{}
-	mi := &file_sandbox_proto_msgTypes[5]
+	mi := &file_sandbox_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -421,7 +565,7 @@ func (x *PromptModelRequest) String() string {
 func (*PromptModelRequest) ProtoMessage() {}
 
 func (x *PromptModelRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_sandbox_proto_msgTypes[5]
+	mi := &file_sandbox_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -434,7 +578,7 @@ func (x *PromptModelRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PromptModelRequest.ProtoReflect.Descriptor instead.
 func (*PromptModelRequest) Descriptor() ([]byte, []int) {
-	return file_sandbox_proto_rawDescGZIP(), []int{5}
+	return file_sandbox_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *PromptModelRequest) GetPrompt() string {
@@ -461,7 +605,7 @@ type PromptModelResponse struct {
 
 func (x *PromptModelResponse) Reset() {
 	*x = PromptModelResponse{}
-	mi := &file_sandbox_proto_msgTypes[6]
+	mi := &file_sandbox_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -473,7 +617,7 @@ func (x *PromptModelResponse) String() string {
 func (*PromptModelResponse) ProtoMessage() {}
 
 func (x *PromptModelResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_sandbox_proto_msgTypes[6]
+	mi := &file_sandbox_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -486,7 +630,7 @@ func (x *PromptModelResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PromptModelResponse.ProtoReflect.Descriptor instead.
 func (*PromptModelResponse) Descriptor() ([]byte, []int) {
-	return file_sandbox_proto_rawDescGZIP(), []int{6}
+	return file_sandbox_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *PromptModelResponse) GetResponse() string {
@@ -512,7 +656,7 @@ type PromptSelfRequest struct {
 
 func (x *PromptSelfRequest) Reset() {
 	*x = PromptSelfRequest{}
-	mi := &file_sandbox_proto_msgTypes[7]
+	mi := &file_sandbox_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -524,7 +668,7 @@ func (x *PromptSelfRequest) String() string {
 func (*PromptSelfRequest) ProtoMessage() {}
 
 func (x *PromptSelfRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_sandbox_proto_msgTypes[7]
+	mi := &file_sandbox_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -537,7 +681,7 @@ func (x *PromptSelfRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PromptSelfRequest.ProtoReflect.Descriptor instead.
 func (*PromptSelfRequest) Descriptor() ([]byte, []int) {
-	return file_sandbox_proto_rawDescGZIP(), []int{7}
+	return file_sandbox_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *PromptSelfRequest) GetMessage() string {
@@ -547,6 +691,8 @@ func (x *PromptSelfRequest) GetMessage() string {
 	return ""
 }
 
+// CancelRequest interrupts the running cell (KeyboardInterrupt). It is a no-op
+// if no cell is running.
 type CancelRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
@@ -555,7 +701,7 @@ type CancelRequest struct {
 
 func (x *CancelRequest) Reset() {
 	*x = CancelRequest{}
-	mi := &file_sandbox_proto_msgTypes[8]
+	mi := &file_sandbox_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -567,7 +713,7 @@ func (x *CancelRequest) String() string {
 func (*CancelRequest) ProtoMessage() {}
 
 func (x *CancelRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_sandbox_proto_msgTypes[8]
+	mi := &file_sandbox_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -580,7 +726,160 @@ func (x *CancelRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CancelRequest.ProtoReflect.Descriptor instead.
 func (*CancelRequest) Descriptor() ([]byte, []int) {
-	return file_sandbox_proto_rawDescGZIP(), []int{8}
+	return file_sandbox_proto_rawDescGZIP(), []int{9}
+}
+
+// SaveNamespaceRequest serializes (with dill) the IPython user namespace to a
+// file in the sandbox. Like cells, it runs on the main thread, so it waits for
+// any running cell to finish.
+type SaveNamespaceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SaveNamespaceRequest) Reset() {
+	*x = SaveNamespaceRequest{}
+	mi := &file_sandbox_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SaveNamespaceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SaveNamespaceRequest) ProtoMessage() {}
+
+func (x *SaveNamespaceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sandbox_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SaveNamespaceRequest.ProtoReflect.Descriptor instead.
+func (*SaveNamespaceRequest) Descriptor() ([]byte, []int) {
+	return file_sandbox_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *SaveNamespaceRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+// LoadNamespaceRequest loads a namespace file written by SaveNamespaceRequest
+// into the IPython user namespace.
+type LoadNamespaceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LoadNamespaceRequest) Reset() {
+	*x = LoadNamespaceRequest{}
+	mi := &file_sandbox_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LoadNamespaceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoadNamespaceRequest) ProtoMessage() {}
+
+func (x *LoadNamespaceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sandbox_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoadNamespaceRequest.ProtoReflect.Descriptor instead.
+func (*LoadNamespaceRequest) Descriptor() ([]byte, []int) {
+	return file_sandbox_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *LoadNamespaceRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type NamespaceResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	Skipped       []string               `protobuf:"bytes,3,rep,name=skipped,proto3" json:"skipped,omitempty"` // Variables that could not be serialized
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NamespaceResult) Reset() {
+	*x = NamespaceResult{}
+	mi := &file_sandbox_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NamespaceResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NamespaceResult) ProtoMessage() {}
+
+func (x *NamespaceResult) ProtoReflect() protoreflect.Message {
+	mi := &file_sandbox_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NamespaceResult.ProtoReflect.Descriptor instead.
+func (*NamespaceResult) Descriptor() ([]byte, []int) {
+	return file_sandbox_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *NamespaceResult) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *NamespaceResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *NamespaceResult) GetSkipped() []string {
+	if x != nil {
+		return x.Skipped
+	}
+	return nil
 }
 
 var File_sandbox_proto protoreflect.FileDescriptor
@@ -588,26 +887,39 @@ var File_sandbox_proto protoreflect.FileDescriptor
 const file_sandbox_proto_rawDesc = "" +
 	"\n" +
 	"\rsandbox.proto\x12\n" +
-	"sandbox.v1\"\xdf\x01\n" +
+	"sandbox.v1\"\xf5\x02\n" +
 	"\rClientMessage\x127\n" +
 	"\brun_cell\x18\x01 \x01(\v2\x1a.sandbox.v1.RunCellRequestH\x00R\arunCell\x12U\n" +
 	"\x15prompt_model_response\x18\x02 \x01(\v2\x1f.sandbox.v1.PromptModelResponseH\x00R\x13promptModelResponse\x123\n" +
-	"\x06cancel\x18\x03 \x01(\v2\x19.sandbox.v1.CancelRequestH\x00R\x06cancelB\t\n" +
-	"\apayload\"\x94\x02\n" +
+	"\x06cancel\x18\x03 \x01(\v2\x19.sandbox.v1.CancelRequestH\x00R\x06cancel\x12I\n" +
+	"\x0esave_namespace\x18\x04 \x01(\v2 .sandbox.v1.SaveNamespaceRequestH\x00R\rsaveNamespace\x12I\n" +
+	"\x0eload_namespace\x18\x05 \x01(\v2 .sandbox.v1.LoadNamespaceRequestH\x00R\rloadNamespaceB\t\n" +
+	"\apayload\"\xde\x02\n" +
 	"\rServerMessage\x12C\n" +
 	"\x0frun_cell_result\x18\x01 \x01(\v2\x19.sandbox.v1.RunCellResultH\x00R\rrunCellResult\x12,\n" +
 	"\x06output\x18\x02 \x01(\v2\x12.sandbox.v1.OutputH\x00R\x06output\x12C\n" +
 	"\fprompt_model\x18\x03 \x01(\v2\x1e.sandbox.v1.PromptModelRequestH\x00R\vpromptModel\x12@\n" +
 	"\vprompt_self\x18\x04 \x01(\v2\x1d.sandbox.v1.PromptSelfRequestH\x00R\n" +
-	"promptSelfB\t\n" +
+	"promptSelf\x12H\n" +
+	"\x10namespace_result\x18\x05 \x01(\v2\x1b.sandbox.v1.NamespaceResultH\x00R\x0fnamespaceResultB\t\n" +
 	"\apayload\"$\n" +
 	"\x0eRunCellRequest\x12\x12\n" +
-	"\x04code\x18\x01 \x01(\tR\x04code\"q\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\"\xa6\x02\n" +
 	"\rRunCellResult\x12\x16\n" +
 	"\x06output\x18\x01 \x01(\tR\x06output\x12\x16\n" +
 	"\x06stdout\x18\x02 \x01(\tR\x06stdout\x12\x16\n" +
 	"\x06stderr\x18\x03 \x01(\tR\x06stderr\x12\x18\n" +
-	"\asuccess\x18\x04 \x01(\bR\asuccess\"9\n" +
+	"\asuccess\x18\x04 \x01(\bR\asuccess\x12 \n" +
+	"\vinterrupted\x18\x05 \x01(\bR\vinterrupted\x12\x1d\n" +
+	"\n" +
+	"error_name\x18\x06 \x01(\tR\terrorName\x12\x1f\n" +
+	"\verror_value\x18\a \x01(\tR\n" +
+	"errorValue\x12\x1c\n" +
+	"\ttraceback\x18\b \x01(\tR\ttraceback\x123\n" +
+	"\bdisplays\x18\t \x03(\v2\x17.sandbox.v1.DisplayDataR\bdisplays\">\n" +
+	"\vDisplayData\x12\x1b\n" +
+	"\tmime_type\x18\x01 \x01(\tR\bmimeType\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"9\n" +
 	"\x06Output\x12\x12\n" +
 	"\x04text\x18\x01 \x01(\tR\x04text\x12\x1b\n" +
 	"\tis_stderr\x18\x02 \x01(\bR\bisStderr\"<\n" +
@@ -619,9 +931,17 @@ const file_sandbox_proto_rawDesc = "" +
 	"\x02id\x18\x02 \x01(\tR\x02id\"-\n" +
 	"\x11PromptSelfRequest\x12\x18\n" +
 	"\amessage\x18\x01 \x01(\tR\amessage\"\x0f\n" +
-	"\rCancelRequest2P\n" +
+	"\rCancelRequest\"*\n" +
+	"\x14SaveNamespaceRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\"*\n" +
+	"\x14LoadNamespaceRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\"[\n" +
+	"\x0fNamespaceResult\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\x12\x18\n" +
+	"\askipped\x18\x03 \x03(\tR\askipped2P\n" +
 	"\aSandbox\x12E\n" +
-	"\tRunStream\x12\x19.sandbox.v1.ClientMessage\x1a\x19.sandbox.v1.ServerMessage(\x010\x01BHZFgithub.com/mariozechner/coding-agent/session/pkg/sandbox/api;sandboxv1b\x06proto3"
+	"\tRunStream\x12\x19.sandbox.v1.ClientMessage\x1a\x19.sandbox.v1.ServerMessage(\x010\x01B9Z7github.com/nstogner/operative/pkg/sandbox/api;sandboxv1b\x06proto3"
 
 var (
 	file_sandbox_proto_rawDescOnce sync.Once
@@ -635,33 +955,41 @@ func file_sandbox_proto_rawDescGZIP() []byte {
 	return file_sandbox_proto_rawDescData
 }
 
-var file_sandbox_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_sandbox_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
 var file_sandbox_proto_goTypes = []any{
-	(*ClientMessage)(nil),       // 0: sandbox.v1.ClientMessage
-	(*ServerMessage)(nil),       // 1: sandbox.v1.ServerMessage
-	(*RunCellRequest)(nil),      // 2: sandbox.v1.RunCellRequest
-	(*RunCellResult)(nil),       // 3: sandbox.v1.RunCellResult
-	(*Output)(nil),              // 4: sandbox.v1.Output
-	(*PromptModelRequest)(nil),  // 5: sandbox.v1.PromptModelRequest
-	(*PromptModelResponse)(nil), // 6: sandbox.v1.PromptModelResponse
-	(*PromptSelfRequest)(nil),   // 7: sandbox.v1.PromptSelfRequest
-	(*CancelRequest)(nil),       // 8: sandbox.v1.CancelRequest
+	(*ClientMessage)(nil),        // 0: sandbox.v1.ClientMessage
+	(*ServerMessage)(nil),        // 1: sandbox.v1.ServerMessage
+	(*RunCellRequest)(nil),       // 2: sandbox.v1.RunCellRequest
+	(*RunCellResult)(nil),        // 3: sandbox.v1.RunCellResult
+	(*DisplayData)(nil),          // 4: sandbox.v1.DisplayData
+	(*Output)(nil),               // 5: sandbox.v1.Output
+	(*PromptModelRequest)(nil),   // 6: sandbox.v1.PromptModelRequest
+	(*PromptModelResponse)(nil),  // 7: sandbox.v1.PromptModelResponse
+	(*PromptSelfRequest)(nil),    // 8: sandbox.v1.PromptSelfRequest
+	(*CancelRequest)(nil),        // 9: sandbox.v1.CancelRequest
+	(*SaveNamespaceRequest)(nil), // 10: sandbox.v1.SaveNamespaceRequest
+	(*LoadNamespaceRequest)(nil), // 11: sandbox.v1.LoadNamespaceRequest
+	(*NamespaceResult)(nil),      // 12: sandbox.v1.NamespaceResult
 }
 var file_sandbox_proto_depIdxs = []int32{
-	2, // 0: sandbox.v1.ClientMessage.run_cell:type_name -> sandbox.v1.RunCellRequest
-	6, // 1: sandbox.v1.ClientMessage.prompt_model_response:type_name -> sandbox.v1.PromptModelResponse
-	8, // 2: sandbox.v1.ClientMessage.cancel:type_name -> sandbox.v1.CancelRequest
-	3, // 3: sandbox.v1.ServerMessage.run_cell_result:type_name -> sandbox.v1.RunCellResult
-	4, // 4: sandbox.v1.ServerMessage.output:type_name -> sandbox.v1.Output
-	5, // 5: sandbox.v1.ServerMessage.prompt_model:type_name -> sandbox.v1.PromptModelRequest
-	7, // 6: sandbox.v1.ServerMessage.prompt_self:type_name -> sandbox.v1.PromptSelfRequest
-	0, // 7: sandbox.v1.Sandbox.RunStream:input_type -> sandbox.v1.ClientMessage
-	1, // 8: sandbox.v1.Sandbox.RunStream:output_type -> sandbox.v1.ServerMessage
-	8, // [8:9] is the sub-list for method output_type
-	7, // [7:8] is the sub-list for method input_type
-	7, // [7:7] is the sub-list for extension type_name
-	7, // [7:7] is the sub-list for extension extendee
-	0, // [0:7] is the sub-list for field type_name
+	2,  // 0: sandbox.v1.ClientMessage.run_cell:type_name -> sandbox.v1.RunCellRequest
+	7,  // 1: sandbox.v1.ClientMessage.prompt_model_response:type_name -> sandbox.v1.PromptModelResponse
+	9,  // 2: sandbox.v1.ClientMessage.cancel:type_name -> sandbox.v1.CancelRequest
+	10, // 3: sandbox.v1.ClientMessage.save_namespace:type_name -> sandbox.v1.SaveNamespaceRequest
+	11, // 4: sandbox.v1.ClientMessage.load_namespace:type_name -> sandbox.v1.LoadNamespaceRequest
+	3,  // 5: sandbox.v1.ServerMessage.run_cell_result:type_name -> sandbox.v1.RunCellResult
+	5,  // 6: sandbox.v1.ServerMessage.output:type_name -> sandbox.v1.Output
+	6,  // 7: sandbox.v1.ServerMessage.prompt_model:type_name -> sandbox.v1.PromptModelRequest
+	8,  // 8: sandbox.v1.ServerMessage.prompt_self:type_name -> sandbox.v1.PromptSelfRequest
+	12, // 9: sandbox.v1.ServerMessage.namespace_result:type_name -> sandbox.v1.NamespaceResult
+	4,  // 10: sandbox.v1.RunCellResult.displays:type_name -> sandbox.v1.DisplayData
+	0,  // 11: sandbox.v1.Sandbox.RunStream:input_type -> sandbox.v1.ClientMessage
+	1,  // 12: sandbox.v1.Sandbox.RunStream:output_type -> sandbox.v1.ServerMessage
+	12, // [12:13] is the sub-list for method output_type
+	11, // [11:12] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
 }
 
 func init() { file_sandbox_proto_init() }
@@ -673,12 +1001,15 @@ func file_sandbox_proto_init() {
 		(*ClientMessage_RunCell)(nil),
 		(*ClientMessage_PromptModelResponse)(nil),
 		(*ClientMessage_Cancel)(nil),
+		(*ClientMessage_SaveNamespace)(nil),
+		(*ClientMessage_LoadNamespace)(nil),
 	}
 	file_sandbox_proto_msgTypes[1].OneofWrappers = []any{
 		(*ServerMessage_RunCellResult)(nil),
 		(*ServerMessage_Output)(nil),
 		(*ServerMessage_PromptModel)(nil),
 		(*ServerMessage_PromptSelf)(nil),
+		(*ServerMessage_NamespaceResult)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -686,7 +1017,7 @@ func file_sandbox_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_sandbox_proto_rawDesc), len(file_sandbox_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   9,
+			NumMessages:   13,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
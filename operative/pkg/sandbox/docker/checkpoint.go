@@ -0,0 +1,297 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/google/uuid"
+	"github.com/nstogner/operative/pkg/sandbox"
+	sandboxv1 "github.com/nstogner/operative/pkg/sandbox/api"
+	"github.com/nstogner/operative/pkg/sandbox/rpc"
+)
+
+const (
+	// CheckpointRepository is the image repository checkpoints are committed to.
+	// Tags are "<operative ID>-<checkpoint ID>".
+	CheckpointRepository = "operative-checkpoint"
+	// LabelCheckpointID identifies checkpoint images.
+	LabelCheckpointID = "checkpoint-id"
+	// LabelCheckpointSkipped lists the variables (comma-separated) that were
+	// not saved in a checkpoint. It is always set, so that a checkpoint of a
+	// restored sandbox does not inherit the list of its parent's image.
+	LabelCheckpointSkipped = "checkpoint-skipped"
+	// LabelCheckpointWorkspace is "true" on checkpoints that include a copy
+	// of the workspace volume. Older checkpoints do not.
+	LabelCheckpointWorkspace = "checkpoint-workspace"
+
+	// namespacePath is where the IPython namespace is saved inside the
+	// sandbox, so that it is part of the committed filesystem.
+	namespacePath = "/var/lib/operative/namespace.dill"
+)
+
+// Checkpoint saves the IPython namespace to a file in the sandbox, copies
+// the workspace volume into the container filesystem, and commits the
+// container, including both, to a checkpoint image.
+func (m *Manager) Checkpoint(ctx context.Context, operativeID string) (*sandbox.Checkpoint, error) {
+	if m.spec(operativeID).config.ReadOnlyRootFS {
+		// The namespace would be saved to a tmpfs, which is not committed.
+		return nil, errors.New("checkpoints are not supported for sandboxes with a read-only root filesystem")
+	}
+	release, err := m.beginCheckpoint(operativeID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	res, err := m.namespaceRequest(ctx, operativeID, &sandboxv1.ClientMessage{
+		Payload: &sandboxv1.ClientMessage_SaveNamespace{
+			SaveNamespace: &sandboxv1.SaveNamespaceRequest{Path: namespacePath},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !res.Success {
+		return nil, fmt.Errorf("saving namespace: %s", res.Error)
+	}
+
+	id := uuid.New().String()
+	if err := m.snapshotWorkspace(ctx, operativeID, id); err != nil {
+		return nil, err
+	}
+	changes := fmt.Sprintf("LABEL %s=%q %s=%q %s=%q", LabelCheckpointID, id,
+		LabelCheckpointSkipped, strings.Join(res.Skipped, ","), LabelCheckpointWorkspace, "true")
+	if _, err := m.client.ContainerCommit(ctx, m.containerName(operativeID), types.ContainerCommitOptions{
+		Reference: m.checkpointRef(operativeID, id),
+		Comment:   "operative sandbox checkpoint",
+		Changes:   []string{changes},
+		Pause:     true,
+	}); err != nil {
+		return nil, fmt.Errorf("committing sandbox: %w", err)
+	}
+	if err := m.removeWorkspaceSnapshots(ctx, operativeID); err != nil {
+		slog.Warn("Failed to remove workspace snapshot from sandbox", "operativeID", operativeID, "error", err)
+	}
+	slog.Info("Sandbox checkpointed", "operativeID", operativeID, "checkpointID", id, "skipped", res.Skipped)
+
+	return m.getCheckpoint(ctx, operativeID, id)
+}
+
+// ListCheckpoints lists the operative's checkpoint images, newest first.
+func (m *Manager) ListCheckpoints(ctx context.Context, operativeID string) ([]sandbox.Checkpoint, error) {
+	images, err := m.client.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", LabelCheckpointID),
+			filters.Arg("label", LabelOperativeID+"="+operativeID),
+		),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing checkpoint images: %w", err)
+	}
+
+	checkpoints := make([]sandbox.Checkpoint, 0, len(images))
+	for _, img := range images {
+		checkpoints = append(checkpoints, checkpointFromLabels(img.Labels, time.Unix(img.Created, 0), img.Size))
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].CreatedAt.After(checkpoints[j].CreatedAt)
+	})
+	return checkpoints, nil
+}
+
+// Restore replaces the operative's container with one created from the
+// checkpoint image, replaces the workspace volume with the checkpoint's copy
+// (if it has one), and loads the saved namespace. The copy is restored into
+// a new volume, and the old one is only removed once the copy and the
+// namespace have been loaded; if either fails, the new volume is removed and
+// the sandbox restarts with the old one.
+func (m *Manager) Restore(ctx context.Context, operativeID, checkpointID string) (*sandbox.Checkpoint, error) {
+	sp := m.spec(operativeID)
+	if sp.config.ReadOnlyRootFS {
+		// The checkpoint's namespace and workspace copy would be hidden by
+		// the tmpfs at /var/lib/operative.
+		return nil, errors.New("checkpoints are not supported for sandboxes with a read-only root filesystem")
+	}
+	cp, err := m.getCheckpoint(ctx, operativeID, checkpointID)
+	if err != nil {
+		return nil, err
+	}
+	if cp.Image != "" && cp.Image != sp.image {
+		return nil, fmt.Errorf("checkpoint %s is based on image %s, but the operative now uses %s", checkpointID, cp.Image, sp.image)
+	}
+
+	// Keep the reconcile loop and new cells away while the container is
+	// replaced and the namespace loaded.
+	release, err := m.beginCheckpoint(operativeID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	slog.Info("Restoring sandbox from checkpoint", "operativeID", operativeID, "checkpointID", checkpointID)
+	oldWorkspace, err := m.currentWorkspace(ctx, operativeID)
+	if err != nil {
+		return nil, err
+	}
+	m.stopContainer(ctx, operativeID)
+
+	var workspace mount.Mount
+	if cp.Workspace {
+		workspace, err = m.createWorkspace(ctx, operativeID, m.restoredWorkspaceName(operativeID))
+	} else {
+		workspace, err = m.ensureWorkspace(ctx, operativeID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// discard removes the sandbox and the new volume, after which the Run
+	// loop starts a sandbox with the old one.
+	discard := func() {
+		m.stopContainer(ctx, operativeID)
+		if err := m.client.VolumeRemove(ctx, workspace.Source, true); err != nil {
+			slog.Warn("Failed to remove workspace volume", "volume", workspace.Source, "error", err)
+		}
+	}
+	if err := m.loadCheckpoint(ctx, operativeID, checkpointID, cp.Workspace, workspace, sp); err != nil {
+		if cp.Workspace {
+			discard()
+		}
+		return nil, err
+	}
+	if cp.Workspace {
+		// Until it is removed, the old volume stays current (see
+		// currentWorkspace).
+		if oldWorkspace != "" {
+			if err := m.client.VolumeRemove(ctx, oldWorkspace, true); err != nil {
+				discard()
+				return nil, fmt.Errorf("removing old workspace volume: %w", err)
+			}
+		}
+		if err := m.removeWorkspaceSnapshots(ctx, operativeID); err != nil {
+			slog.Warn("Failed to remove workspace snapshot from sandbox", "operativeID", operativeID, "error", err)
+		}
+	}
+	return cp, nil
+}
+
+// loadCheckpoint starts the operative's sandbox from the checkpoint image
+// with the given workspace mounted, copies the checkpoint's workspace into
+// it if restoreWorkspace is set, and loads the saved namespace.
+func (m *Manager) loadCheckpoint(ctx context.Context, operativeID, checkpointID string, restoreWorkspace bool, workspace mount.Mount, sp spec) error {
+	if _, err := m.createAndStart(ctx, operativeID, m.checkpointRef(operativeID, checkpointID), workspace, sp); err != nil {
+		return fmt.Errorf("starting sandbox from checkpoint: %w", err)
+	}
+	if restoreWorkspace {
+		if err := m.restoreWorkspace(ctx, operativeID, checkpointID); err != nil {
+			return err
+		}
+	}
+	res, err := m.namespaceRequest(ctx, operativeID, &sandboxv1.ClientMessage{
+		Payload: &sandboxv1.ClientMessage_LoadNamespace{
+			LoadNamespace: &sandboxv1.LoadNamespaceRequest{Path: namespacePath},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if !res.Success {
+		return fmt.Errorf("loading namespace: %s", res.Error)
+	}
+	return nil
+}
+
+// beginCheckpoint marks the operative's sandbox as being checkpointed or
+// restored, which keeps the Run loop from replacing its container and new
+// cells from starting (RunCell waits for it), and returns a function that
+// clears the mark. It fails with ErrCellRunning if a cell is running, or if
+// another checkpoint or restore is in progress.
+func (m *Manager) beginCheckpoint(operativeID string) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cellRuns[operativeID] > 0 {
+		return nil, sandbox.ErrCellRunning
+	}
+	if m.checkpointing[operativeID] {
+		return nil, fmt.Errorf("a checkpoint or restore of the sandbox for operative %s is in progress", operativeID)
+	}
+	m.checkpointing[operativeID] = true
+	return func() {
+		m.mu.Lock()
+		delete(m.checkpointing, operativeID)
+		m.mu.Unlock()
+	}, nil
+}
+
+// getCheckpoint looks up a checkpoint image of the operative.
+func (m *Manager) getCheckpoint(ctx context.Context, operativeID, checkpointID string) (*sandbox.Checkpoint, error) {
+	img, _, err := m.client.ImageInspectWithRaw(ctx, m.checkpointRef(operativeID, checkpointID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", sandbox.ErrCheckpointNotFound, checkpointID)
+	}
+	if img.Config == nil || img.Config.Labels[LabelCheckpointID] != checkpointID {
+		return nil, fmt.Errorf("%w: %s", sandbox.ErrCheckpointNotFound, checkpointID)
+	}
+	created, _ := time.Parse(time.RFC3339Nano, img.Created)
+	cp := checkpointFromLabels(img.Config.Labels, created, img.Size)
+	return &cp, nil
+}
+
+func checkpointFromLabels(labels map[string]string, created time.Time, size int64) sandbox.Checkpoint {
+	cp := sandbox.Checkpoint{
+		ID:          labels[LabelCheckpointID],
+		OperativeID: labels[LabelOperativeID],
+		Image:       labels[LabelImage],
+		CreatedAt:   created.UTC(),
+		Size:        size,
+		Workspace:   labels[LabelCheckpointWorkspace] == "true",
+	}
+	if skipped := labels[LabelCheckpointSkipped]; skipped != "" {
+		cp.SkippedVariables = strings.Split(skipped, ",")
+	}
+	return cp
+}
+
+func (m *Manager) checkpointRef(operativeID, checkpointID string) string {
+	return CheckpointRepository + ":" + operativeID + "-" + checkpointID
+}
+
+// namespaceRequest sends a save/load namespace request to the operative's
+// sandbox and waits for its result.
+func (m *Manager) namespaceRequest(ctx context.Context, operativeID string, req *sandboxv1.ClientMessage) (*sandboxv1.NamespaceResult, error) {
+	conn, err := m.dial(ctx, operativeID)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return rpc.NamespaceRequest(ctx, conn, req)
+}
+
+// removeOrphanCheckpoints deletes checkpoint images of operatives that no
+// longer exist.
+func (m *Manager) removeOrphanCheckpoints(ctx context.Context, known map[string]bool) {
+	images, err := m.client.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", LabelCheckpointID)),
+	})
+	if err != nil {
+		slog.Warn("Failed to list checkpoint images", "error", err)
+		return
+	}
+	for _, img := range images {
+		opID := img.Labels[LabelOperativeID]
+		if known[opID] {
+			continue
+		}
+		slog.Info("Removing orphaned checkpoint", "operativeID", opID, "checkpointID", img.Labels[LabelCheckpointID])
+		if _, err := m.client.ImageRemove(ctx, img.ID, types.ImageRemoveOptions{Force: true, PruneChildren: true}); err != nil {
+			slog.Warn("Failed to remove checkpoint image", "id", img.ID, "error", err)
+		}
+	}
+}
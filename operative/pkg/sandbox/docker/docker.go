@@ -2,20 +2,25 @@ package docker
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
+	"github.com/nstogner/operative/pkg/domain"
 	"github.com/nstogner/operative/pkg/sandbox"
-	sandboxv1 "github.com/nstogner/operative/pkg/sandbox/api"
+	"github.com/nstogner/operative/pkg/sandbox/rpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 const (
@@ -25,18 +30,58 @@ const (
 	LabelManagerValue = "operativesystem"
 	// LabelOperativeID is the label used to identify which operative a container belongs to.
 	LabelOperativeID = "operative-id"
-	// SandboxImage is the default sandbox container image.
+	// LabelSandboxConfig holds a hash of the sandbox settings a container was
+	// created with. Containers whose settings changed are recreated.
+	LabelSandboxConfig = "sandbox-config"
+	// LabelImage records the sandbox image a container, and so its
+	// checkpoints, is based on. Containers whose image changed are recreated.
+	LabelImage = "sandbox-image"
+	// SandboxImage is the default sandbox container image. It also runs the
+	// gateways of isolated sandboxes.
 	SandboxImage = "sandbox-python:latest"
 	// ServerPort is the gRPC port exposed by the sandbox container.
-	ServerPort = "8000"
-	// ReconcileInterval is how often the Run loop checks for drift.
+	ServerPort = rpc.ServerPort
+	// ReconcileInterval is how often the Run loop checks for drift it was
+	// not notified of.
 	ReconcileInterval = 10 * time.Second
+	// DefaultStartTimeout is the default StartTimeout.
+	DefaultStartTimeout = 2 * time.Minute
 )
 
 // Manager implements sandbox.Manager using Docker containers with gRPC.
 type Manager struct {
+	// StartTimeout is how long RunCell waits for a sandbox that is not
+	// running yet, e.g. because its operative was created moments ago or
+	// its container just died. Zero makes RunCell fail right away.
+	StartTimeout time.Duration
+
 	client *client.Client
 	image  string
+
+	cells *rpc.Cells
+
+	mu            sync.Mutex
+	instances     map[string]instance // keyed by operative ID
+	checkpointing map[string]bool     // operatives whose sandbox is being checkpointed or replaced by Restore
+	cellRuns      map[string]int      // RunCell calls in progress, by operative ID
+	starting      map[string]bool     // operatives whose sandbox is being created by start
+	specs         map[string]spec     // keyed by operative ID, as of the last reconcile
+
+	wake   chan struct{} // requests a reconcile from the Run loop
+	events chan sandbox.Event
+}
+
+// spec is the desired sandbox of an operative.
+type spec struct {
+	image  string
+	config domain.SandboxConfig // with defaults applied
+}
+
+// instance identifies one run of an operative's sandbox container. A
+// different container ID or start time means the sandbox was restarted.
+type instance struct {
+	containerID string
+	startedAt   string
 }
 
 // Verify interface compliance.
@@ -48,16 +93,39 @@ func New() (*Manager, error) {
 	if err != nil {
 		return nil, fmt.Errorf("creating docker client: %w", err)
 	}
-	return &Manager{client: cli, image: SandboxImage}, nil
+	return &Manager{
+		StartTimeout:  DefaultStartTimeout,
+		client:        cli,
+		image:         SandboxImage,
+		cells:         rpc.NewCells(),
+		instances:     make(map[string]instance),
+		checkpointing: make(map[string]bool),
+		cellRuns:      make(map[string]int),
+		starting:      make(map[string]bool),
+		specs:         make(map[string]spec),
+		wake:          make(chan struct{}, 1),
+		events:        make(chan sandbox.Event, 64),
+	}, nil
 }
 
-// Run starts a long-running reconciliation loop. It periodically lists
-// known operatives and ensures each has a running sandbox container.
-// Orphan containers (not matching any known operative) are stopped.
+// Run starts a long-running reconciliation loop. It ensures each known
+// operative has a running sandbox container and stops orphan containers (not
+// matching any known operative). It reconciles when a managed container dies
+// or is removed (Docker events), when operatives implements
+// sandbox.OperativeNotifier and an operative changes, when RunCell finds no
+// running sandbox, and every ReconcileInterval to catch anything missed.
 // Blocks until ctx is cancelled.
 func (m *Manager) Run(ctx context.Context, operatives sandbox.OperativeLister) error {
 	slog.Info("Sandbox manager reconciliation loop starting")
 
+	var changes <-chan string
+	if n, ok := operatives.(sandbox.OperativeNotifier); ok {
+		ch, unsubscribe := n.SubscribeOperatives()
+		defer unsubscribe()
+		changes = ch
+	}
+	go m.watchEvents(ctx)
+
 	// Reconcile immediately on start.
 	if err := m.reconcile(ctx, operatives); err != nil {
 		slog.Error("Initial reconciliation failed", "error", err)
@@ -72,18 +140,71 @@ func (m *Manager) Run(ctx context.Context, operatives sandbox.OperativeLister) e
 			slog.Info("Sandbox manager reconciliation loop stopping")
 			return ctx.Err()
 		case <-ticker.C:
-			if err := m.reconcile(ctx, operatives); err != nil {
-				slog.Error("Reconciliation failed", "error", err)
+		case id := <-changes:
+			slog.Debug("Operative changed, reconciling", "operativeID", id)
+		case <-m.wake:
+		}
+		if err := m.reconcile(ctx, operatives); err != nil {
+			slog.Error("Reconciliation failed", "error", err)
+		}
+	}
+}
+
+// watchEvents requests a reconcile whenever a managed container dies, is
+// killed for running out of memory, or is removed, until ctx is cancelled.
+// The subscription is renewed if the event stream fails (e.g. when the
+// daemon restarts).
+func (m *Manager) watchEvents(ctx context.Context) {
+	for {
+		msgs, errs := m.client.Events(ctx, types.EventsOptions{
+			Filters: filters.NewArgs(
+				filters.Arg("type", "container"),
+				filters.Arg("label", LabelManager+"="+LabelManagerValue),
+				filters.Arg("event", "die"),
+				filters.Arg("event", "oom"),
+				filters.Arg("event", "destroy"),
+			),
+		})
+	recv:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-msgs:
+				slog.Debug("Sandbox container event", "action", msg.Action, "operativeID", msg.Actor.Attributes[LabelOperativeID])
+				m.trigger()
+			case err := <-errs:
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Warn("Docker event stream failed, resubscribing", "error", err)
+				break recv
 			}
 		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+		// Events may have been missed in the meantime.
+		m.trigger()
+	}
+}
+
+// trigger requests a reconcile from the Run loop without waiting for it.
+// Requests made while one is pending are coalesced.
+func (m *Manager) trigger() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
 	}
 }
 
 // reconcile compares running containers to known operatives and reconciles.
 func (m *Manager) reconcile(ctx context.Context, operatives sandbox.OperativeLister) error {
-	ids, err := operatives.ListIDs(ctx)
+	ops, err := operatives.List(ctx)
 	if err != nil {
-		return fmt.Errorf("listing operative IDs: %w", err)
+		return fmt.Errorf("listing operatives: %w", err)
 	}
 
 	allContainers, err := m.listAllManagedContainers(ctx)
@@ -91,119 +212,201 @@ func (m *Manager) reconcile(ctx context.Context, operatives sandbox.OperativeLis
 		return fmt.Errorf("listing managed containers: %w", err)
 	}
 
-	knownSet := make(map[string]bool, len(ids))
-	for _, id := range ids {
-		knownSet[id] = true
+	knownSet := make(map[string]bool, len(ops))
+	specs := make(map[string]spec, len(ops))
+	for _, op := range ops {
+		knownSet[op.ID] = true
+		specs[op.ID] = m.specFor(op)
 	}
-
-	runningSet := make(map[string]bool)
+	m.mu.Lock()
+	m.specs = specs
+	m.mu.Unlock()
 
 	// Stop containers for unknown operatives.
+	existing := make(map[string]types.Container)
+	gateways := make(map[string]types.Container)
 	for _, c := range allContainers {
 		opID := c.Labels[LabelOperativeID]
-		runningSet[opID] = true
 		if !knownSet[opID] {
 			slog.Info("Stopping orphaned sandbox", "operativeID", opID)
 			m.stopContainer(ctx, opID)
+			m.mu.Lock()
+			delete(m.instances, opID)
+			m.mu.Unlock()
+			continue
 		}
+		if c.Labels[LabelRole] == RoleGateway {
+			gateways[opID] = c
+			continue
+		}
+		existing[opID] = c
 	}
+	m.removeOrphanCheckpoints(ctx, knownSet)
+	m.removeOrphanWorkspaces(ctx, knownSet)
+
+	// Start containers for known operatives that aren't running, replacing
+	// containers that exited.
+	for _, op := range ops {
+		id := op.ID
+		m.mu.Lock()
+		checkpointing := m.checkpointing[id]
+		m.mu.Unlock()
+		if checkpointing {
+			continue
+		}
 
-	// Start containers for known operatives that aren't running.
-	for _, id := range ids {
-		if !runningSet[id] {
+		sp := specs[id]
+		c, ok := existing[id]
+		switch {
+		case ok && c.State == "running" && c.Labels[LabelImage] != sp.image:
+			slog.Info("Sandbox image changed, recreating", "operativeID", id, "image", sp.image)
+			m.stopContainer(ctx, id)
+			m.start(ctx, id, sp, "the sandbox image was changed to "+sp.image)
+		case ok && c.State == "running" && c.Labels[LabelSandboxConfig] != configHash(sp.config):
+			slog.Info("Sandbox settings changed, recreating", "operativeID", id)
+			m.stopContainer(ctx, id)
+			m.start(ctx, id, sp, "the sandbox settings were changed")
+		case ok && c.State == "running" && isolated(sp.config) && gateways[id].State != "running":
+			slog.Warn("Sandbox gateway is not running, recreating", "operativeID", id)
+			m.stopContainer(ctx, id)
+			m.start(ctx, id, sp, "the sandbox network gateway stopped")
+		case ok && c.State == "running":
+			m.observe(ctx, id, c.ID)
+		case ok:
+			reason := m.exitReason(ctx, c.ID)
+			slog.Warn("Sandbox is not running, recreating", "operativeID", id, "state", c.State, "reason", reason)
+			m.stopContainer(ctx, id)
+			m.start(ctx, id, sp, reason)
+		default:
 			slog.Info("Starting sandbox for operative", "operativeID", id)
-			if _, err := m.createAndStart(ctx, id); err != nil {
-				slog.Error("Failed to start sandbox", "operativeID", id, "error", err)
-			}
+			m.start(ctx, id, sp, "")
 		}
 	}
 
 	return nil
 }
 
-// RunCell executes a code cell in the operative's sandbox via gRPC.
-// The sandbox must already be running (started by the Run loop).
-// Returns an error if the container is not running.
-func (m *Manager) RunCell(ctx context.Context, operativeID, code string, delegate sandbox.Delegate) (*sandbox.Result, error) {
-	hostPort, err := m.getRunningPort(ctx, operativeID)
+// start creates the operative's sandbox container. If the operative already
+// had a sandbox (reason is set, or one was observed before), the restart is
+// reported on Events.
+func (m *Manager) start(ctx context.Context, operativeID string, sp spec, reason string) {
+	m.mu.Lock()
+	_, hadInstance := m.instances[operativeID]
+	m.starting[operativeID] = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.starting, operativeID)
+		m.mu.Unlock()
+	}()
+
+	workspace, err := m.ensureWorkspace(ctx, operativeID)
 	if err != nil {
-		return nil, fmt.Errorf("sandbox not running for operative %s: %w", operativeID, err)
+		slog.Error("Failed to start sandbox", "operativeID", operativeID, "error", err)
+		return
 	}
+	if _, err := m.createAndStart(ctx, operativeID, sp.image, workspace, sp); err != nil {
+		slog.Error("Failed to start sandbox", "operativeID", operativeID, "error", err)
+		return
+	}
+	if reason == "" && hadInstance {
+		reason = "the sandbox container disappeared"
+	}
+	if reason != "" {
+		m.emit(sandbox.Event{OperativeID: operativeID, Reason: reason})
+	}
+}
 
-	// Dial gRPC to the sandbox container.
-	conn, err := grpc.NewClient(
-		fmt.Sprintf("127.0.0.1:%s", hostPort),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
+// observe records the running container of an operative and reports a
+// restart if it differs from the last one seen.
+func (m *Manager) observe(ctx context.Context, operativeID, containerID string) {
+	c, err := m.client.ContainerInspect(ctx, containerID)
 	if err != nil {
-		return nil, fmt.Errorf("dialing sandbox: %w", err)
+		slog.Warn("Failed to inspect sandbox", "operativeID", operativeID, "error", err)
+		return
 	}
-	defer conn.Close()
-
-	sbClient := sandboxv1.NewSandboxClient(conn)
+	current := instance{containerID: c.ID, startedAt: c.State.StartedAt}
+
+	m.mu.Lock()
+	prev, ok := m.instances[operativeID]
+	m.instances[operativeID] = current
+	m.mu.Unlock()
+
+	switch {
+	case !ok || prev == current:
+	case prev.containerID != current.containerID:
+		m.emit(sandbox.Event{OperativeID: operativeID, Reason: "the sandbox container was replaced"})
+	default:
+		m.emit(sandbox.Event{OperativeID: operativeID, Reason: "the sandbox container restarted"})
+	}
+}
 
-	stream, err := sbClient.RunStream(ctx)
+// exitReason describes why a stopped container is not running.
+func (m *Manager) exitReason(ctx context.Context, containerID string) string {
+	c, err := m.client.ContainerInspect(ctx, containerID)
 	if err != nil {
-		return nil, fmt.Errorf("starting stream: %w", err)
+		return "the sandbox container stopped"
 	}
-
-	// Send code execution request.
-	if err := stream.Send(&sandboxv1.ClientMessage{
-		Payload: &sandboxv1.ClientMessage_RunCell{
-			RunCell: &sandboxv1.RunCellRequest{
-				Code: code,
-			},
-		},
-	}); err != nil {
-		return nil, fmt.Errorf("sending run cell request: %w", err)
+	switch {
+	case c.State.OOMKilled:
+		return fmt.Sprintf("the sandbox container ran out of memory and was killed (exit code %d)", c.State.ExitCode)
+	case c.State.Status == "exited":
+		return fmt.Sprintf("the sandbox container exited with code %d", c.State.ExitCode)
+	default:
+		return fmt.Sprintf("the sandbox container stopped (state: %s)", c.State.Status)
 	}
+}
 
-	// Process the stream: handle output, prompt callbacks, and final result.
-	for {
-		msg, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("stream error: %w", err)
-		}
+// emit sends an event without blocking the reconciliation loop.
+func (m *Manager) emit(e sandbox.Event) {
+	slog.Warn("Sandbox restarted", "operativeID", e.OperativeID, "reason", e.Reason)
+	select {
+	case m.events <- e:
+	default:
+		slog.Error("Dropping sandbox event, no receiver", "operativeID", e.OperativeID)
+	}
+}
 
-		switch payload := msg.Payload.(type) {
-		case *sandboxv1.ServerMessage_Output:
-			slog.Debug("sandbox output", "text", payload.Output.Text, "stderr", payload.Output.IsStderr)
-
-		case *sandboxv1.ServerMessage_RunCellResult:
-			return &sandbox.Result{
-				Output: payload.RunCellResult.Output,
-				Stdout: payload.RunCellResult.Stdout,
-				Stderr: payload.RunCellResult.Stderr,
-			}, nil
-
-		case *sandboxv1.ServerMessage_PromptModel:
-			resp, err := delegate.PromptModel(ctx, payload.PromptModel.Prompt)
-			responseVal := resp
-			if err != nil {
-				responseVal = fmt.Sprintf("Error: %v", err)
-			}
-			if err := stream.Send(&sandboxv1.ClientMessage{
-				Payload: &sandboxv1.ClientMessage_PromptModelResponse{
-					PromptModelResponse: &sandboxv1.PromptModelResponse{
-						Id:       payload.PromptModel.Id,
-						Response: responseVal,
-					},
-				},
-			}); err != nil {
-				return nil, fmt.Errorf("sending prompt response: %w", err)
-			}
+// Events returns the channel of sandbox restart events.
+func (m *Manager) Events() <-chan sandbox.Event {
+	return m.events
+}
 
-		case *sandboxv1.ServerMessage_PromptSelf:
-			if err := delegate.PromptSelf(ctx, payload.PromptSelf.Message); err != nil {
-				slog.Error("prompt self failed", "error", err)
-			}
+// RunCell executes a code cell in the operative's sandbox via gRPC.
+// The sandbox is started by the Run loop; RunCell waits up to StartTimeout
+// for one that is not running yet. Returns an error if the container is
+// not running by then.
+func (m *Manager) RunCell(ctx context.Context, operativeID, code string, delegate sandbox.Delegate) (*sandbox.Result, error) {
+	m.waitForSandbox(ctx, operativeID)
+
+	// Register the run under the same lock that beginCheckpoint takes, so
+	// that no cell starts while the sandbox is checkpointed or restored.
+	m.mu.Lock()
+	if m.checkpointing[operativeID] {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("sandbox for operative %s is being checkpointed or restored", operativeID)
+	}
+	m.cellRuns[operativeID]++
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		if m.cellRuns[operativeID]--; m.cellRuns[operativeID] == 0 {
+			delete(m.cellRuns, operativeID)
 		}
+		m.mu.Unlock()
+	}()
+
+	conn, err := m.dial(ctx, operativeID)
+	if err != nil {
+		return nil, err
 	}
+	defer conn.Close()
+	return m.cells.Run(ctx, conn, operativeID, code, delegate)
+}
 
-	return nil, fmt.Errorf("stream ended without result")
+// Interrupt sends a CancelRequest on the operative's in-flight RunCell stream.
+func (m *Manager) Interrupt(ctx context.Context, operativeID string) error {
+	return m.cells.Interrupt(operativeID)
 }
 
 // Status returns the status of the operative's sandbox.
@@ -212,10 +415,12 @@ func (m *Manager) Status(ctx context.Context, operativeID string) (string, error
 	if err != nil {
 		return "unknown", err
 	}
-	if len(containers) == 0 {
-		return "stopped", nil
+	for _, c := range containers {
+		if c.Labels[LabelRole] != RoleGateway {
+			return c.State, nil
+		}
 	}
-	return containers[0].State, nil
+	return "stopped", nil
 }
 
 // Close releases the Docker client resources.
@@ -225,6 +430,48 @@ func (m *Manager) Close() error {
 
 // --- internal helpers ---
 
+// waitForSandbox waits up to StartTimeout for the operative's sandbox to be
+// running, and not being started, checkpointed or restored. It asks the Run loop to
+// reconcile in case the loop has not heard of the operative or of the death
+// of its container yet. If the sandbox does not come up in time, dial
+// reports why.
+func (m *Manager) waitForSandbox(ctx context.Context, operativeID string) {
+	if m.StartTimeout <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.StartTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		m.mu.Lock()
+		busy := m.starting[operativeID] || m.checkpointing[operativeID]
+		m.mu.Unlock()
+		if !busy {
+			if _, err := m.getRunningPort(ctx, operativeID); err == nil {
+				return
+			}
+			m.trigger()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dial connects to the gRPC server of the operative's running sandbox.
+func (m *Manager) dial(ctx context.Context, operativeID string) (*grpc.ClientConn, error) {
+	hostPort, err := m.getRunningPort(ctx, operativeID)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox not running for operative %s: %w", operativeID, err)
+	}
+	return rpc.Dial("127.0.0.1:" + hostPort)
+}
+
 // getRunningPort returns the host port for a running container, or error if not running.
 func (m *Manager) getRunningPort(ctx context.Context, operativeID string) (string, error) {
 	containerName := m.containerName(operativeID)
@@ -235,41 +482,81 @@ func (m *Manager) getRunningPort(ctx context.Context, operativeID string) (strin
 	if !c.State.Running {
 		return "", fmt.Errorf("container exists but not running (state: %s)", c.State.Status)
 	}
+	if c.HostConfig.NetworkMode == container.NetworkMode(m.networkName(operativeID)) {
+		// Isolated sandboxes are reached through their gateway.
+		if c, err = m.client.ContainerInspect(ctx, m.gatewayName(operativeID)); err != nil {
+			return "", fmt.Errorf("gateway not found: %w", err)
+		}
+		if !c.State.Running {
+			return "", fmt.Errorf("gateway exists but not running (state: %s)", c.State.Status)
+		}
+	}
 	return m.getPort(c)
 }
 
-// createAndStart creates a new sandbox container and starts it.
-func (m *Manager) createAndStart(ctx context.Context, operativeID string) (string, error) {
+// createAndStart creates a new sandbox container from the given image (the
+// spec's image or a checkpoint of it) with the spec's settings and the given
+// workspace mounted, and starts it, along with its gateway if the sandbox is
+// isolated.
+func (m *Manager) createAndStart(ctx context.Context, operativeID, image string, workspace mount.Mount, sp spec) (string, error) {
+	sbCfg := sp.config
 	// Ensure image exists locally.
-	_, _, err := m.client.ImageInspectWithRaw(ctx, m.image)
+	_, _, err := m.client.ImageInspectWithRaw(ctx, image)
 	if err != nil {
-		return "", fmt.Errorf("sandbox image '%s' not found — run 'make build-sandbox': %w", m.image, err)
+		return "", fmt.Errorf("sandbox image '%s' not found — run 'make build-sandbox': %w", image, err)
 	}
 
 	cfg := &container.Config{
-		Image: m.image,
+		Image:      image,
+		WorkingDir: WorkspacePath,
+		Env:        proxyEnv(sbCfg),
 		Labels: map[string]string{
-			LabelManager:     LabelManagerValue,
-			LabelOperativeID: operativeID,
+			LabelManager:       LabelManagerValue,
+			LabelOperativeID:   operativeID,
+			LabelSandboxConfig: configHash(sbCfg),
+			LabelImage:         sp.image,
 		},
 		ExposedPorts: nat.PortSet{
 			nat.Port(ServerPort + "/tcp"): {},
 		},
 	}
 
+	memory := sbCfg.MemoryMB << 20
+	pids := sbCfg.PidsLimit
 	hostCfg := &container.HostConfig{
-		PortBindings: nat.PortMap{
-			nat.Port(ServerPort + "/tcp"): []nat.PortBinding{
-				{
-					HostIP:   "127.0.0.1",
-					HostPort: "0", // Dynamically assigned port.
-				},
-			},
+		Mounts: []mount.Mount{workspace},
+		Resources: container.Resources{
+			Memory:     memory,
+			MemorySwap: memory, // no swap on top of the memory limit
+			NanoCPUs:   int64(sbCfg.CPUs * 1e9),
+			PidsLimit:  &pids,
 		},
+		ReadonlyRootfs: sbCfg.ReadOnlyRootFS,
+	}
+	if sbCfg.ReadOnlyRootFS {
+		// Scratch space for Python, IPython and pip; /workspace stays the
+		// only persistent writable directory.
+		hostCfg.Tmpfs = map[string]string{"/tmp": "", "/root": "", "/var/lib/operative": ""}
+	}
+
+	var netCfg *network.NetworkingConfig
+	if isolated(sbCfg) {
+		networkName, err := m.ensureNetwork(ctx, operativeID)
+		if err != nil {
+			return "", err
+		}
+		hostCfg.NetworkMode = container.NetworkMode(networkName)
+		netCfg = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				networkName: {Aliases: []string{sandboxAlias}},
+			},
+		}
+	} else {
+		hostCfg.PortBindings = serverPortBindings()
 	}
 
 	containerName := m.containerName(operativeID)
-	resp, err := m.client.ContainerCreate(ctx, cfg, hostCfg, nil, nil, containerName)
+	resp, err := m.client.ContainerCreate(ctx, cfg, hostCfg, netCfg, nil, containerName)
 	if err != nil {
 		return "", fmt.Errorf("creating container: %w", err)
 	}
@@ -282,19 +569,28 @@ func (m *Manager) createAndStart(ctx context.Context, operativeID string) (strin
 	if err != nil {
 		return "", err
 	}
-	port, err := m.getPort(c)
+	m.mu.Lock()
+	m.instances[operativeID] = instance{containerID: c.ID, startedAt: c.State.StartedAt}
+	m.mu.Unlock()
+	var port string
+	if isolated(sbCfg) {
+		port, err = m.startGateway(ctx, operativeID, string(hostCfg.NetworkMode), sbCfg)
+	} else {
+		port, err = m.getPort(c)
+	}
 	if err != nil {
 		return "", err
 	}
 
-	if err := m.waitForHealth(ctx, port); err != nil {
+	if err := rpc.WaitForHealth(ctx, "127.0.0.1:"+port); err != nil {
 		return "", err
 	}
 	slog.Info("Sandbox started", "operativeID", operativeID, "port", port)
 	return port, nil
 }
 
-// stopContainer stops and removes a container for the given operative.
+// stopContainer stops and removes the containers (sandbox and gateway) and
+// the network of the given operative.
 func (m *Manager) stopContainer(ctx context.Context, operativeID string) {
 	containers, err := m.listContainers(ctx, operativeID)
 	if err != nil {
@@ -310,6 +606,47 @@ func (m *Manager) stopContainer(ctx context.Context, operativeID string) {
 			slog.Warn("Failed to remove container", "id", c.ID, "error", err)
 		}
 	}
+	m.removeNetwork(ctx, operativeID)
+}
+
+// ValidateImage checks that the image exists locally.
+func (m *Manager) ValidateImage(ctx context.Context, image string) error {
+	if image == "" {
+		image = m.image
+	}
+	if _, _, err := m.client.ImageInspectWithRaw(ctx, image); err != nil {
+		if client.IsErrNotFound(err) {
+			return fmt.Errorf("%w: %s", sandbox.ErrImageNotFound, image)
+		}
+		return fmt.Errorf("inspecting image %s: %w", image, err)
+	}
+	return nil
+}
+
+// specFor returns the desired sandbox of an operative.
+func (m *Manager) specFor(op domain.Operative) spec {
+	sp := spec{image: op.Image, config: op.Sandbox.WithDefaults()}
+	if sp.image == "" {
+		sp.image = m.image
+	}
+	return sp
+}
+
+// spec returns the operative's desired sandbox as of the last reconcile.
+func (m *Manager) spec(operativeID string) spec {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if sp, ok := m.specs[operativeID]; ok {
+		return sp
+	}
+	return m.specFor(domain.Operative{ID: operativeID})
+}
+
+// configHash identifies sandbox settings in the LabelSandboxConfig label.
+func configHash(cfg domain.SandboxConfig) string {
+	b, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
 }
 
 func (m *Manager) containerName(operativeID string) string {
@@ -324,32 +661,6 @@ func (m *Manager) getPort(c types.ContainerJSON) (string, error) {
 	return "", fmt.Errorf("container running but port not mapped")
 }
 
-func (m *Manager) waitForHealth(ctx context.Context, port string) error {
-	timeoutCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
-	defer cancel()
-
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-timeoutCtx.Done():
-			return fmt.Errorf("timeout waiting for sandbox gRPC port")
-		case <-ticker.C:
-			dialCtx, dialCancel := context.WithTimeout(timeoutCtx, 1*time.Second)
-			conn, err := grpc.DialContext(dialCtx, fmt.Sprintf("127.0.0.1:%s", port),
-				grpc.WithTransportCredentials(insecure.NewCredentials()),
-				grpc.WithBlock(),
-			)
-			dialCancel()
-			if err == nil {
-				conn.Close()
-				return nil
-			}
-		}
-	}
-}
-
 func (m *Manager) listContainers(ctx context.Context, operativeID string) ([]types.Container, error) {
 	return m.client.ContainerList(ctx, types.ContainerListOptions{
 		All: true,
@@ -1,13 +1,19 @@
 package docker
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/docker/docker/api/types"
+	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/sandbox"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -28,22 +34,62 @@ func (d *stubDelegate) PromptModel(ctx context.Context, prompt string) (string,
 func (d *stubDelegate) PromptSelf(ctx context.Context, message string) error {
 	return nil
 }
+func (d *stubDelegate) Output(ctx context.Context, text string, isStderr bool) {}
 
 const testOperativeID = "integration-test-operative"
 
-// staticLister implements sandbox.OperativeLister with a fixed list of IDs.
+// staticLister implements sandbox.OperativeLister with a fixed list of IDs,
+// all with the same sandbox image and settings.
 type staticLister struct {
-	ids []string
+	ids    []string
+	image  string
+	config domain.SandboxConfig
 }
 
-func (l *staticLister) ListIDs(ctx context.Context) ([]string, error) {
-	return l.ids, nil
+func (l *staticLister) List(ctx context.Context) ([]domain.Operative, error) {
+	ops := make([]domain.Operative, len(l.ids))
+	for i, id := range l.ids {
+		ops[i] = domain.Operative{ID: id, Image: l.image, Sandbox: l.config}
+	}
+	return ops, nil
 }
 
-// setupManagerAndRun creates a Docker Manager, starts the Run loop with the
-// test operative, waits for the sandbox to be fully ready (gRPC reachable),
-// and returns the manager.
-func setupManagerAndRun(t *testing.T) (*Manager, context.CancelFunc) {
+// notifyingLister implements sandbox.OperativeLister and
+// sandbox.OperativeNotifier with a list of IDs that can change.
+type notifyingLister struct {
+	mu      sync.Mutex
+	ids     []string
+	changes chan string
+}
+
+func (l *notifyingLister) List(ctx context.Context) ([]domain.Operative, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var ops []domain.Operative
+	for _, id := range l.ids {
+		ops = append(ops, domain.Operative{ID: id})
+	}
+	return ops, nil
+}
+
+func (l *notifyingLister) SubscribeOperatives() (<-chan string, func()) {
+	return l.changes, func() {}
+}
+
+// set replaces the IDs and notifies about the created and deleted ones.
+func (l *notifyingLister) set(ids ...string) {
+	l.mu.Lock()
+	changed := append(l.ids, ids...)
+	l.ids = ids
+	l.mu.Unlock()
+	for _, id := range changed {
+		l.changes <- id
+	}
+}
+
+// newTestManager creates a Docker Manager, skipping the test if Docker is
+// not available.
+func newTestManager(t *testing.T) *Manager {
 	t.Helper()
 	mgr, err := New()
 	if err != nil {
@@ -58,6 +104,15 @@ func setupManagerAndRun(t *testing.T) (*Manager, context.CancelFunc) {
 		mgr.Close()
 		t.Skipf("Docker daemon not responsive: %v", err)
 	}
+	return mgr
+}
+
+// setupManagerAndRun creates a Docker Manager, starts the Run loop with the
+// test operative, waits for the sandbox to be fully ready (gRPC reachable),
+// and returns the manager.
+func setupManagerAndRun(t *testing.T) (*Manager, context.CancelFunc) {
+	t.Helper()
+	mgr := newTestManager(t)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -116,6 +171,7 @@ func cleanupManager(mgr *Manager, cancel context.CancelFunc, t *testing.T) {
 	ctx, c := context.WithTimeout(context.Background(), 30*time.Second)
 	defer c()
 	mgr.stopContainer(ctx, testOperativeID)
+	mgr.removeOrphanWorkspaces(ctx, nil)
 	mgr.Close()
 }
 
@@ -211,9 +267,370 @@ func TestIntegrationRunCellError(t *testing.T) {
 		t.Fatalf("RunCell: %v", err)
 	}
 
-	output := result.Output + result.Stderr
-	if !strings.Contains(output, "ZeroDivisionError") {
-		t.Errorf("expected ZeroDivisionError in output, got %q", output)
+	if result.Success {
+		t.Fatal("expected Success to be false")
+	}
+	if result.Error == nil || result.Error.Name != "ZeroDivisionError" || result.Error.Value != "division by zero" {
+		t.Fatalf("Error = %+v, want ZeroDivisionError: division by zero", result.Error)
+	}
+	if !strings.Contains(result.Error.Traceback, "ZeroDivisionError") {
+		t.Errorf("expected ZeroDivisionError in traceback, got %q", result.Error.Traceback)
+	}
+	if strings.Contains(result.Error.Traceback, "\x1b[") {
+		t.Errorf("traceback contains ANSI color codes: %q", result.Error.Traceback)
+	}
+}
+
+// TestIntegrationRunCellSyntaxError verifies that syntax errors are reported
+// as failures.
+func TestIntegrationRunCellSyntaxError(t *testing.T) {
+	mgr, cancel := setupManagerAndRun(t)
+	defer cleanupManager(mgr, cancel, t)
+
+	ctx, c := context.WithTimeout(context.Background(), 30*time.Second)
+	defer c()
+
+	result, err := mgr.RunCell(ctx, testOperativeID, "def f(:", &stubDelegate{})
+	if err != nil {
+		t.Fatalf("RunCell: %v", err)
+	}
+	if result.Success || result.Error == nil || result.Error.Name != "SyntaxError" {
+		t.Errorf("got success=%v error=%+v, want SyntaxError", result.Success, result.Error)
+	}
+
+	// A successful cell reports success.
+	result, err = mgr.RunCell(ctx, testOperativeID, "1+1", &stubDelegate{})
+	if err != nil {
+		t.Fatalf("RunCell: %v", err)
+	}
+	if !result.Success || result.Error != nil {
+		t.Errorf("got success=%v error=%+v, want success", result.Success, result.Error)
+	}
+}
+
+// TestIntegrationRunCellDisplays verifies that rich display outputs are
+// returned in their richest MIME type, with the text fallback in stdout.
+func TestIntegrationRunCellDisplays(t *testing.T) {
+	mgr, cancel := setupManagerAndRun(t)
+	defer cleanupManager(mgr, cancel, t)
+
+	ctx, c := context.WithTimeout(context.Background(), 60*time.Second)
+	defer c()
+
+	code := `from IPython.display import HTML, display
+import matplotlib.pyplot as plt
+display(HTML("<b>bold</b>"))
+plt.plot([1, 2, 3])
+plt.show()`
+	result, err := mgr.RunCell(ctx, testOperativeID, code, &stubDelegate{})
+	if err != nil {
+		t.Fatalf("RunCell: %v", err)
+	}
+	if !result.Success {
+		t.Fatalf("cell failed: %+v\n%s", result.Error, result.Output)
+	}
+	if len(result.Displays) != 2 {
+		t.Fatalf("got %d displays, want 2: %+v", len(result.Displays), result.Displays)
+	}
+	if d := result.Displays[0]; d.MIMEType != "text/html" || string(d.Data) != "<b>bold</b>" {
+		t.Errorf("displays[0] = %s %q, want text/html", d.MIMEType, d.Data)
+	}
+	if d := result.Displays[1]; d.MIMEType != "image/png" || !bytes.HasPrefix(d.Data, []byte("\x89PNG")) {
+		t.Errorf("displays[1] = %s (%d bytes), want raw PNG", d.MIMEType, len(d.Data))
+	}
+	if !strings.Contains(result.Stdout, "<IPython.core.display.HTML object>") {
+		t.Errorf("stdout = %q, want the text/plain fallback", result.Stdout)
+	}
+}
+
+// TestIntegrationRestartEvent verifies that a crashed sandbox is recreated
+// and reported on Events.
+func TestIntegrationRestartEvent(t *testing.T) {
+	mgr, cancel := setupManagerAndRun(t)
+	defer cleanupManager(mgr, cancel, t)
+
+	ctx, c := context.WithTimeout(context.Background(), 120*time.Second)
+	defer c()
+
+	// The Run loop hears of the container's death and replaces it.
+	if err := mgr.client.ContainerKill(ctx, mgr.containerName(testOperativeID), "SIGKILL"); err != nil {
+		t.Fatalf("killing sandbox: %v", err)
+	}
+
+	select {
+	case e := <-mgr.Events():
+		if e.OperativeID != testOperativeID || !strings.Contains(e.Reason, "exited with code 137") {
+			t.Errorf("event = %+v, want exit code 137 for %s", e, testOperativeID)
+		}
+	case <-ctx.Done():
+		t.Fatal("no restart event")
+	}
+
+	result, err := mgr.RunCell(ctx, testOperativeID, "1+1", &stubDelegate{})
+	if err != nil {
+		t.Fatalf("RunCell after restart: %v", err)
+	}
+	if got := stripOut(result.Output); got != "2" {
+		t.Errorf("output = %q, want 2", got)
+	}
+}
+
+// TestIntegrationOperativeNotifications verifies that a sandbox is started
+// as soon as its operative is created, and that RunCell waits for it.
+func TestIntegrationOperativeNotifications(t *testing.T) {
+	mgr := newTestManager(t)
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cleanupManager(mgr, cancel, t)
+
+	lister := &notifyingLister{changes: make(chan string, 1)}
+	go mgr.Run(runCtx, lister)
+
+	ctx, c := context.WithTimeout(context.Background(), 120*time.Second)
+	defer c()
+
+	lister.set(testOperativeID)
+	start := time.Now()
+	result, err := mgr.RunCell(ctx, testOperativeID, "1+1", &stubDelegate{})
+	if err != nil {
+		t.Fatalf("RunCell on a new operative: %v", err)
+	}
+	if got := stripOut(result.Output); got != "2" {
+		t.Errorf("output = %q, want 2", got)
+	}
+	t.Logf("first cell ran after %s", time.Since(start))
+
+	lister.set()
+	for {
+		if status, _ := mgr.Status(ctx, testOperativeID); status == "stopped" {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("sandbox of the deleted operative was not stopped")
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// TestIntegrationWorkspacePersists verifies that files in the workspace
+// survive the sandbox being recreated.
+func TestIntegrationWorkspacePersists(t *testing.T) {
+	mgr, cancel := setupManagerAndRun(t)
+	defer cleanupManager(mgr, cancel, t)
+
+	ctx, c := context.WithTimeout(context.Background(), 120*time.Second)
+	defer c()
+
+	result, err := mgr.RunCell(ctx, testOperativeID, "import os\nopen('kept.txt', 'w').write('hello')\nos.getcwd()", &stubDelegate{})
+	if err != nil {
+		t.Fatalf("RunCell: %v", err)
+	}
+	if got := stripOut(result.Output); got != "'"+WorkspacePath+"'" {
+		t.Errorf("cwd = %s, want %s", got, WorkspacePath)
+	}
+
+	if err := mgr.client.ContainerKill(ctx, mgr.containerName(testOperativeID), "SIGKILL"); err != nil {
+		t.Fatalf("killing sandbox: %v", err)
+	}
+	select {
+	case <-mgr.Events():
+	case <-ctx.Done():
+		t.Fatal("sandbox was not restarted")
+	}
+
+	result, err = mgr.RunCell(ctx, testOperativeID, "open('/workspace/kept.txt').read()", &stubDelegate{})
+	if err != nil {
+		t.Fatalf("RunCell after restart: %v", err)
+	}
+	if got := stripOut(result.Output); got != "'hello'" {
+		t.Errorf("file = %q, want 'hello'", got)
+	}
+}
+
+// TestIntegrationNetworkPolicy verifies that an isolated sandbox can only
+// reach allowed hosts, and that changed sandbox settings are applied by
+// recreating the sandbox.
+func TestIntegrationNetworkPolicy(t *testing.T) {
+	mgr := newTestManager(t)
+	defer cleanupManager(mgr, func() {}, t)
+
+	ctx, c := context.WithTimeout(context.Background(), 180*time.Second)
+	defer c()
+
+	fetch := func(url string) string {
+		t.Helper()
+		code := fmt.Sprintf("import urllib.request\ntry:\n    r = urllib.request.urlopen(%q, timeout=10).status\nexcept Exception as e:\n    r = type(e).__name__\nr", url)
+		result, err := mgr.RunCell(ctx, testOperativeID, code, &stubDelegate{})
+		if err != nil {
+			t.Fatalf("RunCell: %v", err)
+		}
+		return stripOut(result.Output)
+	}
+
+	// The Run loop is not started, so the settings are only changed here.
+	lister := &staticLister{
+		ids: []string{testOperativeID},
+		config: domain.SandboxConfig{
+			MemoryMB:     512,
+			Network:      domain.NetworkAllowlist,
+			AllowedHosts: []string{"example.com"},
+		},
+	}
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	inspect, err := mgr.client.ContainerInspect(ctx, mgr.containerName(testOperativeID))
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if got := inspect.HostConfig.Memory; got != 512<<20 {
+		t.Errorf("memory limit = %d, want %d", got, 512<<20)
+	}
+	if got := fetch("https://example.com"); got != "200" {
+		t.Errorf("allowed host: got %s, want 200", got)
+	}
+	if got := fetch("https://www.google.com"); got == "200" {
+		t.Error("request to a host not on the allowlist succeeded")
+	}
+
+	lister.config = domain.SandboxConfig{Network: domain.NetworkNone}
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	select {
+	case e := <-mgr.Events():
+		if !strings.Contains(e.Reason, "settings") {
+			t.Errorf("event = %+v, want settings change", e)
+		}
+	default:
+		t.Error("no restart event after the settings changed")
+	}
+	if got := fetch("https://example.com"); got == "200" {
+		t.Error("request from a sandbox without network succeeded")
+	}
+}
+
+// TestIntegrationImageChange verifies that a sandbox is recreated when its
+// operative switches images.
+func TestIntegrationImageChange(t *testing.T) {
+	mgr := newTestManager(t)
+	defer cleanupManager(mgr, func() {}, t)
+
+	ctx, c := context.WithTimeout(context.Background(), 180*time.Second)
+	defer c()
+
+	const altImage = "sandbox-python:integration-alt"
+	if err := mgr.client.ImageTag(ctx, SandboxImage, altImage); err != nil {
+		t.Fatalf("tagging image: %v", err)
+	}
+	defer mgr.client.ImageRemove(context.Background(), altImage, types.ImageRemoveOptions{})
+
+	if err := mgr.ValidateImage(ctx, "no-such-image:latest"); !errors.Is(err, sandbox.ErrImageNotFound) {
+		t.Errorf("ValidateImage(missing) error = %v, want ErrImageNotFound", err)
+	}
+
+	lister := &staticLister{ids: []string{testOperativeID}}
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	lister.image = altImage
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	select {
+	case e := <-mgr.Events():
+		if !strings.Contains(e.Reason, altImage) {
+			t.Errorf("event = %+v, want image change to %s", e, altImage)
+		}
+	default:
+		t.Fatal("no restart event after the image changed")
+	}
+	inspect, err := mgr.client.ContainerInspect(ctx, mgr.containerName(testOperativeID))
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if inspect.Config.Image != altImage {
+		t.Errorf("image = %s, want %s", inspect.Config.Image, altImage)
+	}
+}
+
+// TestIntegrationCheckpointRestore verifies that restoring a checkpoint
+// brings back both files and variables.
+func TestIntegrationCheckpointRestore(t *testing.T) {
+	mgr, cancel := setupManagerAndRun(t)
+	defer cleanupManager(mgr, cancel, t)
+
+	ctx, c := context.WithTimeout(context.Background(), 120*time.Second)
+	defer c()
+
+	run := func(code string) string {
+		t.Helper()
+		result, err := mgr.RunCell(ctx, testOperativeID, code, &stubDelegate{})
+		if err != nil {
+			t.Fatalf("RunCell(%q): %v", code, err)
+		}
+		return stripOut(result.Output)
+	}
+
+	run("x = 41\nopen('/tmp/marker', 'w').write('saved')\nopen('/workspace/data', 'w').write('saved')\ngen = (i for i in range(3))")
+	cp, err := mgr.Checkpoint(ctx, testOperativeID)
+	if err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	defer mgr.client.ImageRemove(context.Background(), mgr.checkpointRef(testOperativeID, cp.ID), types.ImageRemoveOptions{Force: true})
+	if !cp.Workspace {
+		t.Error("checkpoint does not include the workspace")
+	}
+	if len(cp.SkippedVariables) != 1 || cp.SkippedVariables[0] != "gen" {
+		t.Errorf("skipped variables = %v, want [gen]", cp.SkippedVariables)
+	}
+
+	checkpoints, err := mgr.ListCheckpoints(ctx, testOperativeID)
+	if err != nil {
+		t.Fatalf("ListCheckpoints: %v", err)
+	}
+	if len(checkpoints) == 0 || checkpoints[0].ID != cp.ID {
+		t.Fatalf("checkpoints = %+v, want %s first", checkpoints, cp.ID)
+	}
+
+	run("x = 0\nopen('/tmp/marker', 'w').write('changed')\nopen('/workspace/data', 'w').write('changed')\nopen('/workspace/new', 'w').write('new')")
+	if _, err := mgr.Restore(ctx, testOperativeID, cp.ID); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got := run("x"); got != "41" {
+		t.Errorf("x = %q, want 41", got)
+	}
+	if got := run("open('/tmp/marker').read()"); got != "'saved'" {
+		t.Errorf("marker = %q, want 'saved'", got)
+	}
+	if got := run("open('/workspace/data').read()"); got != "'saved'" {
+		t.Errorf("workspace data = %q, want 'saved'", got)
+	}
+	if got := run("import os; os.path.exists('/workspace/new')"); got != "False" {
+		t.Errorf("file created after the checkpoint exists: %q", got)
+	}
+	if got := run("import os; os.path.exists('" + workspaceSnapshotRoot + "')"); got != "False" {
+		t.Errorf("workspace snapshot left in the restored sandbox: %q", got)
+	}
+	if name, err := mgr.currentWorkspace(ctx, testOperativeID); err != nil || name == mgr.workspaceName(testOperativeID) {
+		t.Errorf("workspace volume after restore = %q, %v; want a new volume", name, err)
+	}
+
+	// A checkpoint of the restored sandbox does not inherit the skipped
+	// variables of the image it runs.
+	again, err := mgr.Checkpoint(ctx, testOperativeID)
+	if err != nil {
+		t.Fatalf("Checkpoint after restore: %v", err)
+	}
+	defer mgr.client.ImageRemove(context.Background(), mgr.checkpointRef(testOperativeID, again.ID), types.ImageRemoveOptions{Force: true})
+	if len(again.SkippedVariables) != 0 {
+		t.Errorf("skipped variables after restore = %v, want none", again.SkippedVariables)
+	}
+
+	if _, err := mgr.Restore(ctx, testOperativeID, "missing"); !errors.Is(err, sandbox.ErrCheckpointNotFound) {
+		t.Errorf("Restore(missing) error = %v, want ErrCheckpointNotFound", err)
 	}
 }
 
@@ -226,6 +643,8 @@ func TestIntegrationRunCellNotRunning(t *testing.T) {
 	}
 	defer mgr.Close()
 
+	mgr.StartTimeout = 0
+
 	ctx, c := context.WithTimeout(context.Background(), 10*time.Second)
 	defer c()
 
@@ -294,9 +713,103 @@ print(f"Model says: {result}")`
 	}
 }
 
+// TestIntegrationRunCellStreamsOutput verifies that output is delivered to
+// the delegate while the cell is still running.
+func TestIntegrationRunCellStreamsOutput(t *testing.T) {
+	mgr, cancel := setupManagerAndRun(t)
+	defer cleanupManager(mgr, cancel, t)
+
+	ctx, c := context.WithTimeout(context.Background(), 30*time.Second)
+	defer c()
+
+	code := `import sys
+print("to stdout")
+print("to stderr", file=sys.stderr)`
+
+	delegate := &recordingDelegate{}
+	result, err := mgr.RunCell(ctx, testOperativeID, code, delegate)
+	if err != nil {
+		t.Fatalf("RunCell: %v", err)
+	}
+
+	if got := delegate.stdout.String(); !strings.Contains(got, "to stdout") {
+		t.Errorf("streamed stdout = %q, want it to contain %q", got, "to stdout")
+	}
+	if got := delegate.stderr.String(); !strings.Contains(got, "to stderr") {
+		t.Errorf("streamed stderr = %q, want it to contain %q", got, "to stderr")
+	}
+	if !strings.Contains(result.Stdout, "to stdout") {
+		t.Errorf("result stdout = %q", result.Stdout)
+	}
+}
+
+// TestIntegrationInterrupt verifies that Interrupt stops a long-running cell
+// and that the result reports the interruption.
+func TestIntegrationInterrupt(t *testing.T) {
+	mgr, cancel := setupManagerAndRun(t)
+	defer cleanupManager(mgr, cancel, t)
+
+	ctx, c := context.WithTimeout(context.Background(), 30*time.Second)
+	defer c()
+
+	if err := mgr.Interrupt(ctx, testOperativeID); !errors.Is(err, sandbox.ErrNoRunningCell) {
+		t.Errorf("Interrupt with no cell running = %v, want ErrNoRunningCell", err)
+	}
+
+	started := make(chan struct{})
+	var once sync.Once
+	delegate := &recordingDelegate{
+		outputFn: func(text string, isStderr bool) {
+			if strings.Contains(text, "sleeping") {
+				once.Do(func() { close(started) })
+			}
+		},
+	}
+
+	go func() {
+		select {
+		case <-started:
+			if err := mgr.Interrupt(ctx, testOperativeID); err != nil {
+				t.Errorf("Interrupt: %v", err)
+			}
+		case <-ctx.Done():
+		}
+	}()
+
+	result, err := mgr.RunCell(ctx, testOperativeID, `import time
+print("sleeping", flush=True)
+time.sleep(60)
+print("finished")`, delegate)
+	if err != nil {
+		t.Fatalf("RunCell: %v", err)
+	}
+
+	if !result.Interrupted {
+		t.Error("expected result to be marked interrupted")
+	}
+	if strings.Contains(result.Output, "finished") {
+		t.Errorf("cell ran to completion: %q", result.Output)
+	}
+	if result.Success || result.Error == nil || result.Error.Name != "KeyboardInterrupt" {
+		t.Errorf("expected KeyboardInterrupt error, got success=%v error=%+v", result.Success, result.Error)
+	}
+
+	// The kernel must still be usable afterwards.
+	result, err = mgr.RunCell(ctx, testOperativeID, "1+1", &stubDelegate{})
+	if err != nil {
+		t.Fatalf("RunCell after interrupt: %v", err)
+	}
+	if got := stripOut(result.Output); got != "2" {
+		t.Errorf("output after interrupt = %q, want %q", got, "2")
+	}
+}
+
 // recordingDelegate records calls to delegate methods for assertions.
 type recordingDelegate struct {
 	promptModelFn func(ctx context.Context, prompt string) (string, error)
+	outputFn      func(text string, isStderr bool)
+
+	stdout, stderr strings.Builder
 }
 
 func (d *recordingDelegate) PromptModel(ctx context.Context, prompt string) (string, error) {
@@ -309,3 +822,14 @@ func (d *recordingDelegate) PromptModel(ctx context.Context, prompt string) (str
 func (d *recordingDelegate) PromptSelf(ctx context.Context, message string) error {
 	return nil
 }
+
+func (d *recordingDelegate) Output(ctx context.Context, text string, isStderr bool) {
+	if d.outputFn != nil {
+		d.outputFn(text, isStderr)
+	}
+	if isStderr {
+		d.stderr.WriteString(text)
+	} else {
+		d.stdout.WriteString(text)
+	}
+}
@@ -0,0 +1,133 @@
+"""Network gateway of an isolated sandbox.
+
+An isolated sandbox is attached only to an internal Docker network. The
+gateway sits on that network and on the default bridge, and:
+
+- forwards its published gRPC port to the sandbox's server (UPSTREAM), so
+  the manager can reach the sandbox;
+- runs an HTTP proxy on PROXY_PORT for the sandbox, which allows CONNECT
+  tunnels and plain HTTP requests to ALLOWED_HOSTS only. An empty allow-list
+  blocks everything.
+"""
+
+import asyncio
+import logging
+import os
+from urllib.parse import urlsplit
+
+logging.basicConfig(level=logging.INFO, format="%(asctime)s gateway %(levelname)s %(message)s")
+logger = logging.getLogger(__name__)
+
+SERVER_PORT = 8000
+PROXY_PORT = int(os.environ.get("PROXY_PORT", "3128"))
+UPSTREAM_HOST, _, UPSTREAM_PORT = os.environ.get("UPSTREAM", "sandbox:8000").rpartition(":")
+ALLOWED_HOSTS = [h.strip().lower() for h in os.environ.get("ALLOWED_HOSTS", "").split(",") if h.strip()]
+
+
+def host_allowed(host: str) -> bool:
+    """Matches exact host names and "*.example.com" subdomain patterns."""
+    host = host.lower().rstrip(".")
+    for pattern in ALLOWED_HOSTS:
+        if pattern.startswith("*."):
+            if host.endswith(pattern[1:]):
+                return True
+        elif host == pattern:
+            return True
+    return False
+
+
+async def pipe(reader: asyncio.StreamReader, writer: asyncio.StreamWriter):
+    try:
+        while data := await reader.read(65536):
+            writer.write(data)
+            await writer.drain()
+    except (ConnectionError, OSError):
+        pass
+    finally:
+        writer.close()
+
+
+async def splice(r1, w1, r2, w2):
+    await asyncio.gather(pipe(r1, w2), pipe(r2, w1))
+
+
+async def forward_server(reader, writer):
+    """Forwards a connection to the sandbox's gRPC server."""
+    try:
+        up_reader, up_writer = await asyncio.open_connection(UPSTREAM_HOST, int(UPSTREAM_PORT))
+    except OSError:
+        writer.close()
+        return
+    await splice(reader, writer, up_reader, up_writer)
+
+
+async def respond(writer, status: str, body: str = ""):
+    writer.write(
+        f"HTTP/1.1 {status}\r\nContent-Type: text/plain\r\nContent-Length: {len(body)}\r\n"
+        f"Connection: close\r\n\r\n{body}".encode()
+    )
+    await writer.drain()
+    writer.close()
+
+
+async def proxy(reader, writer):
+    """Handles one proxy request: a CONNECT tunnel or a plain HTTP request."""
+    try:
+        head = await reader.readuntil(b"\r\n\r\n")
+    except (asyncio.IncompleteReadError, asyncio.LimitOverrunError, ConnectionError):
+        writer.close()
+        return
+
+    request_line, _, headers = head.decode("latin-1").partition("\r\n")
+    try:
+        method, target, version = request_line.split(" ", 2)
+    except ValueError:
+        await respond(writer, "400 Bad Request")
+        return
+
+    if method == "CONNECT":
+        host, _, port = target.rpartition(":")
+        host = host.strip("[]")
+        port = int(port) if port.isdigit() else 443
+    else:
+        url = urlsplit(target)
+        if url.scheme != "http" or not url.hostname:
+            await respond(writer, "400 Bad Request", "Only absolute http:// URLs and CONNECT are supported.\n")
+            return
+        host, port = url.hostname, url.port or 80
+
+    if not host_allowed(host):
+        logger.info(f"Blocked {method} {host}:{port}")
+        await respond(writer, "403 Forbidden", f"{host} is not allowed by the sandbox network policy.\n")
+        return
+
+    try:
+        up_reader, up_writer = await asyncio.open_connection(host, port)
+    except OSError as e:
+        await respond(writer, "502 Bad Gateway", f"{e}\n")
+        return
+
+    if method == "CONNECT":
+        writer.write(b"HTTP/1.1 200 Connection Established\r\n\r\n")
+        await writer.drain()
+    else:
+        # Close the connection after this request: a client could otherwise
+        # send its next request, to any host, over the same connection.
+        kept = [
+            line for line in headers.split("\r\n")
+            if line and line.split(":", 1)[0].strip().lower() not in ("connection", "proxy-connection", "keep-alive")
+        ]
+        up_writer.write(("\r\n".join([request_line, *kept, "Connection: close", "", ""])).encode("latin-1"))
+        await up_writer.drain()
+    await splice(reader, writer, up_reader, up_writer)
+
+
+async def main():
+    logger.info(f"Forwarding :{SERVER_PORT} to {UPSTREAM_HOST}:{UPSTREAM_PORT}; allowed hosts: {ALLOWED_HOSTS}")
+    server = await asyncio.start_server(forward_server, "0.0.0.0", SERVER_PORT)
+    proxy_server = await asyncio.start_server(proxy, "0.0.0.0", PROXY_PORT)
+    await asyncio.gather(server.serve_forever(), proxy_server.serve_forever())
+
+
+if __name__ == "__main__":
+    asyncio.run(main())
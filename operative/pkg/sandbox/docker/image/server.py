@@ -1,6 +1,9 @@
 import asyncio
+import base64
 import io
+import os
 import queue
+import signal
 import sys
 import threading
 import uuid
@@ -11,6 +14,7 @@ from contextlib import redirect_stdout, redirect_stderr
 from typing import Optional
 import traceback
 
+import dill
 import grpc
 from IPython.core.interactiveshell import InteractiveShell
 
@@ -21,12 +25,35 @@ import sandbox_pb2_grpc
 logging.basicConfig(level=logging.INFO, format='%(asctime)s - %(name)s - %(levelname)s - %(message)s')
 logger = logging.getLogger("sandbox")
 
+# Rich MIME types captured from display outputs, richest first. Only the first
+# one present in a MIME bundle is kept.
+RICH_MIME_TYPES = ["image/png", "image/jpeg", "image/gif", "image/webp", "image/svg+xml", "text/html"]
+BINARY_MIME_TYPES = {"image/png", "image/jpeg", "image/gif", "image/webp"}
+
 class SandboxServicer(sandbox_pb2_grpc.SandboxServicer):
     def __init__(self):
         self.ipy = InteractiveShell.instance()
+        # Plain, uncolored tracebacks, captured instead of printed so they are
+        # reported as structured error fields (see _capture_traceback).
+        self.ipy.run_line_magic("colors", "nocolor")
+        self.ipy.run_line_magic("xmode", "Plain")
+        self.ipy._showtraceback = self._capture_traceback
+        self.last_traceback = ""
+        # Capture rich display outputs (display() calls, matplotlib figures and
+        # rich execute results like DataFrames) in addition to the text/plain
+        # fallback that IPython prints to stdout.
+        self.displays = []
+        self._wrap_display_outputs()
         self.response_queue = queue.Queue()
         self.pending_prompts = {} # id -> threading.Event + result placeholder
         self.lock = threading.Lock()
+
+        # Cells run one at a time on the main thread (see run_cells) so that
+        # SIGINT can raise KeyboardInterrupt inside them. Namespace requests
+        # are queued alongside them so they never overlap a cell.
+        self.cell_queue = queue.Queue() # (handler, request, response queue)
+        self.cell_running = False
+        self.interrupt_requested = False
         
         # Inject custom functions into IPython namespace
         self.ipy.user_ns["prompt_model"] = self.prompt_model
@@ -44,12 +71,15 @@ class SandboxServicer(sandbox_pb2_grpc.SandboxServicer):
             prompt_model=sandbox_pb2.PromptModelRequest(prompt=prompt, id=req_id)
         ))
         
-        # Wait for response
-        event.wait()
-        
-        with self.lock:
-            data = self.pending_prompts.pop(req_id)
-            return data["response"]
+        # Wait for response. Remove the pending prompt even if the cell is
+        # interrupted meanwhile, so a late response finds no slot and is
+        # dropped.
+        try:
+            event.wait()
+        finally:
+            with self.lock:
+                data = self.pending_prompts.pop(req_id)
+        return data["response"]
 
     def prompt_self(self, message: str):
         logger.info("Prompting self")
@@ -58,13 +88,12 @@ class SandboxServicer(sandbox_pb2_grpc.SandboxServicer):
         ))
 
     def RunStream(self, request_iterator, context):
-        # Create a new queue for this stream
+        # Create a new queue for this stream. It becomes the target of
+        # prompt_model/prompt_self once the stream runs a cell.
         q = queue.Queue()
-        with self.lock:
-            self.response_queue = q
 
         # Start a thread to consume requests
-        consumer_thread = threading.Thread(target=self._consume_requests, args=(request_iterator, context))
+        consumer_thread = threading.Thread(target=self._consume_requests, args=(request_iterator, context, q))
         consumer_thread.daemon = True
         consumer_thread.start()
         
@@ -85,18 +114,21 @@ class SandboxServicer(sandbox_pb2_grpc.SandboxServicer):
 
 
 
-    def _consume_requests(self, request_iterator, context):
+    def _consume_requests(self, request_iterator, context, q):
         try:
             for req in request_iterator:
                 if req.HasField("run_cell"):
-                    # Run in a separate thread to allow prompt_responses to be processed
-                    t = threading.Thread(target=self._handle_run_cell, args=(req.run_cell,))
-                    t.start()
+                    # Hand off to the main thread; this thread keeps consuming
+                    # so prompt responses and cancels are processed meanwhile.
+                    self.cell_queue.put((self._handle_run_cell, req.run_cell, q))
+                elif req.HasField("save_namespace"):
+                    self.cell_queue.put((self._handle_save_namespace, req.save_namespace, q))
+                elif req.HasField("load_namespace"):
+                    self.cell_queue.put((self._handle_load_namespace, req.load_namespace, q))
                 elif req.HasField("prompt_model_response"):
                     self._handle_prompt_response(req.prompt_model_response)
                 elif req.HasField("cancel"):
-                     # Handle cancellation if needed (e.g. interrupt kernel)
-                     pass
+                    self.interrupt()
         except Exception as e:
             logger.error(f"Error consuming requests: {e}")
             traceback.print_exc()
@@ -104,10 +136,69 @@ class SandboxServicer(sandbox_pb2_grpc.SandboxServicer):
     def _handle_prompt_response(self, resp: sandbox_pb2.PromptModelResponse):
         logger.info(f"Received prompt response: {resp.id}")
         with self.lock:
+            # Prompts of interrupted cells are no longer pending.
             if resp.id in self.pending_prompts:
                 self.pending_prompts[resp.id]["response"] = resp.response
                 self.pending_prompts[resp.id]["event"].set()
 
+    def _capture_traceback(self, etype, evalue, stb):
+        # IPython's documented hook for sending tracebacks to a side channel.
+        self.last_traceback = self.ipy.InteractiveTB.stb2text(stb)
+
+    def _wrap_display_outputs(self):
+        pub = self.ipy.display_pub
+        publish = pub.publish
+        def publish_and_capture(data, metadata=None, *args, **kwargs):
+            self._capture_display(data)
+            return publish(data, metadata, *args, **kwargs)
+        pub.publish = publish_and_capture
+
+        hook = self.ipy.displayhook
+        write_format_data = hook.write_format_data
+        def write_and_capture(format_dict, md_dict=None):
+            self._capture_display(format_dict)
+            return write_format_data(format_dict, md_dict)
+        hook.write_format_data = write_and_capture
+
+    def _capture_display(self, bundle):
+        for mime_type in RICH_MIME_TYPES:
+            value = bundle.get(mime_type)
+            if value is None:
+                continue
+            if mime_type in BINARY_MIME_TYPES:
+                # IPython formatters may return either raw or base64 bytes.
+                data = base64.b64decode(value) if isinstance(value, str) else bytes(value)
+            else:
+                data = value.encode() if isinstance(value, str) else bytes(value)
+            self.displays.append(sandbox_pb2.DisplayData(mime_type=mime_type, data=data))
+            return
+
+    def interrupt(self):
+        """Raise KeyboardInterrupt in the running cell, if any."""
+        with self.lock:
+            if not self.cell_running:
+                return
+            self.interrupt_requested = True
+        logger.info("Interrupting cell")
+        signal.pthread_kill(threading.main_thread().ident, signal.SIGINT)
+
+    def _on_sigint(self, signum, frame):
+        # Only interrupt user code; a SIGINT that races with the end of a
+        # cell must not take down the main loop.
+        if self.cell_running:
+            raise KeyboardInterrupt
+
+    def run_cells(self):
+        """Execute queued cells on the calling (main) thread. Never returns."""
+        signal.signal(signal.SIGINT, self._on_sigint)
+        while True:
+            handler, req, q = self.cell_queue.get()
+            try:
+                handler(req, q)
+            except KeyboardInterrupt:
+                # Arrived just outside run_cell; the result is already sent.
+                pass
+
     class OutputStream(io.TextIOBase):
         def __init__(self, queue, is_stderr=False):
             self.queue = queue
@@ -123,11 +214,13 @@ class SandboxServicer(sandbox_pb2_grpc.SandboxServicer):
         def flush(self):
             pass
 
-    def _handle_run_cell(self, req: sandbox_pb2.RunCellRequest):
+    def _handle_run_cell(self, req: sandbox_pb2.RunCellRequest, q: queue.Queue):
         logger.info("Running cell")
+        with self.lock:
+            self.response_queue = q
         # Setup redirection
-        stdout_stream = self.OutputStream(self.response_queue, is_stderr=False)
-        stderr_stream = self.OutputStream(self.response_queue, is_stderr=True)
+        stdout_stream = self.OutputStream(q, is_stderr=False)
+        stderr_stream = self.OutputStream(q, is_stderr=True)
         
         # We need to capture the output buffers properly for the final result as well, 
         # or just rely on streaming.
@@ -157,30 +250,91 @@ class SandboxServicer(sandbox_pb2_grpc.SandboxServicer):
         tee_stdout = TeeStream(stdout_stream, captured_stdout)
         tee_stderr = TeeStream(stderr_stream, captured_stderr)
 
+        self.last_traceback = ""
+        self.displays = []
+        success, error = False, None
+        with self.lock:
+            self.interrupt_requested = False
+            self.cell_running = True
         with redirect_stdout(tee_stdout), redirect_stderr(tee_stderr):
             try:
-                self.ipy.run_cell(req.code)
-            except Exception as e:
+                result = self.ipy.run_cell(req.code)
+                success = result.success
+                error = result.error_before_exec or result.error_in_exec
+            except (Exception, KeyboardInterrupt) as e:
                 # Should be caught by ipython usually, but just in case
-                tee_stderr.write(str(e))
+                error = e
+                self.last_traceback = traceback.format_exc()
+            finally:
+                with self.lock:
+                    self.cell_running = False
+                    interrupted = self.interrupt_requested
 
         # Send result
-        self.response_queue.put(sandbox_pb2.ServerMessage(
+        q.put(sandbox_pb2.ServerMessage(
             run_cell_result=sandbox_pb2.RunCellResult(
                 output=captured_stdout.getvalue() + captured_stderr.getvalue(),
                 stdout=captured_stdout.getvalue(),
                 stderr=captured_stderr.getvalue(),
-                success=True # TODO check execution success properly
+                success=success,
+                interrupted=interrupted,
+                error_name=type(error).__name__ if error is not None else "",
+                error_value=str(error) if error is not None else "",
+                traceback=self.last_traceback,
+                displays=self.displays,
             )
         ))
 
+    def _user_variables(self):
+        """User-defined variables, excluding IPython's own and injected ones."""
+        hidden = self.ipy.user_ns_hidden
+        return {
+            name: value for name, value in self.ipy.user_ns.items()
+            if not name.startswith("_") and name not in hidden
+            and name not in ("prompt_model", "prompt_self")
+        }
+
+    def _handle_save_namespace(self, req: sandbox_pb2.SaveNamespaceRequest, q: queue.Queue):
+        logger.info(f"Saving namespace to {req.path}")
+        saved, skipped = {}, []
+        for name, value in self._user_variables().items():
+            try:
+                dill.dumps(value)
+            except Exception:
+                skipped.append(name)
+                continue
+            saved[name] = value
+        try:
+            os.makedirs(os.path.dirname(req.path), exist_ok=True)
+            with open(req.path, "wb") as f:
+                dill.dump(saved, f)
+        except Exception as e:
+            q.put(sandbox_pb2.ServerMessage(namespace_result=sandbox_pb2.NamespaceResult(
+                success=False, error=f"{type(e).__name__}: {e}", skipped=skipped)))
+            return
+        q.put(sandbox_pb2.ServerMessage(namespace_result=sandbox_pb2.NamespaceResult(
+            success=True, skipped=skipped)))
+
+    def _handle_load_namespace(self, req: sandbox_pb2.LoadNamespaceRequest, q: queue.Queue):
+        logger.info(f"Loading namespace from {req.path}")
+        try:
+            with open(req.path, "rb") as f:
+                self.ipy.user_ns.update(dill.load(f))
+        except Exception as e:
+            q.put(sandbox_pb2.ServerMessage(namespace_result=sandbox_pb2.NamespaceResult(
+                success=False, error=f"{type(e).__name__}: {e}")))
+            return
+        q.put(sandbox_pb2.ServerMessage(namespace_result=sandbox_pb2.NamespaceResult(success=True)))
+
 def serve():
+    servicer = SandboxServicer()
     server = grpc.server(futures.ThreadPoolExecutor(max_workers=10))
-    sandbox_pb2_grpc.add_SandboxServicer_to_server(SandboxServicer(), server)
+    sandbox_pb2_grpc.add_SandboxServicer_to_server(servicer, server)
     server.add_insecure_port('[::]:8000')
     logger.info("Starting sandbox server on :8000")
     server.start()
-    server.wait_for_termination()
+    # gRPC serves from its own threads; the main thread executes cells.
+    servicer.run_cells()
 
 if __name__ == '__main__':
     serve()
@@ -0,0 +1,156 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/nstogner/operative/pkg/domain"
+)
+
+const (
+	// LabelRole distinguishes an operative's gateway container from its
+	// sandbox container.
+	LabelRole = "sandbox-role"
+	// RoleGateway is the LabelRole value of gateway containers.
+	RoleGateway = "gateway"
+	// ProxyPort is the port of the gateway's HTTP(S) proxy.
+	ProxyPort = "3128"
+
+	// Host names of the two containers on the operative's network.
+	sandboxAlias = "sandbox"
+	gatewayAlias = "gateway"
+)
+
+// isolated reports whether the sandbox is kept off the default bridge
+// network. Such a sandbox is attached to an internal network of its own and
+// reached through a gateway container, which is on both networks: it
+// forwards the gRPC port from the host and, for the allowlist mode, proxies
+// HTTP(S) requests to allowed hosts.
+func isolated(cfg domain.SandboxConfig) bool {
+	return cfg.Network == domain.NetworkNone || cfg.Network == domain.NetworkAllowlist
+}
+
+// proxyEnv returns the proxy variables of the sandbox. They are always set,
+// empty unless the allowlist is in effect, so that variables inherited from
+// a checkpoint image taken under a different policy are overridden.
+func proxyEnv(cfg domain.SandboxConfig) []string {
+	proxy := ""
+	if cfg.Network == domain.NetworkAllowlist {
+		proxy = "http://" + gatewayAlias + ":" + ProxyPort
+	}
+	var env []string
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		env = append(env, name+"="+proxy)
+	}
+	return append(env, "NO_PROXY=localhost,127.0.0.1", "no_proxy=localhost,127.0.0.1")
+}
+
+// ensureNetwork creates the operative's internal network if it does not
+// exist yet and returns its name.
+func (m *Manager) ensureNetwork(ctx context.Context, operativeID string) (string, error) {
+	name := m.networkName(operativeID)
+	if _, err := m.client.NetworkInspect(ctx, name, types.NetworkInspectOptions{}); err == nil {
+		return name, nil
+	} else if !client.IsErrNotFound(err) {
+		return "", fmt.Errorf("inspecting sandbox network: %w", err)
+	}
+	if _, err := m.client.NetworkCreate(ctx, name, types.NetworkCreate{
+		CheckDuplicate: true,
+		Internal:       true,
+		Labels: map[string]string{
+			LabelManager:     LabelManagerValue,
+			LabelOperativeID: operativeID,
+		},
+	}); err != nil {
+		return "", fmt.Errorf("creating sandbox network: %w", err)
+	}
+	return name, nil
+}
+
+// removeNetwork deletes the operative's internal network, if any. Its
+// containers must have been removed first.
+func (m *Manager) removeNetwork(ctx context.Context, operativeID string) {
+	if err := m.client.NetworkRemove(ctx, m.networkName(operativeID)); err != nil && !client.IsErrNotFound(err) {
+		slog.Warn("Failed to remove sandbox network", "operativeID", operativeID, "error", err)
+	}
+}
+
+// startGateway creates and starts the gateway of an isolated sandbox and
+// returns its host port, which forwards to the sandbox's gRPC server.
+func (m *Manager) startGateway(ctx context.Context, operativeID, networkName string, cfg domain.SandboxConfig) (string, error) {
+	var allowed string
+	if cfg.Network == domain.NetworkAllowlist {
+		allowed = strings.Join(cfg.AllowedHosts, ",")
+	}
+	pids := int64(64)
+	resp, err := m.client.ContainerCreate(ctx,
+		&container.Config{
+			Image: m.image,
+			Cmd:   []string{"python", "/app/gateway.py"},
+			Env: []string{
+				"UPSTREAM=" + sandboxAlias + ":" + ServerPort,
+				"PROXY_PORT=" + ProxyPort,
+				"ALLOWED_HOSTS=" + allowed,
+			},
+			Labels: map[string]string{
+				LabelManager:     LabelManagerValue,
+				LabelOperativeID: operativeID,
+				LabelRole:        RoleGateway,
+			},
+			ExposedPorts: nat.PortSet{
+				nat.Port(ServerPort + "/tcp"): {},
+			},
+		},
+		&container.HostConfig{
+			PortBindings: serverPortBindings(),
+			Resources: container.Resources{
+				Memory:    128 << 20,
+				PidsLimit: &pids,
+			},
+		},
+		nil, nil, m.gatewayName(operativeID))
+	if err != nil {
+		return "", fmt.Errorf("creating gateway container: %w", err)
+	}
+	if err := m.client.NetworkConnect(ctx, networkName, resp.ID, &network.EndpointSettings{
+		Aliases: []string{gatewayAlias},
+	}); err != nil {
+		return "", fmt.Errorf("connecting gateway to sandbox network: %w", err)
+	}
+	if err := m.client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return "", fmt.Errorf("starting gateway container: %w", err)
+	}
+	c, err := m.client.ContainerInspect(ctx, resp.ID)
+	if err != nil {
+		return "", err
+	}
+	return m.getPort(c)
+}
+
+// serverPortBindings publishes the gRPC port on a dynamically assigned
+// loopback port.
+func serverPortBindings() nat.PortMap {
+	return nat.PortMap{
+		nat.Port(ServerPort + "/tcp"): []nat.PortBinding{
+			{
+				HostIP:   "127.0.0.1",
+				HostPort: "0", // Dynamically assigned port.
+			},
+		},
+	}
+}
+
+func (m *Manager) networkName(operativeID string) string {
+	return "operative-net-" + operativeID
+}
+
+func (m *Manager) gatewayName(operativeID string) string {
+	return "operative-gateway-" + operativeID
+}
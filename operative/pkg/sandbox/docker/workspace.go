@@ -0,0 +1,223 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// WorkspacePath is where the operative's workspace volume is mounted in the
+// sandbox. It is also the working directory of the IPython kernel. Files
+// written there survive sandbox restarts; the rest of the container
+// filesystem does not. Checkpoints include a copy of it (see
+// snapshotWorkspace).
+const WorkspacePath = "/workspace"
+
+// ensureWorkspace returns the mount for the operative's workspace volume,
+// creating the volume if it does not exist yet.
+func (m *Manager) ensureWorkspace(ctx context.Context, operativeID string) (mount.Mount, error) {
+	name, err := m.currentWorkspace(ctx, operativeID)
+	if err != nil {
+		return mount.Mount{}, err
+	}
+	if name != "" {
+		return workspaceMount(name), nil
+	}
+	return m.createWorkspace(ctx, operativeID, m.workspaceName(operativeID))
+}
+
+// currentWorkspace returns the name of the operative's workspace volume, or
+// "" if it has none. Restore creates a new volume before removing the old
+// one, so there can briefly be two; the old one (first by name, see
+// restoredWorkspaceName) stays current until it is removed.
+func (m *Manager) currentWorkspace(ctx context.Context, operativeID string) (string, error) {
+	resp, err := m.client.VolumeList(ctx, volume.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", LabelManager+"="+LabelManagerValue),
+			filters.Arg("label", LabelOperativeID+"="+operativeID),
+		),
+	})
+	if err != nil {
+		return "", fmt.Errorf("listing workspace volumes: %w", err)
+	}
+	var name string
+	for _, v := range resp.Volumes {
+		if name == "" || v.Name < name {
+			name = v.Name
+		}
+	}
+	return name, nil
+}
+
+// createWorkspace creates a workspace volume for the operative and returns
+// the mount for it.
+func (m *Manager) createWorkspace(ctx context.Context, operativeID, name string) (mount.Mount, error) {
+	if _, err := m.client.VolumeCreate(ctx, volume.CreateOptions{
+		Name: name,
+		Labels: map[string]string{
+			LabelManager:     LabelManagerValue,
+			LabelOperativeID: operativeID,
+		},
+	}); err != nil {
+		return mount.Mount{}, fmt.Errorf("creating workspace volume: %w", err)
+	}
+	return workspaceMount(name), nil
+}
+
+func workspaceMount(name string) mount.Mount {
+	return mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: name,
+		Target: WorkspacePath,
+	}
+}
+
+// removeOrphanWorkspaces deletes the workspace volumes of operatives that no
+// longer exist. Their containers must have been removed first.
+func (m *Manager) removeOrphanWorkspaces(ctx context.Context, known map[string]bool) {
+	resp, err := m.client.VolumeList(ctx, volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", LabelManager+"="+LabelManagerValue)),
+	})
+	if err != nil {
+		slog.Warn("Failed to list workspace volumes", "error", err)
+		return
+	}
+	for _, v := range resp.Volumes {
+		opID := v.Labels[LabelOperativeID]
+		if known[opID] {
+			continue
+		}
+		slog.Info("Removing orphaned workspace", "operativeID", opID, "volume", v.Name)
+		if err := m.client.VolumeRemove(ctx, v.Name, true); err != nil {
+			slog.Warn("Failed to remove workspace volume", "volume", v.Name, "error", err)
+		}
+	}
+}
+
+// workspaceSnapshotRoot holds the workspace copies of checkpoints in the
+// container filesystem, since docker commit does not include volumes. A
+// checkpoint's copy is removed from the container once it has been
+// committed or restored, so that later checkpoints do not carry it along.
+const workspaceSnapshotRoot = "/var/lib/operative/checkpoints"
+
+// workspaceSnapshotDir is where a checkpoint keeps its copy of the
+// workspace. The copy itself is the "workspace" directory in it.
+func workspaceSnapshotDir(checkpointID string) string {
+	return workspaceSnapshotRoot + "/" + checkpointID
+}
+
+// snapshotWorkspace copies the operative's workspace into the filesystem of
+// its container, at workspaceSnapshotDir(checkpointID), so that committing
+// the container includes it.
+func (m *Manager) snapshotWorkspace(ctx context.Context, operativeID, checkpointID string) error {
+	name := m.containerName(operativeID)
+	rc, _, err := m.client.CopyFromContainer(ctx, name, WorkspacePath)
+	if err != nil {
+		return fmt.Errorf("reading workspace: %w", err)
+	}
+	defer rc.Close()
+	// Entries are named "workspace/..."; move them under the snapshot dir.
+	prefix := strings.TrimPrefix(workspaceSnapshotDir(checkpointID), "/") + "/"
+	if err := m.client.CopyToContainer(ctx, name, "/", prefixTar(rc, prefix), types.CopyToContainerOptions{CopyUIDGID: true}); err != nil {
+		return fmt.Errorf("copying workspace: %w", err)
+	}
+	return nil
+}
+
+// restoreWorkspace copies the workspace snapshot of a checkpoint, in the
+// filesystem of the operative's container created from it, into its
+// workspace volume. The volume should be empty.
+func (m *Manager) restoreWorkspace(ctx context.Context, operativeID, checkpointID string) error {
+	name := m.containerName(operativeID)
+	rc, _, err := m.client.CopyFromContainer(ctx, name, path.Join(workspaceSnapshotDir(checkpointID), path.Base(WorkspacePath)))
+	if err != nil {
+		return fmt.Errorf("reading workspace snapshot: %w", err)
+	}
+	defer rc.Close()
+	if err := m.client.CopyToContainer(ctx, name, path.Dir(WorkspacePath), rc, types.CopyToContainerOptions{CopyUIDGID: true}); err != nil {
+		return fmt.Errorf("copying workspace snapshot: %w", err)
+	}
+	return nil
+}
+
+// removeWorkspaceSnapshots deletes the workspace copies of checkpoints
+// from the filesystem of the operative's container.
+func (m *Manager) removeWorkspaceSnapshots(ctx context.Context, operativeID string) error {
+	exec, err := m.client.ContainerExecCreate(ctx, m.containerName(operativeID), types.ExecConfig{
+		Cmd:          []string{"rm", "-rf", workspaceSnapshotRoot},
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("removing workspace snapshots: %w", err)
+	}
+	resp, err := m.client.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return fmt.Errorf("removing workspace snapshots: %w", err)
+	}
+	// The output ends when the command exits.
+	_, _ = io.Copy(io.Discard, resp.Reader)
+	resp.Close()
+	res, err := m.client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return fmt.Errorf("removing workspace snapshots: %w", err)
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("removing workspace snapshots: rm exited with status %d", res.ExitCode)
+	}
+	return nil
+}
+
+// prefixTar returns the tar archive r with prefix prepended to the names of
+// its entries (and the targets of its hard links).
+func prefixTar(r io.Reader, prefix string) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(pw)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			hdr.Name = prefix + hdr.Name
+			if hdr.Typeflag == tar.TypeLink {
+				hdr.Linkname = prefix + hdr.Linkname
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	return pr
+}
+
+func (m *Manager) workspaceName(operativeID string) string {
+	return "operative-workspace-" + operativeID
+}
+
+// restoredWorkspaceName returns a name for a new workspace volume of the
+// operative that sorts after the names of its existing ones.
+func (m *Manager) restoredWorkspaceName(operativeID string) string {
+	return fmt.Sprintf("%s-%016x", m.workspaceName(operativeID), time.Now().UnixNano())
+}
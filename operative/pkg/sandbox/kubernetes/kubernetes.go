@@ -0,0 +1,790 @@
+// Package kubernetes implements sandbox.Manager with one Pod per operative.
+//
+// Pods carry the same labels as the Docker backend's containers, so both
+// backends can be inspected the same way (e.g. with a
+// "manager=operativesystem" selector). The sandbox's gRPC server is reached
+// at the pod IP when the manager runs in the cluster, or through a
+// port-forward when it is given a REST config.
+package kubernetes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/sandbox"
+	"github.com/nstogner/operative/pkg/sandbox/rpc"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// LabelManager is the label used to identify pods managed by this system.
+	LabelManager = "manager"
+	// LabelManagerValue is the value of the manager label.
+	LabelManagerValue = "operativesystem"
+	// LabelOperativeID is the label used to identify which operative a pod
+	// belongs to.
+	LabelOperativeID = "operative-id"
+	// LabelSandboxConfig holds a hash of the sandbox settings a pod was
+	// created with. Pods whose settings changed are recreated.
+	LabelSandboxConfig = "sandbox-config"
+	// AnnotationImage records the sandbox image a pod is based on. Pods whose
+	// image changed are recreated. Image references are not valid label
+	// values, hence an annotation.
+	AnnotationImage = "sandbox-image"
+	// SandboxImage is the default sandbox container image.
+	SandboxImage = "sandbox-python:latest"
+	// ServerPort is the gRPC port exposed by the sandbox container.
+	ServerPort = rpc.ServerPort
+	// WorkspacePath is where the operative's workspace volume is mounted in
+	// the sandbox, as in the Docker backend.
+	WorkspacePath = "/workspace"
+	// DefaultWorkspaceSize is the storage requested for workspace volumes.
+	DefaultWorkspaceSize = "1Gi"
+	// ReconcileInterval is how often the Run loop checks for drift it was
+	// not notified of.
+	ReconcileInterval = 10 * time.Second
+	// DefaultStartTimeout is the default StartTimeout. It allows for
+	// scheduling and image pulls.
+	DefaultStartTimeout = 5 * time.Minute
+
+	// containerName is the name of the sandbox container within its pod.
+	containerName = "sandbox"
+)
+
+// errCheckpointsUnsupported is returned by the checkpoint operations, which
+// rely on committing container filesystems and have no Pod equivalent.
+var errCheckpointsUnsupported = errors.New("checkpoints are not supported by the kubernetes sandbox backend")
+
+// Config configures a Kubernetes sandbox manager.
+type Config struct {
+	// Namespace the sandbox pods are created in. Defaults to "default".
+	Namespace string
+	// Image is the default sandbox image. Defaults to SandboxImage.
+	Image string
+	// StorageClass of the workspace volumes. Empty uses the cluster default.
+	StorageClass string
+	// WorkspaceSize is the storage requested for each workspace volume.
+	// Defaults to DefaultWorkspaceSize.
+	WorkspaceSize string
+	// RESTConfig, if set, makes the manager reach sandboxes through
+	// port-forwards instead of their pod IPs, for managers running outside
+	// the cluster.
+	RESTConfig *rest.Config
+}
+
+// Manager implements sandbox.Manager using Kubernetes Pods with gRPC.
+type Manager struct {
+	// StartTimeout is how long RunCell waits for a sandbox pod that is not
+	// ready yet, e.g. because its operative was created moments ago. Zero
+	// makes RunCell fail right away.
+	StartTimeout time.Duration
+
+	client        kubernetes.Interface
+	namespace     string
+	image         string
+	storageClass  string
+	workspaceSize resource.Quantity
+	forwarder     *forwarder // nil when pods are reached by IP
+
+	cells *rpc.Cells
+
+	mu        sync.Mutex
+	instances map[string]types.UID // pod UID keyed by operative ID
+
+	wake   chan struct{} // requests a reconcile from the Run loop
+	events chan sandbox.Event
+}
+
+// spec is the desired sandbox of an operative.
+type spec struct {
+	image  string
+	config domain.SandboxConfig // with defaults applied
+}
+
+// Verify interface compliance.
+var _ sandbox.Manager = (*Manager)(nil)
+
+// New creates a new Kubernetes sandbox manager.
+func New(client kubernetes.Interface, cfg Config) (*Manager, error) {
+	if cfg.Namespace == "" {
+		cfg.Namespace = "default"
+	}
+	if cfg.Image == "" {
+		cfg.Image = SandboxImage
+	}
+	if cfg.WorkspaceSize == "" {
+		cfg.WorkspaceSize = DefaultWorkspaceSize
+	}
+	size, err := resource.ParseQuantity(cfg.WorkspaceSize)
+	if err != nil {
+		return nil, fmt.Errorf("parsing workspace size: %w", err)
+	}
+	m := &Manager{
+		StartTimeout:  DefaultStartTimeout,
+		client:        client,
+		namespace:     cfg.Namespace,
+		image:         cfg.Image,
+		storageClass:  cfg.StorageClass,
+		workspaceSize: size,
+		cells:         rpc.NewCells(),
+		instances:     make(map[string]types.UID),
+		wake:          make(chan struct{}, 1),
+		events:        make(chan sandbox.Event, 64),
+	}
+	if cfg.RESTConfig != nil {
+		m.forwarder = newForwarder(client, cfg.RESTConfig)
+	}
+	return m, nil
+}
+
+// Run starts a long-running reconciliation loop. It ensures each known
+// operative has a sandbox pod and deletes orphan pods (not matching any known
+// operative). It reconciles when a managed pod terminates or is deleted
+// (watched), when operatives implements sandbox.OperativeNotifier and an
+// operative changes, when RunCell finds no pod, and every ReconcileInterval
+// to catch anything missed. Blocks until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context, operatives sandbox.OperativeLister) error {
+	slog.Info("Sandbox manager reconciliation loop starting", "namespace", m.namespace)
+
+	var changes <-chan string
+	if n, ok := operatives.(sandbox.OperativeNotifier); ok {
+		ch, unsubscribe := n.SubscribeOperatives()
+		defer unsubscribe()
+		changes = ch
+	}
+	go m.watchPods(ctx)
+
+	// Reconcile immediately on start.
+	if err := m.reconcile(ctx, operatives); err != nil {
+		slog.Error("Initial reconciliation failed", "error", err)
+	}
+
+	ticker := time.NewTicker(ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Sandbox manager reconciliation loop stopping")
+			return ctx.Err()
+		case <-ticker.C:
+		case id := <-changes:
+			slog.Debug("Operative changed, reconciling", "operativeID", id)
+		case <-m.wake:
+		}
+		if err := m.reconcile(ctx, operatives); err != nil {
+			slog.Error("Reconciliation failed", "error", err)
+		}
+	}
+}
+
+// watchPods requests a reconcile whenever a managed pod terminates or is
+// deleted, until ctx is cancelled. The watch is renewed when it ends, as
+// the API server closes watches periodically.
+func (m *Manager) watchPods(ctx context.Context) {
+	for {
+		w, err := m.client.CoreV1().Pods(m.namespace).Watch(ctx, metav1.ListOptions{
+			LabelSelector: LabelManager + "=" + LabelManagerValue,
+		})
+		if err != nil {
+			slog.Warn("Failed to watch sandbox pods", "error", err)
+		} else {
+			for ev := range w.ResultChan() {
+				pod, ok := ev.Object.(*corev1.Pod)
+				if !ok {
+					continue
+				}
+				if ev.Type == watch.Deleted || pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+					slog.Debug("Sandbox pod event", "type", ev.Type, "pod", pod.Name, "phase", pod.Status.Phase)
+					m.trigger()
+				}
+			}
+			w.Stop()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+		// Events may have been missed in the meantime.
+		m.trigger()
+	}
+}
+
+// trigger requests a reconcile from the Run loop without waiting for it.
+// Requests made while one is pending are coalesced.
+func (m *Manager) trigger() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// reconcile compares sandbox pods to known operatives and reconciles.
+func (m *Manager) reconcile(ctx context.Context, operatives sandbox.OperativeLister) error {
+	ops, err := operatives.List(ctx)
+	if err != nil {
+		return fmt.Errorf("listing operatives: %w", err)
+	}
+
+	pods, err := m.listPods(ctx, "")
+	if err != nil {
+		return fmt.Errorf("listing managed pods: %w", err)
+	}
+
+	knownSet := make(map[string]bool, len(ops))
+	specs := make(map[string]spec, len(ops))
+	for _, op := range ops {
+		knownSet[op.ID] = true
+		specs[op.ID] = m.specFor(op)
+	}
+
+	// Delete pods for unknown operatives, and all but the newest pod of each
+	// operative.
+	existing := make(map[string]corev1.Pod)
+	for _, pod := range pods {
+		opID := pod.Labels[LabelOperativeID]
+		if !knownSet[opID] {
+			slog.Info("Deleting orphaned sandbox", "operativeID", opID, "pod", pod.Name)
+			m.deletePod(ctx, pod.Name)
+			m.mu.Lock()
+			delete(m.instances, opID)
+			m.mu.Unlock()
+			continue
+		}
+		if _, ok := existing[opID]; ok {
+			slog.Warn("Deleting duplicate sandbox pod", "operativeID", opID, "pod", pod.Name)
+			m.deletePod(ctx, pod.Name)
+			continue
+		}
+		existing[opID] = pod
+	}
+	m.removeOrphans(ctx, knownSet)
+
+	// Create pods for known operatives that have none, replacing pods that
+	// terminated or whose settings changed.
+	for _, op := range ops {
+		id := op.ID
+		sp := specs[id]
+		pod, ok := existing[id]
+		switch {
+		case ok && pod.Annotations[AnnotationImage] != sp.image:
+			slog.Info("Sandbox image changed, recreating", "operativeID", id, "image", sp.image)
+			m.deletePod(ctx, pod.Name)
+			m.start(ctx, id, sp, "the sandbox image was changed to "+sp.image)
+		case ok && pod.Labels[LabelSandboxConfig] != configHash(sp.config):
+			slog.Info("Sandbox settings changed, recreating", "operativeID", id)
+			m.deletePod(ctx, pod.Name)
+			m.start(ctx, id, sp, "the sandbox settings were changed")
+		case ok && (pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded):
+			reason := exitReason(pod)
+			slog.Warn("Sandbox is not running, recreating", "operativeID", id, "phase", pod.Status.Phase, "reason", reason)
+			m.deletePod(ctx, pod.Name)
+			m.start(ctx, id, sp, reason)
+		case ok:
+			m.observe(id, pod.UID)
+		default:
+			slog.Info("Starting sandbox for operative", "operativeID", id)
+			m.start(ctx, id, sp, "")
+		}
+	}
+
+	return nil
+}
+
+// start creates the operative's sandbox pod. Unlike the Docker backend, it
+// does not wait for the sandbox to become ready: scheduling and image pulls
+// can take a while, and RunCell fails until the pod is ready. If the
+// operative already had a sandbox (reason is set, or one was observed
+// before), the restart is reported on Events.
+func (m *Manager) start(ctx context.Context, operativeID string, sp spec, reason string) {
+	m.mu.Lock()
+	_, hadInstance := m.instances[operativeID]
+	m.mu.Unlock()
+
+	if err := m.createPod(ctx, operativeID, sp); err != nil {
+		slog.Error("Failed to start sandbox", "operativeID", operativeID, "error", err)
+		return
+	}
+	if reason == "" && hadInstance {
+		reason = "the sandbox pod disappeared"
+	}
+	if reason != "" {
+		m.emit(sandbox.Event{OperativeID: operativeID, Reason: reason})
+	}
+}
+
+// observe records the pod of an operative and reports a restart if it
+// differs from the last one seen. Sandbox pods never restart in place
+// (their restart policy is Never), so a new UID means the pod was replaced.
+func (m *Manager) observe(operativeID string, uid types.UID) {
+	m.mu.Lock()
+	prev, ok := m.instances[operativeID]
+	m.instances[operativeID] = uid
+	m.mu.Unlock()
+
+	if ok && prev != uid {
+		m.emit(sandbox.Event{OperativeID: operativeID, Reason: "the sandbox pod was replaced"})
+	}
+}
+
+// exitReason describes why a pod terminated.
+func exitReason(pod corev1.Pod) string {
+	if pod.Status.Reason == "Evicted" {
+		return fmt.Sprintf("the sandbox pod was evicted: %s", pod.Status.Message)
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name != containerName || cs.State.Terminated == nil {
+			continue
+		}
+		t := cs.State.Terminated
+		if t.Reason == "OOMKilled" {
+			return fmt.Sprintf("the sandbox pod ran out of memory and was killed (exit code %d)", t.ExitCode)
+		}
+		return fmt.Sprintf("the sandbox pod exited with code %d", t.ExitCode)
+	}
+	return fmt.Sprintf("the sandbox pod stopped (phase: %s)", pod.Status.Phase)
+}
+
+// emit sends an event without blocking the reconciliation loop.
+func (m *Manager) emit(e sandbox.Event) {
+	slog.Warn("Sandbox restarted", "operativeID", e.OperativeID, "reason", e.Reason)
+	select {
+	case m.events <- e:
+	default:
+		slog.Error("Dropping sandbox event, no receiver", "operativeID", e.OperativeID)
+	}
+}
+
+// Events returns the channel of sandbox restart events.
+func (m *Manager) Events() <-chan sandbox.Event {
+	return m.events
+}
+
+// RunCell executes a code cell in the operative's sandbox via gRPC.
+// The sandbox pod is created by the Run loop; RunCell waits up to
+// StartTimeout for it to be ready.
+func (m *Manager) RunCell(ctx context.Context, operativeID, code string, delegate sandbox.Delegate) (*sandbox.Result, error) {
+	m.waitForSandbox(ctx, operativeID)
+	conn, err := m.dial(ctx, operativeID)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return m.cells.Run(ctx, conn, operativeID, code, delegate)
+}
+
+// Interrupt sends a CancelRequest on the operative's in-flight RunCell stream.
+func (m *Manager) Interrupt(ctx context.Context, operativeID string) error {
+	return m.cells.Interrupt(operativeID)
+}
+
+// ValidateImage accepts any image: images are pulled by the nodes, and
+// whether a pull succeeds cannot be known in advance. A pod whose image
+// cannot be pulled stays pending, which Status reports.
+func (m *Manager) ValidateImage(ctx context.Context, image string) error {
+	return nil
+}
+
+// Checkpoint is not supported by this backend.
+func (m *Manager) Checkpoint(ctx context.Context, operativeID string) (*sandbox.Checkpoint, error) {
+	return nil, errCheckpointsUnsupported
+}
+
+// ListCheckpoints returns no checkpoints, as this backend cannot take any.
+func (m *Manager) ListCheckpoints(ctx context.Context, operativeID string) ([]sandbox.Checkpoint, error) {
+	return []sandbox.Checkpoint{}, nil
+}
+
+// Restore is not supported by this backend.
+func (m *Manager) Restore(ctx context.Context, operativeID, checkpointID string) (*sandbox.Checkpoint, error) {
+	return nil, errCheckpointsUnsupported
+}
+
+// Status returns the status of the operative's sandbox: "running" once its
+// pod is ready, the lowercased pod phase (e.g. "pending") before that, or
+// "stopped" if it has no pod.
+func (m *Manager) Status(ctx context.Context, operativeID string) (string, error) {
+	pods, err := m.listPods(ctx, operativeID)
+	if err != nil {
+		return "unknown", err
+	}
+	if len(pods) == 0 {
+		return "stopped", nil
+	}
+	if podReady(pods[0]) {
+		return "running", nil
+	}
+	return strings.ToLower(string(pods[0].Status.Phase)), nil
+}
+
+// Close stops any port-forwards.
+func (m *Manager) Close() error {
+	if m.forwarder != nil {
+		m.forwarder.closeAll()
+	}
+	return nil
+}
+
+// --- internal helpers ---
+
+// waitForSandbox waits up to StartTimeout for the operative's sandbox pod to
+// be ready. It asks the Run loop to reconcile while the operative has no
+// pod, in case the loop has not heard of it yet. If the pod does not become
+// ready in time, dial reports why.
+func (m *Manager) waitForSandbox(ctx context.Context, operativeID string) {
+	if m.StartTimeout <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.StartTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		pods, err := m.listPods(ctx, operativeID)
+		if err == nil && len(pods) > 0 && podReady(pods[0]) {
+			return
+		}
+		if err == nil && len(pods) == 0 {
+			m.trigger()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dial connects to the gRPC server of the operative's ready sandbox pod.
+func (m *Manager) dial(ctx context.Context, operativeID string) (*grpc.ClientConn, error) {
+	pods, err := m.listPods(ctx, operativeID)
+	if err != nil {
+		return nil, fmt.Errorf("listing sandbox pods: %w", err)
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("sandbox not running for operative %s: no pod", operativeID)
+	}
+	pod := pods[0]
+	if !podReady(pod) {
+		return nil, fmt.Errorf("sandbox not running for operative %s: pod %s is not ready (phase: %s)", operativeID, pod.Name, pod.Status.Phase)
+	}
+
+	if m.forwarder != nil {
+		addr, err := m.forwarder.addr(ctx, m.namespace, pod.Name)
+		if err != nil {
+			return nil, err
+		}
+		return rpc.Dial(addr)
+	}
+	if pod.Status.PodIP == "" {
+		return nil, fmt.Errorf("sandbox pod %s has no IP", pod.Name)
+	}
+	return rpc.Dial(net.JoinHostPort(pod.Status.PodIP, ServerPort))
+}
+
+// createPod creates the operative's workspace volume and network policy if
+// needed, and a new sandbox pod.
+func (m *Manager) createPod(ctx context.Context, operativeID string, sp spec) error {
+	if err := m.ensureWorkspace(ctx, operativeID); err != nil {
+		return err
+	}
+	if err := m.syncNetworkPolicy(ctx, operativeID, sp.config); err != nil {
+		return err
+	}
+	pod, err := m.client.CoreV1().Pods(m.namespace).Create(ctx, m.podFor(operativeID, sp), metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("creating pod: %w", err)
+	}
+	m.mu.Lock()
+	m.instances[operativeID] = pod.UID
+	m.mu.Unlock()
+	slog.Info("Sandbox pod created", "operativeID", operativeID, "pod", pod.Name)
+	return nil
+}
+
+// podFor builds the sandbox pod of an operative.
+func (m *Manager) podFor(operativeID string, sp spec) *corev1.Pod {
+	cfg := sp.config
+	memory := resource.NewQuantity(cfg.MemoryMB<<20, resource.BinarySI)
+	cpu := resource.NewMilliQuantity(int64(cfg.CPUs*1000), resource.DecimalSI)
+	port, _ := strconv.Atoi(ServerPort)
+	noToken := false
+
+	container := corev1.Container{
+		Name:            containerName,
+		Image:           sp.image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		WorkingDir:      WorkspacePath,
+		Ports: []corev1.ContainerPort{{
+			Name:          "grpc",
+			ContainerPort: int32(port),
+		}},
+		// Limits only: requests default to the limits.
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: *memory,
+				corev1.ResourceCPU:    *cpu,
+			},
+		},
+		ReadinessProbe: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(int32(port))},
+			},
+			PeriodSeconds: 2,
+		},
+		SecurityContext: &corev1.SecurityContext{
+			ReadOnlyRootFilesystem: &cfg.ReadOnlyRootFS,
+		},
+		VolumeMounts: []corev1.VolumeMount{{Name: "workspace", MountPath: WorkspacePath}},
+	}
+	volumes := []corev1.Volume{{
+		Name: "workspace",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: m.workspaceName(operativeID)},
+		},
+	}}
+	if cfg.ReadOnlyRootFS {
+		// Scratch space for Python, IPython and pip; /workspace stays the
+		// only persistent writable directory.
+		for i, path := range []string{"/tmp", "/root", "/var/lib/operative"} {
+			name := fmt.Sprintf("scratch-%d", i)
+			volumes = append(volumes, corev1.Volume{
+				Name:         name,
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			})
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: name, MountPath: path})
+		}
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      m.podName(operativeID),
+			Namespace: m.namespace,
+			Labels: map[string]string{
+				LabelManager:       LabelManagerValue,
+				LabelOperativeID:   operativeID,
+				LabelSandboxConfig: configHash(cfg),
+			},
+			Annotations: map[string]string{
+				AnnotationImage: sp.image,
+			},
+		},
+		Spec: corev1.PodSpec{
+			// A crashed sandbox has lost its state anyway; the reconcile loop
+			// replaces it and reports why.
+			RestartPolicy: corev1.RestartPolicyNever,
+			// Sandboxes run untrusted code and must not reach the API server
+			// with the namespace's credentials.
+			AutomountServiceAccountToken: &noToken,
+			Containers:                   []corev1.Container{container},
+			Volumes:                      volumes,
+		},
+	}
+}
+
+// ensureWorkspace creates the operative's workspace volume claim if it does
+// not exist yet.
+func (m *Manager) ensureWorkspace(ctx context.Context, operativeID string) error {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      m.workspaceName(operativeID),
+			Namespace: m.namespace,
+			Labels:    m.labels(operativeID),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: m.workspaceSize},
+			},
+		},
+	}
+	if m.storageClass != "" {
+		pvc.Spec.StorageClassName = &m.storageClass
+	}
+	_, err := m.client.CoreV1().PersistentVolumeClaims(m.namespace).Create(ctx, pvc, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("creating workspace volume claim: %w", err)
+	}
+	return nil
+}
+
+// syncNetworkPolicy creates or deletes the operative's network policy. The
+// "none" and "allowlist" networks both deny all egress: a NetworkPolicy
+// cannot allow host names, so the allow-list is not supported by this
+// backend and fails closed. Ingress (the manager reaching the gRPC port) is
+// not restricted.
+func (m *Manager) syncNetworkPolicy(ctx context.Context, operativeID string, cfg domain.SandboxConfig) error {
+	policies := m.client.NetworkingV1().NetworkPolicies(m.namespace)
+	name := m.networkPolicyName(operativeID)
+	if cfg.Network == domain.NetworkBridge {
+		if err := policies.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting network policy: %w", err)
+		}
+		return nil
+	}
+	if cfg.Network == domain.NetworkAllowlist {
+		slog.Warn("The allowlist network is not supported by the kubernetes sandbox backend, denying all egress", "operativeID", operativeID)
+	}
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: m.namespace,
+			Labels:    m.labels(operativeID),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: m.labels(operativeID)},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+		},
+	}
+	_, err := policies.Create(ctx, policy, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("creating network policy: %w", err)
+	}
+	return nil
+}
+
+// removeOrphans deletes the workspace volume claims and network policies of
+// operatives that no longer exist.
+func (m *Manager) removeOrphans(ctx context.Context, known map[string]bool) {
+	opts := metav1.ListOptions{LabelSelector: LabelManager + "=" + LabelManagerValue}
+
+	claims := m.client.CoreV1().PersistentVolumeClaims(m.namespace)
+	pvcs, err := claims.List(ctx, opts)
+	if err != nil {
+		slog.Warn("Failed to list workspace volume claims", "error", err)
+	} else {
+		for _, pvc := range pvcs.Items {
+			if opID := pvc.Labels[LabelOperativeID]; !known[opID] {
+				slog.Info("Deleting orphaned workspace volume claim", "operativeID", opID, "name", pvc.Name)
+				if err := claims.Delete(ctx, pvc.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+					slog.Warn("Failed to delete workspace volume claim", "name", pvc.Name, "error", err)
+				}
+			}
+		}
+	}
+
+	policies := m.client.NetworkingV1().NetworkPolicies(m.namespace)
+	nps, err := policies.List(ctx, opts)
+	if err != nil {
+		slog.Warn("Failed to list network policies", "error", err)
+		return
+	}
+	for _, np := range nps.Items {
+		if opID := np.Labels[LabelOperativeID]; !known[opID] {
+			if err := policies.Delete(ctx, np.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				slog.Warn("Failed to delete network policy", "name", np.Name, "error", err)
+			}
+		}
+	}
+}
+
+// deletePod deletes a sandbox pod and closes any port-forward to it.
+func (m *Manager) deletePod(ctx context.Context, name string) {
+	grace := int64(10)
+	if err := m.client.CoreV1().Pods(m.namespace).Delete(ctx, name, metav1.DeleteOptions{GracePeriodSeconds: &grace}); err != nil && !apierrors.IsNotFound(err) {
+		slog.Warn("Failed to delete pod", "pod", name, "error", err)
+	}
+	if m.forwarder != nil {
+		m.forwarder.close(name)
+	}
+}
+
+// listPods returns the managed pods, of one operative if operativeID is set,
+// newest first. Pods being deleted are left out.
+func (m *Manager) listPods(ctx context.Context, operativeID string) ([]corev1.Pod, error) {
+	selector := LabelManager + "=" + LabelManagerValue
+	if operativeID != "" {
+		selector += "," + LabelOperativeID + "=" + operativeID
+	}
+	list, err := m.client.CoreV1().Pods(m.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for _, pod := range list.Items {
+		if pod.DeletionTimestamp == nil {
+			pods = append(pods, pod)
+		}
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[j].CreationTimestamp.Before(&pods[i].CreationTimestamp)
+	})
+	return pods, nil
+}
+
+// specFor returns the desired sandbox of an operative.
+func (m *Manager) specFor(op domain.Operative) spec {
+	sp := spec{image: op.Image, config: op.Sandbox.WithDefaults()}
+	if sp.image == "" {
+		sp.image = m.image
+	}
+	return sp
+}
+
+// podReady reports whether the pod's readiness probe passes, i.e. its gRPC
+// server accepts connections.
+func podReady(pod corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// configHash identifies sandbox settings in the LabelSandboxConfig label.
+func configHash(cfg domain.SandboxConfig) string {
+	b, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (m *Manager) labels(operativeID string) map[string]string {
+	return map[string]string{
+		LabelManager:     LabelManagerValue,
+		LabelOperativeID: operativeID,
+	}
+}
+
+// podName returns a new pod name. Pods are not reused by name because a
+// deleted pod can linger while it terminates.
+func (m *Manager) podName(operativeID string) string {
+	return "operative-sandbox-" + operativeID + "-" + utilrand.String(5)
+}
+
+func (m *Manager) workspaceName(operativeID string) string {
+	return "operative-workspace-" + operativeID
+}
+
+func (m *Manager) networkPolicyName(operativeID string) string {
+	return "operative-net-" + operativeID
+}
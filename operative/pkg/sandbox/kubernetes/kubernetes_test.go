@@ -0,0 +1,359 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/sandbox"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "sandboxes"
+
+// staticLister implements sandbox.OperativeLister with a fixed list of
+// operatives.
+type staticLister struct {
+	ops []domain.Operative
+}
+
+func (l *staticLister) List(ctx context.Context) ([]domain.Operative, error) {
+	return l.ops, nil
+}
+
+func newTestManager(t *testing.T) (*Manager, *fake.Clientset) {
+	t.Helper()
+	client := fake.NewSimpleClientset()
+	// The fake clientset does not assign UIDs; use the pod names.
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.UID = types.UID(pod.Name)
+		return false, nil, nil
+	})
+	mgr, err := New(client, Config{Namespace: testNamespace})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return mgr, client
+}
+
+func listPods(t *testing.T, client *fake.Clientset) []corev1.Pod {
+	t.Helper()
+	list, err := client.CoreV1().Pods(testNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("listing pods: %v", err)
+	}
+	return list.Items
+}
+
+// onlyPod returns the single pod of the operative.
+func onlyPod(t *testing.T, client *fake.Clientset, operativeID string) corev1.Pod {
+	t.Helper()
+	var found []corev1.Pod
+	for _, pod := range listPods(t, client) {
+		if pod.Labels[LabelOperativeID] == operativeID {
+			found = append(found, pod)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("operative %s has %d pods, want 1", operativeID, len(found))
+	}
+	return found[0]
+}
+
+func nextEvent(t *testing.T, mgr *Manager) sandbox.Event {
+	t.Helper()
+	select {
+	case e := <-mgr.Events():
+		return e
+	default:
+		t.Fatal("expected a sandbox event")
+		return sandbox.Event{}
+	}
+}
+
+func noEvent(t *testing.T, mgr *Manager) {
+	t.Helper()
+	select {
+	case e := <-mgr.Events():
+		t.Fatalf("unexpected sandbox event: %+v", e)
+	default:
+	}
+}
+
+func TestReconcileCreatesPods(t *testing.T) {
+	ctx := context.Background()
+	mgr, client := newTestManager(t)
+
+	// Leftovers of a deleted operative.
+	orphanLabels := map[string]string{LabelManager: LabelManagerValue, LabelOperativeID: "gone"}
+	client.CoreV1().Pods(testNamespace).Create(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "orphan", Labels: orphanLabels}}, metav1.CreateOptions{})
+	client.CoreV1().PersistentVolumeClaims(testNamespace).Create(ctx, &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "orphan", Labels: orphanLabels}}, metav1.CreateOptions{})
+
+	lister := &staticLister{ops: []domain.Operative{
+		{ID: "op-1"},
+		{ID: "op-2", Image: "custom:1", Sandbox: domain.SandboxConfig{MemoryMB: 512, CPUs: 0.5, ReadOnlyRootFS: true}},
+	}}
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	if pods := listPods(t, client); len(pods) != 2 {
+		t.Fatalf("got %d pods, want 2 (orphan deleted)", len(pods))
+	}
+
+	pod := onlyPod(t, client, "op-1")
+	if pod.Labels[LabelManager] != LabelManagerValue {
+		t.Errorf("manager label = %q", pod.Labels[LabelManager])
+	}
+	if got := pod.Spec.Containers[0].Image; got != SandboxImage {
+		t.Errorf("image = %q, want %q", got, SandboxImage)
+	}
+	if pod.Spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("restart policy = %q, want Never", pod.Spec.RestartPolicy)
+	}
+
+	pod = onlyPod(t, client, "op-2")
+	c := pod.Spec.Containers[0]
+	if c.Image != "custom:1" || pod.Annotations[AnnotationImage] != "custom:1" {
+		t.Errorf("image = %q, annotation = %q, want custom:1", c.Image, pod.Annotations[AnnotationImage])
+	}
+	if got := c.Resources.Limits.Memory().Value(); got != 512<<20 {
+		t.Errorf("memory limit = %d, want %d", got, 512<<20)
+	}
+	if got := c.Resources.Limits.Cpu().MilliValue(); got != 500 {
+		t.Errorf("cpu limit = %dm, want 500m", got)
+	}
+	if !*c.SecurityContext.ReadOnlyRootFilesystem {
+		t.Error("root filesystem is not read-only")
+	}
+	if len(c.VolumeMounts) != 4 {
+		t.Errorf("got %d volume mounts, want workspace and 3 scratch dirs", len(c.VolumeMounts))
+	}
+
+	pvcs, _ := client.CoreV1().PersistentVolumeClaims(testNamespace).List(ctx, metav1.ListOptions{})
+	var names []string
+	for _, pvc := range pvcs.Items {
+		names = append(names, pvc.Name)
+	}
+	if got := strings.Join(names, ","); got != "operative-workspace-op-1,operative-workspace-op-2" {
+		t.Errorf("workspace claims = %s", got)
+	}
+	noEvent(t, mgr)
+
+	// Reconciling again changes nothing.
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if pods := listPods(t, client); len(pods) != 2 {
+		t.Fatalf("got %d pods after second reconcile, want 2", len(pods))
+	}
+	noEvent(t, mgr)
+}
+
+func TestReconcileRecreatesChangedPods(t *testing.T) {
+	ctx := context.Background()
+	mgr, client := newTestManager(t)
+
+	lister := &staticLister{ops: []domain.Operative{{ID: "op-1"}}}
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	before := onlyPod(t, client, "op-1")
+
+	lister.ops[0].Sandbox = domain.SandboxConfig{Network: domain.NetworkNone}
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	after := onlyPod(t, client, "op-1")
+	if after.Name == before.Name {
+		t.Fatal("pod was not recreated after the settings changed")
+	}
+	if e := nextEvent(t, mgr); e.Reason != "the sandbox settings were changed" {
+		t.Errorf("event reason = %q", e.Reason)
+	}
+	if _, err := client.NetworkingV1().NetworkPolicies(testNamespace).Get(ctx, "operative-net-op-1", metav1.GetOptions{}); err != nil {
+		t.Errorf("no network policy for the none network: %v", err)
+	}
+
+	lister.ops[0].Sandbox = domain.SandboxConfig{}
+	lister.ops[0].Image = "custom:2"
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got := onlyPod(t, client, "op-1").Spec.Containers[0].Image; got != "custom:2" {
+		t.Errorf("image = %q, want custom:2", got)
+	}
+	if e := nextEvent(t, mgr); !strings.Contains(e.Reason, "custom:2") {
+		t.Errorf("event reason = %q, want the new image", e.Reason)
+	}
+	if _, err := client.NetworkingV1().NetworkPolicies(testNamespace).Get(ctx, "operative-net-op-1", metav1.GetOptions{}); err == nil {
+		t.Error("network policy was kept for the bridge network")
+	}
+}
+
+func TestReconcileReplacesTerminatedPods(t *testing.T) {
+	ctx := context.Background()
+	mgr, client := newTestManager(t)
+
+	lister := &staticLister{ops: []domain.Operative{{ID: "op-1"}}}
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	pod := onlyPod(t, client, "op-1")
+	pod.Status = corev1.PodStatus{
+		Phase: corev1.PodFailed,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			Name: containerName,
+			State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+			},
+		}},
+	}
+	if _, err := client.CoreV1().Pods(testNamespace).UpdateStatus(ctx, &pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating pod status: %v", err)
+	}
+
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if onlyPod(t, client, "op-1").Name == pod.Name {
+		t.Fatal("failed pod was not replaced")
+	}
+	want := "the sandbox pod ran out of memory and was killed (exit code 137)"
+	if e := nextEvent(t, mgr); e.OperativeID != "op-1" || e.Reason != want {
+		t.Errorf("event = %+v, want reason %q", e, want)
+	}
+}
+
+func TestReconcileDetectsReplacedPods(t *testing.T) {
+	ctx := context.Background()
+	mgr, client := newTestManager(t)
+
+	lister := &staticLister{ops: []domain.Operative{{ID: "op-1"}}}
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	noEvent(t, mgr)
+
+	// Something else deletes the pod and recreates it.
+	pod := onlyPod(t, client, "op-1")
+	client.CoreV1().Pods(testNamespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+	pod.Name = "replacement"
+	pod.ResourceVersion = ""
+	client.CoreV1().Pods(testNamespace).Create(ctx, &pod, metav1.CreateOptions{})
+
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if e := nextEvent(t, mgr); e.Reason != "the sandbox pod was replaced" {
+		t.Errorf("event reason = %q", e.Reason)
+	}
+}
+
+// notifyingLister implements sandbox.OperativeLister and
+// sandbox.OperativeNotifier.
+type notifyingLister struct {
+	mu      sync.Mutex
+	ops     []domain.Operative
+	changes chan string
+}
+
+func (l *notifyingLister) List(ctx context.Context) ([]domain.Operative, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ops, nil
+}
+
+// add adds an operative and notifies about it.
+func (l *notifyingLister) add(op domain.Operative) {
+	l.mu.Lock()
+	l.ops = append(l.ops, op)
+	l.mu.Unlock()
+	l.changes <- op.ID
+}
+
+func (l *notifyingLister) SubscribeOperatives() (<-chan string, func()) {
+	return l.changes, func() {}
+}
+
+// eventually fails the test if cond does not hold within a fraction of
+// ReconcileInterval, i.e. without the help of the periodic reconcile.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(ReconcileInterval / 4)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRunReconcilesOnNotifications(t *testing.T) {
+	mgr, client := newTestManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lister := &notifyingLister{changes: make(chan string)}
+	go mgr.Run(ctx, lister)
+
+	podCount := func() int {
+		return len(listPods(t, client))
+	}
+
+	// Let the initial reconcile run before the operative exists.
+	time.Sleep(50 * time.Millisecond)
+	lister.add(domain.Operative{ID: "op-1"})
+	eventually(t, "the pod of a new operative", func() bool { return podCount() == 1 })
+
+	// A deleted pod is noticed through the watch and replaced.
+	pod := listPods(t, client)[0]
+	client.CoreV1().Pods(testNamespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+	eventually(t, "the deleted pod to be replaced", func() bool {
+		pods := listPods(t, client)
+		return len(pods) == 1 && pods[0].Name != pod.Name
+	})
+}
+
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+	mgr, client := newTestManager(t)
+
+	if status, err := mgr.Status(ctx, "op-1"); err != nil || status != "stopped" {
+		t.Fatalf("Status = %q, %v; want stopped", status, err)
+	}
+
+	lister := &staticLister{ops: []domain.Operative{{ID: "op-1"}}}
+	if err := mgr.reconcile(ctx, lister); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	pod := onlyPod(t, client, "op-1")
+	pod.Status.Phase = corev1.PodPending
+	client.CoreV1().Pods(testNamespace).UpdateStatus(ctx, &pod, metav1.UpdateOptions{})
+	if status, _ := mgr.Status(ctx, "op-1"); status != "pending" {
+		t.Errorf("Status = %q, want pending", status)
+	}
+	mgr.StartTimeout = 0
+	if _, err := mgr.RunCell(ctx, "op-1", "1", nil); err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Errorf("RunCell on a pending pod: err = %v, want not ready", err)
+	}
+
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	client.CoreV1().Pods(testNamespace).UpdateStatus(ctx, &pod, metav1.UpdateOptions{})
+	if status, _ := mgr.Status(ctx, "op-1"); status != "running" {
+		t.Errorf("Status = %q, want running", status)
+	}
+}
@@ -0,0 +1,113 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/nstogner/operative/pkg/sandbox/rpc"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// forwarder keeps a port-forward to the gRPC port of each sandbox pod it
+// was asked for, for managers running outside the cluster.
+type forwarder struct {
+	client kubernetes.Interface
+	config *rest.Config
+
+	mu       sync.Mutex
+	forwards map[string]*forward // keyed by pod name
+}
+
+// forward is an open port-forward to a pod.
+type forward struct {
+	addr string        // local address, 127.0.0.1:<port>
+	stop chan struct{} // closing it stops the port-forward
+	done chan struct{} // closed when the port-forward ended
+}
+
+func newForwarder(client kubernetes.Interface, config *rest.Config) *forwarder {
+	return &forwarder{
+		client:   client,
+		config:   config,
+		forwards: make(map[string]*forward),
+	}
+}
+
+// addr returns the local address of the port-forward to the pod, starting
+// one if there is none or the previous one ended.
+func (f *forwarder) addr(ctx context.Context, namespace, pod string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if fw, ok := f.forwards[pod]; ok {
+		select {
+		case <-fw.done:
+			delete(f.forwards, pod)
+		default:
+			return fw.addr, nil
+		}
+	}
+
+	transport, upgrader, err := spdy.RoundTripperFor(f.config)
+	if err != nil {
+		return "", fmt.Errorf("creating port-forward transport: %w", err)
+	}
+	url := f.client.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(pod).SubResource("portforward").URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	fw := &forward{stop: make(chan struct{}), done: make(chan struct{})}
+	ready := make(chan struct{})
+	pf, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{"0:" + rpc.ServerPort}, fw.stop, ready, io.Discard, io.Discard)
+	if err != nil {
+		return "", fmt.Errorf("creating port-forward: %w", err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- pf.ForwardPorts()
+		close(fw.done)
+	}()
+
+	select {
+	case <-ready:
+	case err := <-errc:
+		return "", fmt.Errorf("port-forwarding to pod %s: %w", pod, err)
+	case <-ctx.Done():
+		close(fw.stop)
+		return "", ctx.Err()
+	}
+	ports, err := pf.GetPorts()
+	if err != nil || len(ports) == 0 {
+		close(fw.stop)
+		return "", fmt.Errorf("port-forwarding to pod %s: no local port: %v", pod, err)
+	}
+	fw.addr = fmt.Sprintf("127.0.0.1:%d", ports[0].Local)
+	f.forwards[pod] = fw
+	return fw.addr, nil
+}
+
+// close stops the port-forward to the pod, if any.
+func (f *forwarder) close(pod string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fw, ok := f.forwards[pod]; ok {
+		close(fw.stop)
+		delete(f.forwards, pod)
+	}
+}
+
+// closeAll stops all port-forwards.
+func (f *forwarder) closeAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for pod, fw := range f.forwards {
+		close(fw.stop)
+		delete(f.forwards, pod)
+	}
+}
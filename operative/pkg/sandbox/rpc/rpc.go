@@ -0,0 +1,262 @@
+// Package rpc is the client side of the sandbox's gRPC API (sandbox.proto),
+// shared by the sandbox.Manager implementations. They differ in how they run
+// sandboxes and reach their server, not in how they talk to it.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nstogner/operative/pkg/sandbox"
+	sandboxv1 "github.com/nstogner/operative/pkg/sandbox/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// ServerPort is the gRPC port of the sandbox server.
+const ServerPort = "8000"
+
+// MaxResultSize bounds the size of a cell result message, which can carry
+// images and other rich display outputs.
+const MaxResultSize = 64 << 20
+
+// Dial connects to a sandbox server at addr (host:port).
+func Dial(addr string) (*grpc.ClientConn, error) {
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(MaxResultSize)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("dialing sandbox: %w", err)
+	}
+	return conn, nil
+}
+
+// WaitForHealth blocks until the sandbox server at addr accepts gRPC
+// connections, for up to two minutes.
+func WaitForHealth(ctx context.Context, addr string) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timeoutCtx.Done():
+			return fmt.Errorf("timeout waiting for sandbox gRPC port")
+		case <-ticker.C:
+			dialCtx, dialCancel := context.WithTimeout(timeoutCtx, 1*time.Second)
+			conn, err := grpc.DialContext(dialCtx, addr,
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithBlock(),
+			)
+			dialCancel()
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+		}
+	}
+}
+
+// Cells runs cells over the gRPC API and tracks the in-flight cell of each
+// operative so that it can be interrupted.
+type Cells struct {
+	mu      sync.Mutex
+	running map[string]*runningCell // keyed by operative ID
+}
+
+// runningCell is the gRPC stream of an in-flight cell. Sends are serialized
+// because Interrupt sends on the stream concurrently with Run.
+type runningCell struct {
+	mu     sync.Mutex
+	stream sandboxv1.Sandbox_RunStreamClient
+}
+
+func (c *runningCell) send(msg *sandboxv1.ClientMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stream.Send(msg)
+}
+
+// NewCells creates an empty Cells.
+func NewCells() *Cells {
+	return &Cells{running: make(map[string]*runningCell)}
+}
+
+// Running reports whether the operative has a cell executing.
+func (c *Cells) Running(operativeID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running[operativeID] != nil
+}
+
+// Run executes a cell on the sandbox server behind conn, handling output
+// and prompt callbacks with the delegate until the final result arrives.
+func (c *Cells) Run(ctx context.Context, conn *grpc.ClientConn, operativeID, code string, delegate sandbox.Delegate) (*sandbox.Result, error) {
+	stream, err := sandboxv1.NewSandboxClient(conn).RunStream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("starting stream: %w", err)
+	}
+	cell := &runningCell{stream: stream}
+	c.mu.Lock()
+	c.running[operativeID] = cell
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		if c.running[operativeID] == cell {
+			delete(c.running, operativeID)
+		}
+		c.mu.Unlock()
+	}()
+
+	// Send code execution request.
+	if err := cell.send(&sandboxv1.ClientMessage{
+		Payload: &sandboxv1.ClientMessage_RunCell{
+			RunCell: &sandboxv1.RunCellRequest{
+				Code: code,
+			},
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("sending run cell request: %w", err)
+	}
+
+	// Prompts are answered in the background so that the stream keeps
+	// being read while the model thinks: an interrupt releases the cell's
+	// pending prompts and its result must not wait for their answers. They
+	// are canceled once the cell is done.
+	var prompts sync.WaitGroup
+	defer prompts.Wait()
+	promptCtx, cancelPrompts := context.WithCancel(ctx)
+	defer cancelPrompts()
+
+	// Process the stream: handle output, prompt callbacks, and final result.
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stream error: %w", err)
+		}
+
+		switch payload := msg.Payload.(type) {
+		case *sandboxv1.ServerMessage_Output:
+			delegate.Output(ctx, payload.Output.Text, payload.Output.IsStderr)
+
+		case *sandboxv1.ServerMessage_RunCellResult:
+			return resultFromProto(payload.RunCellResult), nil
+
+		case *sandboxv1.ServerMessage_PromptModel:
+			prompt := payload.PromptModel
+			prompts.Add(1)
+			go func() {
+				defer prompts.Done()
+				resp, err := delegate.PromptModel(promptCtx, prompt.Prompt)
+				if promptCtx.Err() != nil {
+					return
+				}
+				responseVal := resp
+				if err != nil {
+					responseVal = fmt.Sprintf("Error: %v", err)
+				}
+				if err := cell.send(&sandboxv1.ClientMessage{
+					Payload: &sandboxv1.ClientMessage_PromptModelResponse{
+						PromptModelResponse: &sandboxv1.PromptModelResponse{
+							Id:       prompt.Id,
+							Response: responseVal,
+						},
+					},
+				}); err != nil {
+					// The stream is broken; Recv reports it.
+					slog.Error("sending prompt response failed", "error", err)
+				}
+			}()
+
+		case *sandboxv1.ServerMessage_PromptSelf:
+			if err := delegate.PromptSelf(ctx, payload.PromptSelf.Message); err != nil {
+				slog.Error("prompt self failed", "error", err)
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("stream ended without result")
+}
+
+// resultFromProto converts the sandbox's final cell result.
+func resultFromProto(r *sandboxv1.RunCellResult) *sandbox.Result {
+	result := &sandbox.Result{
+		Output:      r.Output,
+		Stdout:      r.Stdout,
+		Stderr:      r.Stderr,
+		Success:     r.Success,
+		Interrupted: r.Interrupted,
+	}
+	if !r.Success {
+		result.Error = &sandbox.CellError{
+			Name:      r.ErrorName,
+			Value:     r.ErrorValue,
+			Traceback: r.Traceback,
+		}
+	}
+	for _, d := range r.Displays {
+		result.Displays = append(result.Displays, sandbox.Display{
+			MIMEType: d.MimeType,
+			Data:     d.Data,
+		})
+	}
+	return result
+}
+
+// Interrupt sends a CancelRequest on the operative's in-flight cell stream.
+// Returns sandbox.ErrNoRunningCell if no cell is running.
+func (c *Cells) Interrupt(operativeID string) error {
+	c.mu.Lock()
+	cell := c.running[operativeID]
+	c.mu.Unlock()
+	if cell == nil {
+		return sandbox.ErrNoRunningCell
+	}
+
+	slog.Info("Interrupting cell", "operativeID", operativeID)
+	if err := cell.send(&sandboxv1.ClientMessage{
+		Payload: &sandboxv1.ClientMessage_Cancel{
+			Cancel: &sandboxv1.CancelRequest{},
+		},
+	}); err != nil {
+		return fmt.Errorf("sending cancel request: %w", err)
+	}
+	return nil
+}
+
+// NamespaceRequest sends a save/load namespace request to the sandbox
+// server behind conn and waits for its result.
+func NamespaceRequest(ctx context.Context, conn *grpc.ClientConn, req *sandboxv1.ClientMessage) (*sandboxv1.NamespaceResult, error) {
+	stream, err := sandboxv1.NewSandboxClient(conn).RunStream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("starting stream: %w", err)
+	}
+	defer stream.CloseSend()
+
+	if err := stream.Send(req); err != nil {
+		return nil, fmt.Errorf("sending namespace request: %w", err)
+	}
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil, errors.New("stream closed before namespace result")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stream error: %w", err)
+		}
+		if res := msg.GetNamespaceResult(); res != nil {
+			return res, nil
+		}
+	}
+}
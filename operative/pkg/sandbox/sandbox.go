@@ -1,6 +1,29 @@
 package sandbox
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nstogner/operative/pkg/domain"
+)
+
+var (
+	// ErrNoRunningCell is returned by Manager.Interrupt when the operative
+	// has no cell executing.
+	ErrNoRunningCell = errors.New("no cell is running")
+
+	// ErrCellRunning is returned by operations that need an idle sandbox.
+	ErrCellRunning = errors.New("a cell is running")
+
+	// ErrCheckpointNotFound is returned by Manager.Restore for unknown
+	// checkpoints.
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+
+	// ErrImageNotFound is returned by Manager.ValidateImage for images the
+	// sandbox runtime does not have.
+	ErrImageNotFound = errors.New("sandbox image not found")
+)
 
 // Result represents the output of a sandbox code execution.
 type Result struct {
@@ -10,12 +33,75 @@ type Result struct {
 	Stdout string `json:"stdout,omitempty"`
 	// Stderr is the standard error (if split).
 	Stderr string `json:"stderr,omitempty"`
+	// Success is false if the cell raised an exception, including syntax
+	// errors and KeyboardInterrupt.
+	Success bool `json:"success"`
+	// Error describes the exception when Success is false.
+	Error *CellError `json:"error,omitempty"`
+	// Interrupted reports that the cell was stopped by Interrupt before it
+	// finished. The output is whatever the cell produced until then.
+	Interrupted bool `json:"interrupted,omitempty"`
+	// Displays are the rich outputs (plots, HTML tables, ...) the cell
+	// showed, in order. Their text/plain fallbacks are already in the output.
+	Displays []Display `json:"displays,omitempty"`
+}
+
+// Display is a rich output in the richest MIME type available, e.g.
+// "image/png" or "text/html". Image data is raw (not base64-encoded).
+type Display struct {
+	MIMEType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+}
+
+// CellError describes an exception raised by a cell.
+type CellError struct {
+	// Name is the exception type, e.g. "ZeroDivisionError".
+	Name string `json:"name"`
+	// Value is the exception message.
+	Value string `json:"value,omitempty"`
+	// Traceback is the plain-text traceback. It is not part of the output.
+	Traceback string `json:"traceback,omitempty"`
+}
+
+// Checkpoint is a snapshot of an operative's sandbox: its filesystem and the
+// variables of its IPython namespace.
+type Checkpoint struct {
+	ID          string `json:"id"`
+	OperativeID string `json:"operative_id"`
+	// Image is the sandbox image the checkpoint is based on. A checkpoint
+	// can only be restored while the operative uses that image.
+	Image     string    `json:"image,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Size is the size of the snapshot in bytes, if known.
+	Size int64 `json:"size,omitempty"`
+	// SkippedVariables could not be serialized and are not restored.
+	SkippedVariables []string `json:"skipped_variables,omitempty"`
+	// Workspace reports whether the checkpoint includes the files in the
+	// workspace. Restoring a checkpoint without them keeps the current files.
+	Workspace bool `json:"workspace"`
 }
 
-// OperativeLister lists operative IDs for sandbox reconciliation.
-// This is a minimal interface to avoid importing the store package.
+// Event reports a sandbox lifecycle change that the operative should know
+// about.
+type Event struct {
+	OperativeID string
+	// Reason describes what happened, e.g. "the sandbox container exited
+	// with code 137 (out of memory)".
+	Reason string
+}
+
+// OperativeLister lists operatives, and with them their sandbox settings,
+// for sandbox reconciliation. This is a minimal interface to avoid importing
+// the store package.
 type OperativeLister interface {
-	ListIDs(ctx context.Context) ([]string, error)
+	List(ctx context.Context) ([]domain.Operative, error)
+}
+
+// OperativeNotifier is optionally implemented by an OperativeLister to report
+// operative changes as they happen (see store.OperativeStore), so that
+// sandboxes are started and removed without waiting for the next poll.
+type OperativeNotifier interface {
+	SubscribeOperatives() (<-chan string, func())
 }
 
 // Delegate defines the callbacks that sandbox code can invoke
@@ -28,6 +114,10 @@ type Delegate interface {
 	// PromptSelf sends a message to the operative's stream as if from the
 	// user/system. Does not wait for a response.
 	PromptSelf(ctx context.Context, message string) error
+
+	// Output receives stdout/stderr chunks as a cell produces them, before
+	// the cell's final Result is available. It must not block.
+	Output(ctx context.Context, text string, isStderr bool)
 }
 
 // Manager defines the interface for managing container sandboxes.
@@ -36,14 +126,47 @@ type Manager interface {
 	// Run starts a long-running reconciliation loop that keeps sandbox
 	// containers in sync with known operatives. It periodically lists
 	// operatives and ensures each has a running container. Containers
-	// for unknown operatives are stopped. Blocks until ctx is cancelled.
+	// for unknown operatives are stopped. It also reconciles right away when
+	// a sandbox dies and, if operatives implements OperativeNotifier, when an
+	// operative changes. Blocks until ctx is cancelled.
 	Run(ctx context.Context, operatives OperativeLister) error
 
 	// RunCell executes a code cell (IPython) within the sandbox for the
-	// given operative. The sandbox must already be running (started by Run).
-	// Returns an error if the sandbox is not running.
+	// given operative. The sandbox is started by Run; if it is not running
+	// yet (e.g. the operative was just created), RunCell waits for it up to
+	// an implementation-defined timeout. Returns an error if the sandbox is
+	// not running by then.
 	RunCell(ctx context.Context, operativeID, code string, delegate Delegate) (*Result, error)
 
+	// Interrupt raises KeyboardInterrupt in the cell currently executing for
+	// the given operative. The in-flight RunCell call then returns a Result
+	// with Interrupted set. Returns ErrNoRunningCell if no cell is running.
+	Interrupt(ctx context.Context, operativeID string) error
+
+	// ValidateImage checks that a sandbox image is available. An empty
+	// image is the default one. Returns ErrImageNotFound if it is not.
+	ValidateImage(ctx context.Context, image string) error
+
+	// Checkpoint snapshots the operative's sandbox, including its workspace
+	// files and IPython variables. It returns
+	// ErrCellRunning if a cell is executing.
+	Checkpoint(ctx context.Context, operativeID string) (*Checkpoint, error)
+
+	// ListCheckpoints returns the operative's checkpoints, newest first.
+	ListCheckpoints(ctx context.Context, operativeID string) ([]Checkpoint, error)
+
+	// Restore replaces the operative's sandbox with one created from a
+	// checkpoint; the current sandbox state is discarded. It returns
+	// ErrCheckpointNotFound for unknown checkpoints and ErrCellRunning if a
+	// cell is executing.
+	Restore(ctx context.Context, operativeID, checkpointID string) (*Checkpoint, error)
+
+	// Events returns a channel of sandbox restarts: whenever a sandbox that
+	// was running is replaced by a fresh one (after a crash, an exit or an
+	// external restart), all IPython state is lost and an Event is sent. The
+	// channel is shared by all callers and is never closed.
+	Events() <-chan Event
+
 	// Status returns the current status of the sandbox for the given operative.
 	// Returns one of: "running", "stopped", "unknown".
 	Status(ctx context.Context, operativeID string) (string, error)
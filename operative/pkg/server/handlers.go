@@ -1,11 +1,21 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/nstogner/operative/pkg/controller"
 	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/sandbox"
+	"github.com/nstogner/operative/pkg/store"
 )
 
 // --- Operatives ---
@@ -31,6 +41,10 @@ func (s *Server) handleCreateOperative(w http.ResponseWriter, r *http.Request) {
 	if op.CompactionThreshold == 0 {
 		op.CompactionThreshold = 0.6
 	}
+	if err := s.validateOperative(r.Context(), &op); err != nil {
+		s.errorResponse(w, http.StatusBadRequest, err)
+		return
+	}
 	if err := s.operatives.Create(r.Context(), &op); err != nil {
 		s.errorResponse(w, http.StatusInternalServerError, err)
 		return
@@ -38,6 +52,28 @@ func (s *Server) handleCreateOperative(w http.ResponseWriter, r *http.Request) {
 	s.jsonResponse(w, http.StatusCreated, op)
 }
 
+// validateOperative checks user-supplied operative settings.
+func (s *Server) validateOperative(ctx context.Context, op *domain.Operative) error {
+	if _, _, err := s.providers.Resolve(ctx, op.Provider, op.Model); err != nil {
+		return err
+	}
+	if op.CellTimeoutSeconds < 0 {
+		return errors.New("cell_timeout_seconds must not be negative")
+	}
+	if op.MaxCellOutputBytes < 0 {
+		return errors.New("max_cell_output_bytes must not be negative")
+	}
+	if op.Image != "" {
+		if !slices.Contains(s.allowedImages, op.Image) {
+			return fmt.Errorf("sandbox image %q is not in the allowed images", op.Image)
+		}
+		if err := s.sandbox.ValidateImage(ctx, op.Image); err != nil {
+			return err
+		}
+	}
+	return op.Sandbox.Validate()
+}
+
 func (s *Server) handleGetOperative(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	op, err := s.operatives.Get(r.Context(), id)
@@ -56,6 +92,10 @@ func (s *Server) handleUpdateOperative(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	op.ID = id
+	if err := s.validateOperative(r.Context(), &op); err != nil {
+		s.errorResponse(w, http.StatusBadRequest, err)
+		return
+	}
 	if err := s.operatives.Update(r.Context(), &op); err != nil {
 		s.errorResponse(w, http.StatusInternalServerError, err)
 		return
@@ -84,6 +124,84 @@ func (s *Server) handleGetStream(w http.ResponseWriter, r *http.Request) {
 	s.jsonResponse(w, http.StatusOK, entries)
 }
 
+// handlePinEntry pins (PUT) or unpins (DELETE) a user message so that
+// compaction keeps it verbatim.
+func (s *Server) handlePinEntry(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	entry, err := s.stream.SetPinned(r.Context(), id, r.PathValue("entryID"), r.Method == http.MethodPut)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotPinnable):
+			s.errorResponse(w, http.StatusBadRequest, err)
+		case errors.Is(err, store.ErrEntryNotFound):
+			s.errorResponse(w, http.StatusNotFound, err)
+		default:
+			s.errorResponse(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, entry)
+}
+
+// handleCompact compacts the operative's stream now. With ?dry_run=true the
+// proposed compaction (summary and split) is returned without applying it.
+func (s *Server) handleCompact(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			s.errorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid dry_run: %w", err))
+			return
+		}
+	}
+	if _, err := s.operatives.Get(r.Context(), id); err != nil {
+		s.errorResponse(w, http.StatusNotFound, err)
+		return
+	}
+
+	compaction, err := s.compactor.Compact(r.Context(), id, dryRun)
+	if err != nil {
+		if errors.Is(err, controller.ErrNothingToCompact) {
+			s.errorResponse(w, http.StatusConflict, err)
+			return
+		}
+		s.errorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	if dryRun {
+		s.jsonResponse(w, http.StatusOK, compaction)
+		return
+	}
+	s.jsonResponse(w, http.StatusCreated, compaction)
+}
+
+func (s *Server) handleListCompactions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	compactions, err := s.stream.ListCompactions(r.Context(), id)
+	if err != nil {
+		s.errorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, compactions)
+}
+
+// handleGetCompactedEntries returns the raw entries a compaction summary
+// replaced.
+func (s *Server) handleGetCompactedEntries(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	entries, err := s.stream.GetCompactedEntries(r.Context(), id, r.PathValue("summaryID"))
+	if err != nil {
+		if errors.Is(err, store.ErrCompactionNotFound) {
+			s.errorResponse(w, http.StatusNotFound, err)
+		} else {
+			s.errorResponse(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, entries)
+}
+
 // --- Notes ---
 
 func (s *Server) handleListNotes(w http.ResponseWriter, r *http.Request) {
@@ -182,10 +300,98 @@ func (s *Server) handleSandboxStatus(w http.ResponseWriter, r *http.Request) {
 	s.jsonResponse(w, http.StatusOK, map[string]string{"status": status})
 }
 
+func (s *Server) handleInterrupt(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.sandbox.Interrupt(r.Context(), id); err != nil {
+		if errors.Is(err, sandbox.ErrNoRunningCell) {
+			s.errorResponse(w, http.StatusConflict, err)
+			return
+		}
+		s.errorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListImages lists the sandbox images operatives may use besides the
+// default one.
+func (s *Server) handleListImages(w http.ResponseWriter, r *http.Request) {
+	images := s.allowedImages
+	if images == nil {
+		images = []string{}
+	}
+	s.jsonResponse(w, http.StatusOK, images)
+}
+
+func (s *Server) handleListCheckpoints(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	checkpoints, err := s.sandbox.ListCheckpoints(r.Context(), id)
+	if err != nil {
+		s.errorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, checkpoints)
+}
+
+func (s *Server) handleCreateCheckpoint(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	cp, err := s.sandbox.Checkpoint(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sandbox.ErrCellRunning) {
+			s.errorResponse(w, http.StatusConflict, err)
+			return
+		}
+		s.errorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.jsonResponse(w, http.StatusCreated, cp)
+}
+
+func (s *Server) handleRestoreCheckpoint(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	cp, err := s.sandbox.Restore(r.Context(), id, r.PathValue("checkpointID"))
+	if err != nil {
+		switch {
+		case errors.Is(err, sandbox.ErrCheckpointNotFound):
+			s.errorResponse(w, http.StatusNotFound, err)
+		case errors.Is(err, sandbox.ErrCellRunning):
+			s.errorResponse(w, http.StatusConflict, err)
+		default:
+			s.errorResponse(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	// Tell the operative that its sandbox state changed underneath it.
+	files := "files in /workspace were restored too"
+	if !cp.Workspace {
+		// Older checkpoints do not include a copy of the workspace.
+		files = "files in /workspace were not changed"
+	}
+	msg := fmt.Sprintf("The IPython sandbox was restored from a checkpoint taken at %s. "+
+		"Variables, imports and files outside /workspace are as they were then; %s. "+
+		"Running processes and background threads were not restored.",
+		cp.CreatedAt.Format(time.RFC3339), files)
+	if len(cp.SkippedVariables) > 0 {
+		msg += fmt.Sprintf(" These variables could not be saved and are missing: %s.", strings.Join(cp.SkippedVariables, ", "))
+	}
+	if err := s.stream.Append(r.Context(), &domain.StreamEntry{
+		ID:          uuid.New().String(),
+		OperativeID: id,
+		Role:        domain.RoleSystem,
+		ContentType: domain.ContentTypeText,
+		Content:     msg,
+	}); err != nil {
+		s.errorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, cp)
+}
+
 // --- Models ---
 
 func (s *Server) handleListModels(w http.ResponseWriter, r *http.Request) {
-	models, err := s.provider.List(r.Context())
+	models, err := s.providers.List(r.Context())
 	if err != nil {
 		s.errorResponse(w, http.StatusInternalServerError, err)
 		return
@@ -10,20 +10,37 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/events"
 	"github.com/nstogner/operative/pkg/model"
 	"github.com/nstogner/operative/pkg/sandbox"
 	"github.com/nstogner/operative/pkg/store"
 )
 
+// Compactor compacts operative streams on demand. It is implemented by
+// controller.Controller.
+type Compactor interface {
+	// Compact compacts the operative's stream, or with dryRun only plans
+	// the compaction. Returns controller.ErrNothingToCompact if the stream
+	// is too short.
+	Compact(ctx context.Context, operativeID string, dryRun bool) (*domain.Compaction, error)
+}
+
 // Server serves the web UI and REST API for the operative system.
 type Server struct {
 	operatives store.OperativeStore
 	stream     store.StreamStore
 	notes      store.NoteStore
-	provider   model.Provider
+	providers  *model.Registry
 	sandbox    sandbox.Manager
+	compactor  Compactor
+	bus        *events.Bus
 	distFS     embed.FS
 	srv        *http.Server
+
+	// allowedImages are the sandbox images operatives may use besides the
+	// default one.
+	allowedImages []string
 }
 
 // New creates a new Server.
@@ -31,17 +48,23 @@ func New(
 	operatives store.OperativeStore,
 	stream store.StreamStore,
 	notes store.NoteStore,
-	provider model.Provider,
+	providers *model.Registry,
 	sandbox sandbox.Manager,
+	compactor Compactor,
+	bus *events.Bus,
 	distFS embed.FS,
+	allowedImages []string,
 ) *Server {
 	return &Server{
-		operatives: operatives,
-		stream:     stream,
-		notes:      notes,
-		provider:   provider,
-		sandbox:    sandbox,
-		distFS:     distFS,
+		operatives:    operatives,
+		stream:        stream,
+		notes:         notes,
+		providers:     providers,
+		sandbox:       sandbox,
+		compactor:     compactor,
+		bus:           bus,
+		distFS:        distFS,
+		allowedImages: allowedImages,
 	}
 }
 
@@ -58,6 +81,11 @@ func (s *Server) Start(addr string) error {
 
 	// Stream
 	mux.HandleFunc("GET /api/operatives/{id}/stream", s.handleGetStream)
+	mux.HandleFunc("PUT /api/operatives/{id}/stream/{entryID}/pin", s.handlePinEntry)
+	mux.HandleFunc("DELETE /api/operatives/{id}/stream/{entryID}/pin", s.handlePinEntry)
+	mux.HandleFunc("POST /api/operatives/{id}/compact", s.handleCompact)
+	mux.HandleFunc("GET /api/operatives/{id}/compactions", s.handleListCompactions)
+	mux.HandleFunc("GET /api/operatives/{id}/compactions/{summaryID}/entries", s.handleGetCompactedEntries)
 
 	// Notes
 	mux.HandleFunc("GET /api/operatives/{id}/notes", s.handleListNotes)
@@ -70,6 +98,11 @@ func (s *Server) Start(addr string) error {
 
 	// Sandbox
 	mux.HandleFunc("GET /api/operatives/{id}/sandbox/status", s.handleSandboxStatus)
+	mux.HandleFunc("GET /api/sandbox/images", s.handleListImages)
+	mux.HandleFunc("POST /api/operatives/{id}/interrupt", s.handleInterrupt)
+	mux.HandleFunc("GET /api/operatives/{id}/checkpoints", s.handleListCheckpoints)
+	mux.HandleFunc("POST /api/operatives/{id}/checkpoints", s.handleCreateCheckpoint)
+	mux.HandleFunc("POST /api/operatives/{id}/checkpoints/{checkpointID}/restore", s.handleRestoreCheckpoint)
 
 	// Models
 	mux.HandleFunc("GET /api/models", s.handleListModels)
@@ -10,6 +10,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/events"
 )
 
 var upgrader = websocket.Upgrader{
@@ -39,7 +40,10 @@ func (s *Server) handleChatWebSocket(w http.ResponseWriter, r *http.Request) {
 	defer ws.Close()
 
 	done := make(chan struct{})
-	updates := s.stream.Subscribe()
+	updates, unsubscribeStream := s.stream.Subscribe()
+	defer unsubscribeStream()
+	busEvents, unsubscribe := s.bus.Subscribe(operativeID)
+	defer func() { unsubscribe() }()
 
 	// Send initial stream state.
 	sentIDs := make(map[string]bool)
@@ -70,6 +74,27 @@ func (s *Server) handleChatWebSocket(w http.ResponseWriter, r *http.Request) {
 						return
 					}
 				}
+			case ev, ok := <-busEvents:
+				if !ok {
+					// The bus dropped this client for falling behind, so it
+					// may have missed the end of a partial response. Send
+					// what was persisted meanwhile, retire any partial
+					// response, and subscribe again.
+					busEvents, unsubscribe = s.bus.Subscribe(operativeID)
+					ev = events.Event{Type: events.TypePartial, OperativeID: operativeID, Partial: &events.Partial{Done: true}}
+				}
+				// Flush persisted entries before a partial response is
+				// retired so clients never see a gap between the two.
+				if ev.Partial != nil && ev.Partial.Done {
+					if err := s.syncStream(ws, operativeID, sentIDs); err != nil {
+						slog.Error("Failed stream sync", "error", err)
+						return
+					}
+				}
+				if err := ws.WriteJSON(ev); err != nil {
+					slog.Error("Failed to write event", "error", err)
+					return
+				}
 			case <-ticker.C:
 				// Keepalive
 			}
@@ -3,7 +3,9 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
@@ -15,9 +17,10 @@ import (
 
 // Store implements OperativeStore, StreamStore, and NoteStore using SQLite.
 type Store struct {
-	db          *sql.DB
-	subscribers []chan string
-	mu          sync.RWMutex
+	db                   *sql.DB
+	subscribers          map[*subscriber]bool // stream appends
+	operativeSubscribers map[*subscriber]bool // operative changes
+	mu                   sync.RWMutex
 }
 
 // Verify interface compliance at compile time.
@@ -51,7 +54,11 @@ func New(dbPath string) (*Store, error) {
 		return nil, fmt.Errorf("open sqlite: %w", err)
 	}
 
-	s := &Store{db: db}
+	s := &Store{
+		db:                   db,
+		subscribers:          make(map[*subscriber]bool),
+		operativeSubscribers: make(map[*subscriber]bool),
+	}
 	if err := s.migrate(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("migrate: %w", err)
@@ -71,9 +78,15 @@ func (s *Store) migrate() error {
 		name TEXT NOT NULL DEFAULT '',
 		admin_instructions TEXT NOT NULL DEFAULT '',
 		operative_instructions TEXT NOT NULL DEFAULT '',
+		provider TEXT NOT NULL DEFAULT '',
 		model TEXT NOT NULL DEFAULT '',
 		compaction_model TEXT NOT NULL DEFAULT '',
 		compaction_threshold REAL NOT NULL DEFAULT 0.6,
+		cell_timeout_seconds INTEGER NOT NULL DEFAULT 0,
+		max_cell_output_bytes INTEGER NOT NULL DEFAULT 0,
+		image TEXT NOT NULL DEFAULT '',
+		sandbox_config TEXT NOT NULL DEFAULT '{}',
+		handled_seq INTEGER NOT NULL DEFAULT 0,
 		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 	);
@@ -85,6 +98,10 @@ func (s *Store) migrate() error {
 		content_type TEXT NOT NULL DEFAULT 'text',
 		content TEXT NOT NULL DEFAULT '',
 		model TEXT NOT NULL DEFAULT '',
+		first_kept_id TEXT NOT NULL DEFAULT '',
+		input_tokens INTEGER NOT NULL DEFAULT 0,
+		output_tokens INTEGER NOT NULL DEFAULT 0,
+		pinned INTEGER NOT NULL DEFAULT 0,
 		timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 		seq INTEGER NOT NULL,
 		FOREIGN KEY (operative_id) REFERENCES operatives(id) ON DELETE CASCADE
@@ -102,35 +119,108 @@ func (s *Store) migrate() error {
 	);
 	CREATE INDEX IF NOT EXISTS idx_notes_operative ON notes(operative_id);
 	`
-	_, err := s.db.Exec(schema)
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema. CREATE TABLE IF NOT EXISTS does
+	// not alter existing tables, so add them to older databases here.
+	columns := []struct{ table, name, def string }{
+		{"operatives", "provider", "TEXT NOT NULL DEFAULT ''"},
+		{"operatives", "cell_timeout_seconds", "INTEGER NOT NULL DEFAULT 0"},
+		{"operatives", "max_cell_output_bytes", "INTEGER NOT NULL DEFAULT 0"},
+		{"operatives", "handled_seq", "INTEGER NOT NULL DEFAULT 0"},
+		{"operatives", "sandbox_config", "TEXT NOT NULL DEFAULT '{}'"},
+		{"operatives", "image", "TEXT NOT NULL DEFAULT ''"},
+		{"stream_entries", "first_kept_id", "TEXT NOT NULL DEFAULT ''"},
+		{"stream_entries", "input_tokens", "INTEGER NOT NULL DEFAULT 0"},
+		{"stream_entries", "output_tokens", "INTEGER NOT NULL DEFAULT 0"},
+		{"stream_entries", "pinned", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		if err := s.ensureColumn(c.table, c.name, c.def); err != nil {
+			return fmt.Errorf("adding column %s.%s: %w", c.table, c.name, err)
+		}
+	}
+	return nil
+}
+
+// ensureColumn adds a column to a table if it does not already exist.
+func (s *Store) ensureColumn(table, column, definition string) error {
+	rows, err := s.db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
 	return err
 }
 
 // --- OperativeStore ---
 
+// operativeColumns lists the operatives columns in the order used by
+// scanOperative and the insert/update statements.
+const operativeColumns = `id, name, admin_instructions, operative_instructions, provider, model,
+	compaction_model, compaction_threshold, cell_timeout_seconds, max_cell_output_bytes, image,
+	sandbox_config, created_at, updated_at`
+
+// scanOperative scans a row selected with operativeColumns.
+func scanOperative(row interface{ Scan(...any) error }) (*domain.Operative, error) {
+	op := &domain.Operative{}
+	var sandboxConfig string
+	err := row.Scan(&op.ID, &op.Name, &op.AdminInstructions, &op.OperativeInstructions,
+		&op.Provider, &op.Model, &op.CompactionModel, &op.CompactionThreshold,
+		&op.CellTimeoutSeconds, &op.MaxCellOutputBytes, &op.Image, &sandboxConfig,
+		&op.CreatedAt, &op.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(sandboxConfig), &op.Sandbox); err != nil {
+		return nil, fmt.Errorf("decoding sandbox config of operative %s: %w", op.ID, err)
+	}
+	return op, nil
+}
+
 func (s *Store) Create(ctx context.Context, op *domain.Operative) error {
 	now := time.Now().UTC()
 	op.CreatedAt = now
 	op.UpdatedAt = now
-	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO operatives (id, name, admin_instructions, operative_instructions, model, compaction_model, compaction_threshold, created_at, updated_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+	sandboxConfig, err := json.Marshal(op.Sandbox)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO operatives (`+operativeColumns+`)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		op.ID, op.Name, op.AdminInstructions, op.OperativeInstructions,
-		op.Model, op.CompactionModel, op.CompactionThreshold,
+		op.Provider, op.Model, op.CompactionModel, op.CompactionThreshold,
+		op.CellTimeoutSeconds, op.MaxCellOutputBytes, op.Image, string(sandboxConfig),
 		op.CreatedAt, op.UpdatedAt,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	s.notify(s.operativeSubscribers, op.ID)
+	return nil
 }
 
 func (s *Store) Get(ctx context.Context, id string) (*domain.Operative, error) {
-	op := &domain.Operative{}
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, name, admin_instructions, operative_instructions, model, compaction_model, compaction_threshold, created_at, updated_at
-		 FROM operatives WHERE id = ?`, id,
-	).Scan(&op.ID, &op.Name, &op.AdminInstructions, &op.OperativeInstructions,
-		&op.Model, &op.CompactionModel, &op.CompactionThreshold,
-		&op.CreatedAt, &op.UpdatedAt,
-	)
+	op, err := scanOperative(s.db.QueryRowContext(ctx,
+		`SELECT `+operativeColumns+` FROM operatives WHERE id = ?`, id,
+	))
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("operative not found: %s", id)
 	}
@@ -139,8 +229,7 @@ func (s *Store) Get(ctx context.Context, id string) (*domain.Operative, error) {
 
 func (s *Store) List(ctx context.Context) ([]domain.Operative, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, name, admin_instructions, operative_instructions, model, compaction_model, compaction_threshold, created_at, updated_at
-		 FROM operatives ORDER BY created_at DESC`)
+		`SELECT `+operativeColumns+` FROM operatives ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -148,25 +237,29 @@ func (s *Store) List(ctx context.Context) ([]domain.Operative, error) {
 
 	var ops []domain.Operative
 	for rows.Next() {
-		var op domain.Operative
-		if err := rows.Scan(&op.ID, &op.Name, &op.AdminInstructions, &op.OperativeInstructions,
-			&op.Model, &op.CompactionModel, &op.CompactionThreshold,
-			&op.CreatedAt, &op.UpdatedAt,
-		); err != nil {
+		op, err := scanOperative(rows)
+		if err != nil {
 			return nil, err
 		}
-		ops = append(ops, op)
+		ops = append(ops, *op)
 	}
 	return ops, rows.Err()
 }
 
 func (s *Store) Update(ctx context.Context, op *domain.Operative) error {
 	op.UpdatedAt = time.Now().UTC()
+	sandboxConfig, err := json.Marshal(op.Sandbox)
+	if err != nil {
+		return err
+	}
 	result, err := s.db.ExecContext(ctx,
-		`UPDATE operatives SET name=?, admin_instructions=?, operative_instructions=?, model=?, compaction_model=?, compaction_threshold=?, updated_at=?
+		`UPDATE operatives SET name=?, admin_instructions=?, operative_instructions=?, provider=?, model=?,
+		 compaction_model=?, compaction_threshold=?, cell_timeout_seconds=?, max_cell_output_bytes=?,
+		 image=?, sandbox_config=?, updated_at=?
 		 WHERE id=?`,
 		op.Name, op.AdminInstructions, op.OperativeInstructions,
-		op.Model, op.CompactionModel, op.CompactionThreshold,
+		op.Provider, op.Model, op.CompactionModel, op.CompactionThreshold,
+		op.CellTimeoutSeconds, op.MaxCellOutputBytes, op.Image, string(sandboxConfig),
 		op.UpdatedAt, op.ID,
 	)
 	if err != nil {
@@ -176,6 +269,7 @@ func (s *Store) Update(ctx context.Context, op *domain.Operative) error {
 	if n == 0 {
 		return fmt.Errorf("operative not found: %s", op.ID)
 	}
+	s.notify(s.operativeSubscribers, op.ID)
 	return nil
 }
 
@@ -188,9 +282,14 @@ func (s *Store) Delete(ctx context.Context, id string) error {
 	if n == 0 {
 		return fmt.Errorf("operative not found: %s", id)
 	}
+	s.notify(s.operativeSubscribers, id)
 	return nil
 }
 
+func (s *Store) SubscribeOperatives() (<-chan string, func()) {
+	return s.subscribe(s.operativeSubscribers)
+}
+
 func (s *Store) UpdateInstructions(ctx context.Context, id string, adminInstructions, operativeInstructions string) error {
 	result, err := s.db.ExecContext(ctx,
 		`UPDATE operatives SET admin_instructions=?, operative_instructions=?, updated_at=? WHERE id=?`,
@@ -223,63 +322,52 @@ func (s *Store) Append(ctx context.Context, entry *domain.StreamEntry) error {
 		return err
 	}
 
+	var usage domain.Usage
+	if entry.Usage != nil {
+		usage = *entry.Usage
+	}
 	_, err = s.db.ExecContext(ctx,
-		`INSERT INTO stream_entries (id, operative_id, role, content_type, content, model, timestamp, seq)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO stream_entries (`+entryColumns+`, seq)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		entry.ID, entry.OperativeID, entry.Role, entry.ContentType,
-		entry.Content, entry.Model, entry.Timestamp, maxSeq+1,
+		entry.Content, entry.Model, entry.FirstKeptEntryID,
+		usage.InputTokens, usage.OutputTokens, entry.Pinned, entry.Timestamp, maxSeq+1,
 	)
 	if err != nil {
 		return err
 	}
 
 	// Notify subscribers.
-	s.notifySubscribers(entry.OperativeID)
+	s.notify(s.subscribers, entry.OperativeID)
 	return nil
 }
 
-func (s *Store) GetEntries(ctx context.Context, operativeID string, limit int) ([]domain.StreamEntry, error) {
-	// Find the seq of the last compaction_summary entry (if any).
-	var compactionSeq int
-	err := s.db.QueryRowContext(ctx,
-		`SELECT COALESCE(MAX(seq), 0) FROM stream_entries WHERE operative_id=? AND role=?`,
-		operativeID, domain.RoleCompactionSummary,
-	).Scan(&compactionSeq)
-	if err != nil {
-		return nil, err
-	}
-
-	query := `SELECT id, operative_id, role, content_type, content, model, timestamp
-		FROM stream_entries WHERE operative_id=? AND seq >= ? ORDER BY seq ASC`
-	var args []any
-	args = append(args, operativeID, compactionSeq)
+// entryColumns lists the stream_entries columns in the order used by
+// scanEntries and the insert statement.
+const entryColumns = `id, operative_id, role, content_type, content, model, first_kept_id, input_tokens, output_tokens, pinned, timestamp`
 
-	if limit > 0 {
-		// Subquery to get only the last N entries (from the compacted view) in ASC order.
-		query = `SELECT id, operative_id, role, content_type, content, model, timestamp FROM (
-			SELECT id, operative_id, role, content_type, content, model, timestamp, seq
-			FROM stream_entries WHERE operative_id=? AND seq >= ? ORDER BY seq DESC LIMIT ?
-		) sub ORDER BY seq ASC`
-		args = append(args, limit)
-	}
-
-	rows, err := s.db.QueryContext(ctx, query, args...)
-	if err != nil {
-		return nil, err
-	}
+// scanEntries scans rows selected with entryColumns.
+func scanEntries(rows *sql.Rows) ([]domain.StreamEntry, error) {
 	defer rows.Close()
-
 	var entries []domain.StreamEntry
 	for rows.Next() {
 		var e domain.StreamEntry
-		if err := rows.Scan(&e.ID, &e.OperativeID, &e.Role, &e.ContentType, &e.Content, &e.Model, &e.Timestamp); err != nil {
+		var usage domain.Usage
+		if err := rows.Scan(&e.ID, &e.OperativeID, &e.Role, &e.ContentType, &e.Content, &e.Model, &e.FirstKeptEntryID, &usage.InputTokens, &usage.OutputTokens, &e.Pinned, &e.Timestamp); err != nil {
 			return nil, err
 		}
+		if usage != (domain.Usage{}) {
+			e.Usage = &usage
+		}
 		entries = append(entries, e)
 	}
 	return entries, rows.Err()
 }
 
+func (s *Store) GetEntries(ctx context.Context, operativeID string, limit int) ([]domain.StreamEntry, error) {
+	return s.compactedView(ctx, operativeID, 0, limit)
+}
+
 func (s *Store) GetEntriesAfter(ctx context.Context, operativeID string, afterID string) ([]domain.StreamEntry, error) {
 	// Find the seq of the afterID entry.
 	var afterSeq int
@@ -293,56 +381,351 @@ func (s *Store) GetEntriesAfter(ctx context.Context, operativeID string, afterID
 	if err != nil {
 		return nil, err
 	}
+	return s.compactedView(ctx, operativeID, afterSeq, 0)
+}
 
+// compactedView returns the operative's entries as the model sees them: the
+// most recent compaction summary, followed by the pinned entries it covers,
+// then the entries it kept (from its first kept entry on) and those appended
+// since, in order, without older summaries. Only entries with a seq above
+// afterSeq are returned, except that pinned entries follow a returned
+// summary, and if limit > 0, only the last limit entries of the view.
+func (s *Store) compactedView(ctx context.Context, operativeID string, afterSeq, limit int) ([]domain.StreamEntry, error) {
+	// Find the most recent compaction summary and the seq of its first kept
+	// entry. Summaries without one keep nothing before themselves.
+	var compactionSeq, keptSeq int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT c.seq, COALESCE(k.seq, c.seq) FROM stream_entries c
+		 LEFT JOIN stream_entries k ON k.id = c.first_kept_id AND k.operative_id = c.operative_id
+		 WHERE c.operative_id=? AND c.role=? ORDER BY c.seq DESC LIMIT 1`,
+		operativeID, domain.RoleCompactionSummary,
+	).Scan(&compactionSeq, &keptSeq)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	query := `SELECT ` + entryColumns + ` FROM stream_entries
+		WHERE operative_id=? AND seq >= ? AND seq > ? AND role != ? ORDER BY seq DESC`
+	args := []any{operativeID, keptSeq, afterSeq, domain.RoleCompactionSummary}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	tail, err := scanEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(tail)
+
+	if compactionSeq == 0 || compactionSeq <= afterSeq || (limit > 0 && len(tail) >= limit) {
+		return tail, nil
+	}
+	// The summary, then the pinned entries it covers.
+	rows, err = s.db.QueryContext(ctx,
+		`SELECT `+entryColumns+` FROM stream_entries
+		 WHERE operative_id=? AND (seq=? OR (seq < ? AND pinned != 0 AND role != ?)) ORDER BY seq != ?, seq`,
+		operativeID, compactionSeq, keptSeq, domain.RoleCompactionSummary, compactionSeq,
+	)
+	if err != nil {
+		return nil, err
+	}
+	head, err := scanEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+	view := append(head, tail...)
+	if limit > 0 && len(view) > limit {
+		view = view[len(view)-limit:]
+	}
+	return view, nil
+}
+
+func (s *Store) SetPinned(ctx context.Context, operativeID, entryID string, pinned bool) (*domain.StreamEntry, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, operative_id, role, content_type, content, model, timestamp
-		 FROM stream_entries WHERE operative_id=? AND seq > ? ORDER BY seq ASC`,
-		operativeID, afterSeq,
+		`SELECT `+entryColumns+` FROM stream_entries WHERE id=? AND operative_id=?`, entryID, operativeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := scanEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: %s", store.ErrEntryNotFound, entryID)
+	}
+	entry := &entries[0]
+	if entry.Role != domain.RoleUser || entry.ContentType != domain.ContentTypeText {
+		return nil, store.ErrNotPinnable
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE stream_entries SET pinned=? WHERE id=? AND operative_id=?`, pinned, entryID, operativeID,
+	); err != nil {
+		return nil, err
+	}
+	entry.Pinned = pinned
+	return entry, nil
+}
+
+func (s *Store) Compact(ctx context.Context, operativeID string, summary string, firstKeptEntryID string) (*domain.StreamEntry, error) {
+	if firstKeptEntryID != "" {
+		var n int
+		if err := s.db.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM stream_entries WHERE id=? AND operative_id=?`, firstKeptEntryID, operativeID,
+		).Scan(&n); err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("first kept entry not found: %s", firstKeptEntryID)
+		}
+	}
+	// Append a compaction summary entry. GetEntries will use it as the new
+	// starting point, followed by the kept entries, hiding all older ones.
+	entry := &domain.StreamEntry{
+		ID:               fmt.Sprintf("compaction-%d", time.Now().UnixNano()),
+		OperativeID:      operativeID,
+		Role:             domain.RoleCompactionSummary,
+		ContentType:      domain.ContentTypeText,
+		Content:          summary,
+		FirstKeptEntryID: firstKeptEntryID,
+	}
+	if err := s.Append(ctx, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// compactionRange is a compaction summary and the seqs [fromSeq, toSeq) of
+// the entries it replaced.
+type compactionRange struct {
+	summary        domain.StreamEntry
+	fromSeq, toSeq int
+}
+
+// compactionRanges returns the operative's compaction summaries, oldest
+// first. Each replaced the previous summary's view: the entries from the
+// previous first kept entry (or the start of the stream) up to its own first
+// kept entry.
+func (s *Store) compactionRanges(ctx context.Context, operativeID string) ([]compactionRange, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+entryColumns+` FROM stream_entries WHERE operative_id=? AND role=? ORDER BY seq`,
+		operativeID, domain.RoleCompactionSummary,
+	)
+	if err != nil {
+		return nil, err
+	}
+	summaries, err := scanEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// The seq of each summary's first kept entry; summaries without one keep
+	// nothing before themselves.
+	rows, err = s.db.QueryContext(ctx,
+		`SELECT COALESCE(k.seq, c.seq) FROM stream_entries c
+		 LEFT JOIN stream_entries k ON k.id = c.first_kept_id AND k.operative_id = c.operative_id
+		 WHERE c.operative_id=? AND c.role=? ORDER BY c.seq`,
+		operativeID, domain.RoleCompactionSummary,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+	var ranges []compactionRange
+	fromSeq := 0
+	for i := 0; rows.Next() && i < len(summaries); i++ {
+		var keptSeq int
+		if err := rows.Scan(&keptSeq); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, compactionRange{summary: summaries[i], fromSeq: fromSeq, toSeq: keptSeq})
+		fromSeq = keptSeq
+	}
+	return ranges, rows.Err()
+}
 
-	var entries []domain.StreamEntry
+// rangeEntries returns the entries with seqs in [fromSeq, toSeq) that are
+// neither summaries nor pinned.
+func (s *Store) rangeEntries(ctx context.Context, operativeID string, fromSeq, toSeq int) ([]domain.StreamEntry, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+entryColumns+` FROM stream_entries
+		 WHERE operative_id=? AND seq >= ? AND seq < ? AND role != ? AND pinned = 0 ORDER BY seq`,
+		operativeID, fromSeq, toSeq, domain.RoleCompactionSummary,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+func (s *Store) ListCompactions(ctx context.Context, operativeID string) ([]domain.Compaction, error) {
+	ranges, err := s.compactionRanges(ctx, operativeID)
+	if err != nil {
+		return nil, err
+	}
+	var compactions []domain.Compaction
+	for _, r := range ranges {
+		c := domain.Compaction{Summary: r.summary}
+		var first, last sql.NullString
+		err := s.db.QueryRowContext(ctx,
+			`SELECT COUNT(*),
+			   (SELECT id FROM stream_entries WHERE operative_id=?1 AND seq >= ?2 AND seq < ?3 AND role != ?4 AND pinned = 0 ORDER BY seq LIMIT 1),
+			   (SELECT id FROM stream_entries WHERE operative_id=?1 AND seq >= ?2 AND seq < ?3 AND role != ?4 AND pinned = 0 ORDER BY seq DESC LIMIT 1)
+			 FROM stream_entries WHERE operative_id=?1 AND seq >= ?2 AND seq < ?3 AND role != ?4 AND pinned = 0`,
+			operativeID, r.fromSeq, r.toSeq, domain.RoleCompactionSummary,
+		).Scan(&c.EntryCount, &first, &last)
+		if err != nil {
+			return nil, err
+		}
+		c.FirstEntryID, c.LastEntryID = first.String, last.String
+		compactions = append(compactions, c)
+	}
+	return compactions, nil
+}
+
+func (s *Store) GetCompactedEntries(ctx context.Context, operativeID, summaryID string) ([]domain.StreamEntry, error) {
+	ranges, err := s.compactionRanges(ctx, operativeID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range ranges {
+		if r.summary.ID == summaryID {
+			return s.rangeEntries(ctx, operativeID, r.fromSeq, r.toSeq)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", store.ErrCompactionNotFound, summaryID)
+}
+
+func (s *Store) MarkHandled(ctx context.Context, operativeID, entryID string) error {
+	// The cursor only moves forward.
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE operatives SET handled_seq = MAX(handled_seq, COALESCE(
+			(SELECT seq FROM stream_entries WHERE id=? AND operative_id=?), 0))
+		 WHERE id=?`,
+		entryID, operativeID, operativeID,
+	)
+	return err
+}
+
+func (s *Store) ListUnhandled(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT o.id FROM operatives o WHERE EXISTS (
+			SELECT 1 FROM stream_entries e WHERE e.operative_id=o.id AND e.seq > o.handled_seq
+		)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
 	for rows.Next() {
-		var e domain.StreamEntry
-		if err := rows.Scan(&e.ID, &e.OperativeID, &e.Role, &e.ContentType, &e.Content, &e.Model, &e.Timestamp); err != nil {
+		var id string
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		entries = append(entries, e)
+		ids = append(ids, id)
 	}
-	return entries, rows.Err()
+	return ids, rows.Err()
 }
 
-func (s *Store) Compact(ctx context.Context, operativeID string, summary string) error {
-	// Append a compaction summary entry. GetEntries will use this as the new
-	// starting point, effectively hiding all older entries from the view.
-	return s.Append(ctx, &domain.StreamEntry{
-		ID:          fmt.Sprintf("compaction-%d", time.Now().UnixNano()),
-		OperativeID: operativeID,
-		Role:        domain.RoleCompactionSummary,
-		ContentType: domain.ContentTypeText,
-		Content:     summary,
-	})
+func (s *Store) Subscribe() (<-chan string, func()) {
+	return s.subscribe(s.subscribers)
 }
 
-func (s *Store) Subscribe() <-chan string {
-	ch := make(chan string, 64)
+// subscribe adds a subscriber to one of the subscriber sets.
+func (s *Store) subscribe(set map[*subscriber]bool) (<-chan string, func()) {
+	sub := &subscriber{
+		out:    make(chan string),
+		queued: make(map[string]bool),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
 	s.mu.Lock()
-	s.subscribers = append(s.subscribers, ch)
+	set[sub] = true
 	s.mu.Unlock()
-	return ch
+	go sub.run()
+
+	var once sync.Once
+	return sub.out, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(set, sub)
+			s.mu.Unlock()
+			close(sub.done)
+		})
+	}
 }
 
-func (s *Store) notifySubscribers(operativeID string) {
+// notify tells the subscribers in set about a change to the operative.
+func (s *Store) notify(set map[*subscriber]bool, operativeID string) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for _, ch := range s.subscribers {
+	for sub := range set {
+		sub.notify(operativeID)
+	}
+}
+
+// subscriber delivers operative IDs without ever dropping one. Notifications
+// for an operative that is already queued are coalesced, so the backlog is
+// bounded by the number of operatives and Append never blocks on a slow
+// subscriber.
+type subscriber struct {
+	out chan string
+
+	mu      sync.Mutex
+	pending []string        // operative IDs in notification order
+	queued  map[string]bool // operative IDs in pending
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+func (sub *subscriber) notify(operativeID string) {
+	sub.mu.Lock()
+	if !sub.queued[operativeID] {
+		sub.queued[operativeID] = true
+		sub.pending = append(sub.pending, operativeID)
+	}
+	sub.mu.Unlock()
+
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run forwards pending notifications to out until the subscription is
+// cancelled.
+func (sub *subscriber) run() {
+	for {
 		select {
-		case ch <- operativeID:
-		default:
-			// Drop if subscriber is not consuming fast enough.
+		case <-sub.wake:
+		case <-sub.done:
+			return
+		}
+		for {
+			sub.mu.Lock()
+			if len(sub.pending) == 0 {
+				sub.mu.Unlock()
+				break
+			}
+			id := sub.pending[0]
+			sub.pending = sub.pending[1:]
+			// Dequeue before delivery so that a notification arriving
+			// while the receiver is busy is delivered again afterwards.
+			delete(sub.queued, id)
+			sub.mu.Unlock()
+
+			select {
+			case sub.out <- id:
+			case <-sub.done:
+				return
+			}
 		}
 	}
 }
@@ -2,12 +2,18 @@ package sqlite
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/nstogner/operative/pkg/domain"
+	"github.com/nstogner/operative/pkg/store"
 )
 
 func newTestStore(t *testing.T) *Store {
@@ -24,15 +30,55 @@ func newTestStore(t *testing.T) *Store {
 	return s
 }
 
+func TestMigrateAddsColumns(t *testing.T) {
+	tmpFile := t.TempDir() + "/old.db"
+
+	// A database created before the provider and cell limit columns existed.
+	db, err := sql.Open("sqlite3", tmpFile)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE operatives (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		admin_instructions TEXT NOT NULL DEFAULT '',
+		operative_instructions TEXT NOT NULL DEFAULT '',
+		model TEXT NOT NULL DEFAULT '',
+		compaction_model TEXT NOT NULL DEFAULT '',
+		compaction_threshold REAL NOT NULL DEFAULT 0.6,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	); INSERT INTO operatives (id, name, model) VALUES ('op-1', 'Old', 'm');`); err != nil {
+		t.Fatalf("creating old schema: %v", err)
+	}
+	db.Close()
+
+	s, err := New(tmpFile)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer s.Close()
+
+	got, err := s.Get(context.Background(), "op-1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Name != "Old" || got.Provider != "" || got.CellTimeoutSeconds != 0 {
+		t.Errorf("got = %+v", got)
+	}
+}
+
 func TestOperativeCRUD(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
 
 	op := &domain.Operative{
-		ID:                "op-1",
-		Name:              "Test Operative",
-		AdminInstructions: "You are a test operative.",
-		Model:             "gemini-2.0-flash",
+		ID:                 "op-1",
+		Name:               "Test Operative",
+		AdminInstructions:  "You are a test operative.",
+		Provider:           "gemini",
+		Model:              "gemini-2.0-flash",
+		CellTimeoutSeconds: 30,
 	}
 
 	// Create
@@ -48,9 +94,21 @@ func TestOperativeCRUD(t *testing.T) {
 	if got.Name != "Test Operative" {
 		t.Errorf("Name = %q, want %q", got.Name, "Test Operative")
 	}
+	if got.Provider != "gemini" {
+		t.Errorf("Provider = %q, want %q", got.Provider, "gemini")
+	}
+	if got.CellTimeoutSeconds != 30 {
+		t.Errorf("CellTimeoutSeconds = %d, want 30", got.CellTimeoutSeconds)
+	}
+
+	if got.Sandbox.Network != "" || got.Sandbox.MemoryMB != 0 {
+		t.Errorf("Sandbox = %+v, want zero value", got.Sandbox)
+	}
 
 	// Update
 	got.Name = "Updated Name"
+	got.Image = "sandbox-node:latest"
+	got.Sandbox = domain.SandboxConfig{MemoryMB: 512, Network: domain.NetworkAllowlist, AllowedHosts: []string{"pypi.org"}}
 	if err := s.Update(ctx, got); err != nil {
 		t.Fatalf("Update: %v", err)
 	}
@@ -58,6 +116,13 @@ func TestOperativeCRUD(t *testing.T) {
 	if got2.Name != "Updated Name" {
 		t.Errorf("after update: Name = %q, want %q", got2.Name, "Updated Name")
 	}
+	if got2.Image != "sandbox-node:latest" {
+		t.Errorf("after update: Image = %q, want sandbox-node:latest", got2.Image)
+	}
+	if got2.Sandbox.MemoryMB != 512 || got2.Sandbox.Network != domain.NetworkAllowlist ||
+		len(got2.Sandbox.AllowedHosts) != 1 || got2.Sandbox.AllowedHosts[0] != "pypi.org" {
+		t.Errorf("after update: Sandbox = %+v", got2.Sandbox)
+	}
 
 	// List
 	ops, err := s.List(ctx)
@@ -136,6 +201,20 @@ func TestStreamAppendAndGet(t *testing.T) {
 	if limited[0].Content != entries[2].Content {
 		t.Errorf("first limited entry = %q, want %q", limited[0].Content, entries[2].Content)
 	}
+	if entries[0].Usage != nil {
+		t.Errorf("usage = %+v, want nil", entries[0].Usage)
+	}
+
+	// Usage round-trips.
+	usage := &domain.Usage{InputTokens: 1200, OutputTokens: 34}
+	s.Append(ctx, &domain.StreamEntry{
+		ID: uuid.New().String(), OperativeID: "op-1", Role: domain.RoleAssistant,
+		ContentType: domain.ContentTypeText, Content: "reply", Usage: usage,
+	})
+	entries, _ = s.GetEntries(ctx, "op-1", 1)
+	if len(entries) != 1 || entries[0].Usage == nil || *entries[0].Usage != *usage {
+		t.Errorf("entries = %+v, want usage %+v", entries, usage)
+	}
 }
 
 func TestStreamGetEntriesAfter(t *testing.T) {
@@ -187,7 +266,7 @@ func TestStreamCompaction(t *testing.T) {
 	}
 
 	// Compact — this appends a compaction_summary entry (immutable, no deletion).
-	if err := s.Compact(ctx, "op-1", "summary of first messages"); err != nil {
+	if _, err := s.Compact(ctx, "op-1", "summary of first messages", ""); err != nil {
 		t.Fatalf("Compact: %v", err)
 	}
 
@@ -228,13 +307,233 @@ func TestStreamCompaction(t *testing.T) {
 	}
 }
 
+func TestStreamCompactionKeepsTail(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	s.Create(ctx, &domain.Operative{ID: "op-1", Name: "test"})
+
+	var ids []string
+	appendMsg := func(content string) {
+		id := uuid.New().String()
+		ids = append(ids, id)
+		s.Append(ctx, &domain.StreamEntry{
+			ID:          id,
+			OperativeID: "op-1",
+			Role:        domain.RoleUser,
+			ContentType: domain.ContentTypeText,
+			Content:     content,
+		})
+	}
+	view := func(entries []domain.StreamEntry) string {
+		var contents []string
+		for _, e := range entries {
+			contents = append(contents, e.Content)
+		}
+		return strings.Join(contents, ",")
+	}
+
+	for i := 0; i < 6; i++ {
+		appendMsg(fmt.Sprintf("msg-%d", i))
+	}
+	if _, err := s.Compact(ctx, "op-1", "summary-1", ids[3]); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	appendMsg("msg-6")
+
+	entries, err := s.GetEntries(ctx, "op-1", 0)
+	if err != nil {
+		t.Fatalf("GetEntries: %v", err)
+	}
+	if got, want := view(entries), "summary-1,msg-3,msg-4,msg-5,msg-6"; got != want {
+		t.Errorf("GetEntries = %s, want %s", got, want)
+	}
+	if entries[0].FirstKeptEntryID != ids[3] {
+		t.Errorf("summary FirstKeptEntryID = %q, want %q", entries[0].FirstKeptEntryID, ids[3])
+	}
+
+	// A limit returns the end of the view; the summary only if it fits.
+	entries, _ = s.GetEntries(ctx, "op-1", 2)
+	if got, want := view(entries), "msg-5,msg-6"; got != want {
+		t.Errorf("GetEntries(limit 2) = %s, want %s", got, want)
+	}
+	entries, _ = s.GetEntries(ctx, "op-1", 5)
+	if got, want := view(entries), "summary-1,msg-3,msg-4,msg-5,msg-6"; got != want {
+		t.Errorf("GetEntries(limit 5) = %s, want %s", got, want)
+	}
+
+	// The summary was appended after msg-5, the kept entries before it.
+	entries, _ = s.GetEntriesAfter(ctx, "op-1", ids[5])
+	if got, want := view(entries), "summary-1,msg-6"; got != want {
+		t.Errorf("GetEntriesAfter(msg-5) = %s, want %s", got, want)
+	}
+
+	// A second compaction replaces the first summary.
+	if _, err := s.Compact(ctx, "op-1", "summary-2", ids[5]); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	entries, _ = s.GetEntries(ctx, "op-1", 0)
+	if got, want := view(entries), "summary-2,msg-5,msg-6"; got != want {
+		t.Errorf("GetEntries after second compaction = %s, want %s", got, want)
+	}
+
+	if _, err := s.Compact(ctx, "op-1", "summary-3", "no-such-entry"); err == nil {
+		t.Error("Compact with an unknown first kept entry succeeded")
+	}
+}
+
+func TestCompactionHistory(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	s.Create(ctx, &domain.Operative{ID: "op-1", Name: "test"})
+
+	var ids []string
+	for i := 0; i < 8; i++ {
+		id := fmt.Sprintf("msg-%d", i)
+		ids = append(ids, id)
+		s.Append(ctx, &domain.StreamEntry{
+			ID: id, OperativeID: "op-1", Role: domain.RoleUser,
+			ContentType: domain.ContentTypeText, Content: id,
+		})
+		switch i {
+		case 4:
+			if _, err := s.Compact(ctx, "op-1", "summary-1", ids[3]); err != nil {
+				t.Fatalf("Compact: %v", err)
+			}
+		case 6:
+			if _, err := s.Compact(ctx, "op-1", "summary-2", ids[5]); err != nil {
+				t.Fatalf("Compact: %v", err)
+			}
+		}
+	}
+
+	compactions, err := s.ListCompactions(ctx, "op-1")
+	if err != nil {
+		t.Fatalf("ListCompactions: %v", err)
+	}
+	if len(compactions) != 2 {
+		t.Fatalf("ListCompactions len = %d, want 2", len(compactions))
+	}
+	want := []struct {
+		summary, first, last string
+		count                int
+	}{
+		{"summary-1", "msg-0", "msg-2", 3},
+		// The second summary replaced the first one and msg-3..msg-4.
+		{"summary-2", "msg-3", "msg-4", 2},
+	}
+	for i, w := range want {
+		c := compactions[i]
+		if c.Summary.Content != w.summary || c.FirstEntryID != w.first || c.LastEntryID != w.last || c.EntryCount != w.count {
+			t.Errorf("compactions[%d] = %+v, want %+v", i, c, w)
+		}
+	}
+
+	entries, err := s.GetCompactedEntries(ctx, "op-1", compactions[1].Summary.ID)
+	if err != nil {
+		t.Fatalf("GetCompactedEntries: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ID != "msg-3" || entries[1].ID != "msg-4" {
+		t.Errorf("GetCompactedEntries = %+v", entries)
+	}
+	if _, err := s.GetCompactedEntries(ctx, "op-1", "no-such-summary"); !errors.Is(err, store.ErrCompactionNotFound) {
+		t.Errorf("GetCompactedEntries of an unknown summary error = %v, want ErrCompactionNotFound", err)
+	}
+
+	if compactions, _ := s.ListCompactions(ctx, "op-2"); len(compactions) != 0 {
+		t.Errorf("ListCompactions of an operative without compactions = %+v", compactions)
+	}
+}
+
+func TestPinnedEntries(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	s.Create(ctx, &domain.Operative{ID: "op-1", Name: "test"})
+
+	var ids []string
+	for i := 0; i < 6; i++ {
+		id := fmt.Sprintf("msg-%d", i)
+		ids = append(ids, id)
+		s.Append(ctx, &domain.StreamEntry{
+			ID: id, OperativeID: "op-1", Role: domain.RoleUser,
+			ContentType: domain.ContentTypeText, Content: id,
+		})
+	}
+	s.Append(ctx, &domain.StreamEntry{
+		ID: "reply", OperativeID: "op-1", Role: domain.RoleAssistant,
+		ContentType: domain.ContentTypeText, Content: "ok",
+	})
+
+	entry, err := s.SetPinned(ctx, "op-1", "msg-1", true)
+	if err != nil {
+		t.Fatalf("SetPinned: %v", err)
+	}
+	if !entry.Pinned || entry.Content != "msg-1" {
+		t.Errorf("SetPinned = %+v", entry)
+	}
+	if _, err := s.SetPinned(ctx, "op-1", "reply", true); !errors.Is(err, store.ErrNotPinnable) {
+		t.Errorf("SetPinned of an assistant message: %v, want ErrNotPinnable", err)
+	}
+	if _, err := s.SetPinned(ctx, "op-1", "no-such-entry", true); !errors.Is(err, store.ErrEntryNotFound) {
+		t.Errorf("SetPinned of an unknown entry: %v, want ErrEntryNotFound", err)
+	}
+
+	// The pinned entry follows the summary that covers it.
+	summary, err := s.Compact(ctx, "op-1", "summary", ids[4])
+	if err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	assertIDs := func(got []domain.StreamEntry, want ...string) {
+		t.Helper()
+		var gotIDs []string
+		for _, e := range got {
+			gotIDs = append(gotIDs, e.ID)
+		}
+		if !slices.Equal(gotIDs, want) {
+			t.Errorf("entries = %v, want %v", gotIDs, want)
+		}
+	}
+	entries, _ := s.GetEntries(ctx, "op-1", 0)
+	assertIDs(entries, summary.ID, "msg-1", "msg-4", "msg-5", "reply")
+	if !entries[1].Pinned {
+		t.Error("pinned entry lost its flag")
+	}
+	entries, _ = s.GetEntries(ctx, "op-1", 3)
+	assertIDs(entries, "msg-4", "msg-5", "reply")
+
+	// Readers that already have msg-5 get the summary and pinned entry again.
+	entries, _ = s.GetEntriesAfter(ctx, "op-1", "msg-5")
+	assertIDs(entries, summary.ID, "msg-1", "reply")
+
+	// Pinned entries are not part of what the summary replaced.
+	compactions, _ := s.ListCompactions(ctx, "op-1")
+	if len(compactions) != 1 || compactions[0].EntryCount != 3 || compactions[0].FirstEntryID != "msg-0" {
+		t.Errorf("ListCompactions = %+v", compactions)
+	}
+	replaced, _ := s.GetCompactedEntries(ctx, "op-1", summary.ID)
+	assertIDs(replaced, "msg-0", "msg-2", "msg-3")
+
+	// It survives later compactions, and leaves the view once unpinned.
+	summary, _ = s.Compact(ctx, "op-1", "summary-2", "reply")
+	entries, _ = s.GetEntries(ctx, "op-1", 0)
+	assertIDs(entries, summary.ID, "msg-1", "reply")
+	if _, err := s.SetPinned(ctx, "op-1", "msg-1", false); err != nil {
+		t.Fatalf("SetPinned: %v", err)
+	}
+	entries, _ = s.GetEntries(ctx, "op-1", 0)
+	assertIDs(entries, summary.ID, "reply")
+}
+
 func TestStreamSubscribe(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
 
 	s.Create(ctx, &domain.Operative{ID: "op-1", Name: "test"})
 
-	ch := s.Subscribe()
+	ch, unsubscribe := s.Subscribe()
+	defer unsubscribe()
 
 	s.Append(ctx, &domain.StreamEntry{
 		ID:          uuid.New().String(),
@@ -249,11 +548,163 @@ func TestStreamSubscribe(t *testing.T) {
 		if id != "op-1" {
 			t.Errorf("subscriber got %q, want %q", id, "op-1")
 		}
-	default:
+	case <-time.After(time.Second):
 		t.Error("subscriber did not receive event")
 	}
 }
 
+func TestSubscribeOperatives(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	ch, unsubscribe := s.SubscribeOperatives()
+	defer unsubscribe()
+
+	next := func(want string) {
+		t.Helper()
+		select {
+		case id := <-ch:
+			if id != want {
+				t.Errorf("subscriber got %q, want %q", id, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("subscriber was not told about %q", want)
+		}
+	}
+
+	op := &domain.Operative{ID: "op-1", Name: "test"}
+	s.Create(ctx, op)
+	next("op-1")
+
+	op.Image = "custom:1"
+	s.Update(ctx, op)
+	next("op-1")
+
+	s.Delete(ctx, "op-1")
+	next("op-1")
+
+	// Stream appends are not operative changes.
+	s.Create(ctx, &domain.Operative{ID: "op-2", Name: "two"})
+	next("op-2")
+	s.Append(ctx, &domain.StreamEntry{
+		ID:          uuid.New().String(),
+		OperativeID: "op-2",
+		Role:        domain.RoleUser,
+		ContentType: domain.ContentTypeText,
+		Content:     "hello",
+	})
+	select {
+	case id := <-ch:
+		t.Errorf("unexpected notification for %q", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStreamSubscribeCoalesces(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	s.Create(ctx, &domain.Operative{ID: "op-1", Name: "one"})
+	s.Create(ctx, &domain.Operative{ID: "op-2", Name: "two"})
+
+	ch, unsubscribe := s.Subscribe()
+
+	// Far more appends than any channel buffer, while nobody is receiving.
+	for i := 0; i < 200; i++ {
+		for _, id := range []string{"op-1", "op-2"} {
+			s.Append(ctx, &domain.StreamEntry{
+				ID:          uuid.New().String(),
+				OperativeID: id,
+				Role:        domain.RoleUser,
+				ContentType: domain.ContentTypeText,
+				Content:     fmt.Sprintf("msg %d", i),
+			})
+		}
+	}
+
+	got := map[string]int{}
+	timeout := time.After(time.Second)
+	for len(got) < 2 {
+		select {
+		case id := <-ch:
+			got[id]++
+		case <-timeout:
+			t.Fatalf("got notifications %v, want op-1 and op-2", got)
+		}
+	}
+	// Nothing is dropped, but an operative is only delivered again if it
+	// was appended to after its previous delivery.
+	if got["op-1"] > 2 || got["op-2"] > 2 {
+		t.Errorf("notifications not coalesced: %v", got)
+	}
+
+	unsubscribe()
+	unsubscribe() // idempotent
+	s.Append(ctx, &domain.StreamEntry{
+		ID:          uuid.New().String(),
+		OperativeID: "op-1",
+		Role:        domain.RoleUser,
+		ContentType: domain.ContentTypeText,
+		Content:     "after",
+	})
+}
+
+func TestMarkHandled(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	s.Create(ctx, &domain.Operative{ID: "op-1", Name: "one"})
+	s.Create(ctx, &domain.Operative{ID: "op-2", Name: "two"})
+
+	appendMsg := func(opID string) string {
+		id := uuid.New().String()
+		if err := s.Append(ctx, &domain.StreamEntry{
+			ID: id, OperativeID: opID, Role: domain.RoleUser, ContentType: domain.ContentTypeText, Content: "hi",
+		}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		return id
+	}
+	unhandled := func() []string {
+		ids, err := s.ListUnhandled(ctx)
+		if err != nil {
+			t.Fatalf("ListUnhandled: %v", err)
+		}
+		return ids
+	}
+
+	if ids := unhandled(); len(ids) != 0 {
+		t.Errorf("ListUnhandled with empty streams = %v", ids)
+	}
+
+	first := appendMsg("op-1")
+	second := appendMsg("op-1")
+	if ids := unhandled(); len(ids) != 1 || ids[0] != "op-1" {
+		t.Errorf("ListUnhandled = %v, want [op-1]", ids)
+	}
+
+	if err := s.MarkHandled(ctx, "op-1", second); err != nil {
+		t.Fatalf("MarkHandled: %v", err)
+	}
+	if ids := unhandled(); len(ids) != 0 {
+		t.Errorf("ListUnhandled after MarkHandled = %v", ids)
+	}
+
+	// The mark never moves back.
+	if err := s.MarkHandled(ctx, "op-1", first); err != nil {
+		t.Fatalf("MarkHandled: %v", err)
+	}
+	if ids := unhandled(); len(ids) != 0 {
+		t.Errorf("ListUnhandled after stale MarkHandled = %v", ids)
+	}
+
+	appendMsg("op-1")
+	appendMsg("op-2")
+	if ids := unhandled(); len(ids) != 2 {
+		t.Errorf("ListUnhandled = %v, want both operatives", ids)
+	}
+}
+
 func TestNoteCRUD(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
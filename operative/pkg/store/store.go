@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"errors"
 
 	"github.com/nstogner/operative/pkg/domain"
 )
@@ -29,34 +30,88 @@ type OperativeStore interface {
 	// UpdateInstructions updates both the admin-set and operative-set instructions
 	// for the given operative. Either may be empty to leave unchanged.
 	UpdateInstructions(ctx context.Context, id string, adminInstructions, operativeInstructions string) error
+
+	// SubscribeOperatives returns a channel that emits operative IDs whenever
+	// an operative is created, updated with Update, or deleted, and a
+	// function that cancels the subscription. Used by the sandbox manager to
+	// reconcile without waiting for its next poll. Delivery follows the same
+	// rules as StreamStore.Subscribe.
+	SubscribeOperatives() (<-chan string, func())
 }
 
+var (
+	// ErrEntryNotFound is returned by SetPinned for unknown stream entries.
+	ErrEntryNotFound = errors.New("stream entry not found")
+	// ErrCompactionNotFound is returned by GetCompactedEntries for unknown
+	// compaction summaries.
+	ErrCompactionNotFound = errors.New("compaction not found")
+
+	// ErrNotPinnable is returned by SetPinned for entries other than user
+	// messages.
+	ErrNotPinnable = errors.New("only user messages can be pinned")
+)
+
 // StreamStore manages the append-only message stream for operatives.
 // Stream entries are immutable — compaction works by appending a summary entry
-// rather than deleting old entries. Query methods return the "compacted view"
-// (entries from the most recent compaction entry onward).
+// rather than deleting old entries. Only their pinned flag can change. Query
+// methods return the "compacted view": the most recent compaction summary, the
+// pinned entries it covers, then the entries from its first kept entry onward
+// (excluding older summaries), including those appended after the summary.
 type StreamStore interface {
 	// Append adds a new entry to the end of the operative's stream.
 	// The entry's ID and Timestamp should be set by the caller.
 	Append(ctx context.Context, entry *domain.StreamEntry) error
 
-	// GetEntries returns the compacted view of entries for an operative.
-	// Only entries at or after the most recent compaction_summary entry are
-	// returned, in chronological order. If limit > 0, returns at most that many.
+	// GetEntries returns the compacted view of entries for an operative: the
+	// most recent compaction_summary entry first, then the pinned entries
+	// before its first kept entry, then the kept and newer entries, each in
+	// chronological order. If limit > 0, returns at most that many
+	// (the last ones).
 	GetEntries(ctx context.Context, operativeID string, limit int) ([]domain.StreamEntry, error)
 
 	// GetEntriesAfter returns entries appended after the given entry ID,
-	// respecting the compacted view.
+	// respecting the compacted view. If that includes a newer compaction
+	// summary, the pinned entries it covers follow it.
 	GetEntriesAfter(ctx context.Context, operativeID string, afterID string) ([]domain.StreamEntry, error)
 
-	// Compact appends a compaction_summary entry to the stream. Older entries
-	// remain in the database but are excluded from GetEntries/GetEntriesAfter.
-	Compact(ctx context.Context, operativeID string, summary string) error
+	// Compact appends a compaction_summary entry to the stream. The summary
+	// replaces the entries before firstKeptEntryID, which remain in the
+	// database but are excluded from GetEntries/GetEntriesAfter; the entry
+	// itself and later ones stay visible after the summary. An empty
+	// firstKeptEntryID keeps nothing: the summary replaces the whole view.
+	// Returns the summary entry.
+	Compact(ctx context.Context, operativeID string, summary string, firstKeptEntryID string) (*domain.StreamEntry, error)
+
+	// SetPinned pins or unpins a user message and returns the updated entry.
+	// Returns ErrNotPinnable for other entries and ErrEntryNotFound if the
+	// entry does not exist.
+	SetPinned(ctx context.Context, operativeID, entryID string, pinned bool) (*domain.StreamEntry, error)
+
+	// ListCompactions returns the operative's compactions, oldest first, with
+	// the range of entries each summary replaced.
+	ListCompactions(ctx context.Context, operativeID string) ([]domain.Compaction, error)
+
+	// GetCompactedEntries returns the entries that the compaction summary
+	// with the given ID replaced, in chronological order, as they were
+	// before compaction (the previous summary and pinned entries excluded).
+	// Returns ErrCompactionNotFound if the summary does not exist.
+	GetCompactedEntries(ctx context.Context, operativeID, summaryID string) ([]domain.StreamEntry, error)
 
 	// Subscribe returns a channel that emits operative IDs whenever new entries
-	// are appended to any operative's stream. Used by the controller to trigger
-	// the next step in the control loop.
-	Subscribe() <-chan string
+	// are appended to any operative's stream, and a function that cancels the
+	// subscription. Used by the controller to trigger the next step in the
+	// control loop. Notifications are never dropped, but repeated appends to
+	// an operative that the subscriber has not yet been told about are
+	// delivered once.
+	Subscribe() (<-chan string, func())
+
+	// MarkHandled records that the controller has stepped the operative with
+	// entryID as the latest entry in its stream. The mark never moves back.
+	MarkHandled(ctx context.Context, operativeID, entryID string) error
+
+	// ListUnhandled returns the IDs of operatives with entries appended after
+	// their last MarkHandled entry, i.e. operatives that may need a step.
+	ListUnhandled(ctx context.Context) ([]string, error)
 }
 
 // NoteStore manages persistent, searchable notes attached to operatives.
@@ -7,13 +7,28 @@ export interface Operative {
     name: string;
     admin_instructions: string;
     operative_instructions: string;
+    provider?: string;
     model: string;
     compaction_model: string;
     compaction_threshold: number;
+    cell_timeout_seconds?: number;
+    max_cell_output_bytes?: number;
+    image?: string;
+    sandbox?: SandboxConfig;
     created_at: string;
     updated_at: string;
 }
 
+// Zero or missing fields select the server defaults.
+export interface SandboxConfig {
+    memory_mb?: number;
+    cpus?: number;
+    pids_limit?: number;
+    read_only_rootfs?: boolean;
+    network?: 'bridge' | 'none' | 'allowlist';
+    allowed_hosts?: string[];
+}
+
 export interface StreamEntry {
     id: string;
     operative_id: string;
@@ -21,9 +36,61 @@ export interface StreamEntry {
     content_type: string;
     content: string;
     model: string;
+    // Set on compaction summaries: the oldest entry kept after the summary.
+    first_kept_entry_id?: string;
+    // Set on the last entry of a model response.
+    usage?: Usage;
+    // Pinned user messages stay verbatim after every compaction.
+    pinned?: boolean;
     timestamp: string;
 }
 
+// Usage is the token usage of a model request as reported by the provider.
+export interface Usage {
+    input_tokens: number;
+    output_tokens: number;
+}
+
+// Attachment is the JSON content of an 'attachment' stream entry: a rich
+// output (plot, HTML table) of the tool call that precedes it.
+export interface Attachment {
+    tool_call_id: string;
+    mime_type: string;
+    data: string; // base64
+}
+
+// ToolCallDelta is a fragment of a tool call being generated. Fragments with
+// the same index belong to the same call.
+export interface ToolCallDelta {
+    index: number;
+    id?: string;
+    name?: string;
+    arguments?: string;
+}
+
+// Transient events sent over the chat WebSocket alongside stream entries.
+export interface PartialEvent {
+    event: 'partial';
+    operative_id: string;
+    partial: {
+        text?: string;
+        tool_call?: ToolCallDelta;
+        done?: boolean;
+    };
+}
+
+export interface CellOutputEvent {
+    event: 'cell_output';
+    operative_id: string;
+    cell_output: {
+        tool_call_id: string;
+        text: string;
+        is_stderr?: boolean;
+    };
+}
+
+export type ChatEvent = PartialEvent | CellOutputEvent;
+
 export interface Note {
     id: string;
     operative_id: string;
@@ -33,6 +100,24 @@ export interface Note {
     updated_at: string;
 }
 
+export interface Checkpoint {
+    id: string;
+    operative_id: string;
+    created_at: string;
+    size?: number;
+    skipped_variables?: string[];
+    // False for older checkpoints without the files in /workspace.
+    workspace: boolean;
+}
+
+// Compaction describes a compaction summary and the entries it replaced.
+export interface Compaction {
+    summary: StreamEntry;
+    first_entry_id?: string;
+    last_entry_id?: string;
+    entry_count: number;
+}
+
 export interface Model {
     id: string;
     name: string;
@@ -66,6 +151,14 @@ export const deleteOperative = (id: string) =>
 // Stream
 export const getStream = (operativeId: string) =>
     fetchJSON<StreamEntry[]>(`/operatives/${operativeId}/stream`);
+export const setEntryPinned = (operativeId: string, entryId: string, pinned: boolean) =>
+    fetchJSON<StreamEntry>(`/operatives/${operativeId}/stream/${entryId}/pin`, { method: pinned ? 'PUT' : 'DELETE' });
+export const compactStream = (operativeId: string, dryRun = false) =>
+    fetchJSON<Compaction>(`/operatives/${operativeId}/compact?dry_run=${dryRun}`, { method: 'POST' });
+export const listCompactions = (operativeId: string) =>
+    fetchJSON<Compaction[]>(`/operatives/${operativeId}/compactions`);
+export const getCompactedEntries = (operativeId: string, summaryId: string) =>
+    fetchJSON<StreamEntry[]>(`/operatives/${operativeId}/compactions/${summaryId}/entries`);
 
 // Notes
 export const listNotes = (operativeId: string) =>
@@ -83,6 +176,15 @@ export const keywordSearchNotes = (operativeId: string, query: string) =>
 // Sandbox
 export const getSandboxStatus = (operativeId: string) =>
     fetchJSON<{ status: string }>(`/operatives/${operativeId}/sandbox/status`);
+export const interruptOperative = (operativeId: string) =>
+    fetchJSON<void>(`/operatives/${operativeId}/interrupt`, { method: 'POST' });
+export const listSandboxImages = () => fetchJSON<string[]>('/sandbox/images');
+export const listCheckpoints = (operativeId: string) =>
+    fetchJSON<Checkpoint[]>(`/operatives/${operativeId}/checkpoints`);
+export const createCheckpoint = (operativeId: string) =>
+    fetchJSON<Checkpoint>(`/operatives/${operativeId}/checkpoints`, { method: 'POST' });
+export const restoreCheckpoint = (operativeId: string, checkpointId: string) =>
+    fetchJSON<Checkpoint>(`/operatives/${operativeId}/checkpoints/${checkpointId}/restore`, { method: 'POST' });
 
 // Models
 export const listModels = () => fetchJSON<Model[]>('/models');
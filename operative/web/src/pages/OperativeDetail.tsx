@@ -1,11 +1,12 @@
 import { useEffect, useState, useRef, useCallback } from 'react';
 import { useParams, useNavigate } from 'react-router-dom';
-import type { Operative, StreamEntry, Note } from '@/lib/api';
+import type { Operative, StreamEntry, Note, ChatEvent, ToolCallDelta, Attachment, Checkpoint, SandboxConfig } from '@/lib/api';
 import {
     getOperative, updateOperative,
-    connectChat, getStream,
+    connectChat, getStream, setEntryPinned, compactStream, getCompactedEntries,
     listNotes, createNote, deleteNote, keywordSearchNotes,
-    getSandboxStatus,
+    getSandboxStatus, interruptOperative,
+    listCheckpoints, createCheckpoint, restoreCheckpoint, listSandboxImages,
 } from '@/lib/api';
 import { Button } from '@/components/ui/button';
 import { Input } from '@/components/ui/input';
@@ -16,14 +17,43 @@ import { ScrollArea } from '@/components/ui/scroll-area';
 import { Separator } from '@/components/ui/separator';
 import { Badge } from '@/components/ui/badge';
 
+// PartialResponse is a model response that is still being generated.
+interface PartialResponse {
+    text: string;
+    toolCalls: { name: string; arguments: string }[];
+}
+
+function applyDelta(prev: PartialResponse | null, text?: string, tc?: ToolCallDelta): PartialResponse {
+    const next: PartialResponse = { text: prev?.text ?? '', toolCalls: [...(prev?.toolCalls ?? [])] };
+    if (text) next.text += text;
+    if (tc) {
+        const call = next.toolCalls[tc.index] ?? { name: '', arguments: '' };
+        next.toolCalls[tc.index] = {
+            name: call.name + (tc.name ?? ''),
+            arguments: call.arguments + (tc.arguments ?? ''),
+        };
+    }
+    return next;
+}
+
 export function OperativeDetail() {
     const { id } = useParams<{ id: string }>();
     const navigate = useNavigate();
     const [operative, setOperative] = useState<Operative | null>(null);
     const [entries, setEntries] = useState<StreamEntry[]>([]);
+    const [partial, setPartial] = useState<PartialResponse | null>(null);
+    // Output of running cells, keyed by tool call ID, until the tool result arrives.
+    const [cellOutputs, setCellOutputs] = useState<Record<string, string>>({});
     const [notes, setNotes] = useState<Note[]>([]);
     const [message, setMessage] = useState('');
     const [editInstructions, setEditInstructions] = useState('');
+    const [editCellTimeout, setEditCellTimeout] = useState('');
+    const [editMaxCellOutput, setEditMaxCellOutput] = useState('');
+    const [editImage, setEditImage] = useState('');
+    const [images, setImages] = useState<string[]>([]);
+    const [editSandbox, setEditSandbox] = useState<SandboxConfig>({});
+    const [editAllowedHosts, setEditAllowedHosts] = useState('');
+    const [configError, setConfigError] = useState('');
     const [noteTitle, setNoteTitle] = useState('');
     const [noteContent, setNoteContent] = useState('');
     const [searchQuery, setSearchQuery] = useState('');
@@ -32,12 +62,21 @@ export function OperativeDetail() {
     const scrollRef = useRef<HTMLDivElement>(null);
     const [activeTab, setActiveTab] = useState('chat');
     const [sandboxStatus, setSandboxStatus] = useState<string>('unknown');
+    const [checkpoints, setCheckpoints] = useState<Checkpoint[]>([]);
+    const [checkpointBusy, setCheckpointBusy] = useState(false);
+    const [checkpointError, setCheckpointError] = useState('');
+    const [compactBusy, setCompactBusy] = useState(false);
 
     const loadOperative = useCallback(async () => {
         if (!id) return;
         const op = await getOperative(id);
         setOperative(op);
         setEditInstructions(op.admin_instructions);
+        setEditCellTimeout(op.cell_timeout_seconds ? String(op.cell_timeout_seconds) : '');
+        setEditMaxCellOutput(op.max_cell_output_bytes ? String(op.max_cell_output_bytes) : '');
+        setEditImage(op.image ?? '');
+        setEditSandbox(op.sandbox ?? {});
+        setEditAllowedHosts((op.sandbox?.allowed_hosts ?? []).join(', '));
     }, [id]);
 
     const loadNotes = useCallback(async () => {
@@ -46,10 +85,21 @@ export function OperativeDetail() {
         setNotes(n || []);
     }, [id]);
 
+    const loadCheckpoints = useCallback(async () => {
+        if (!id) return;
+        try {
+            setCheckpoints((await listCheckpoints(id)) || []);
+        } catch (err) {
+            console.error('Listing checkpoints failed', err);
+        }
+    }, [id]);
+
     useEffect(() => {
         loadOperative();
         loadNotes();
-    }, [loadOperative, loadNotes]);
+        loadCheckpoints();
+        listSandboxImages().then(setImages).catch(() => setImages([]));
+    }, [loadOperative, loadNotes, loadCheckpoints]);
 
     // Poll sandbox status every 3s until running.
     useEffect(() => {
@@ -78,13 +128,45 @@ export function OperativeDetail() {
         wsRef.current = ws;
 
         ws.onmessage = (event) => {
-            const entry: StreamEntry = JSON.parse(event.data);
+            const data = JSON.parse(event.data);
+            if ('event' in data) {
+                const ev = data as ChatEvent;
+                if (ev.event === 'partial') {
+                    if (ev.partial.done) {
+                        setPartial(null);
+                    } else {
+                        setPartial((prev) => applyDelta(prev, ev.partial.text, ev.partial.tool_call));
+                    }
+                } else if (ev.event === 'cell_output') {
+                    const { tool_call_id, text } = ev.cell_output;
+                    setCellOutputs((prev) => ({ ...prev, [tool_call_id]: (prev[tool_call_id] ?? '') + text }));
+                }
+                return;
+            }
+            const entry: StreamEntry = data;
+            if (entry.content_type === 'tool_result') {
+                try {
+                    const { tool_call_id } = JSON.parse(entry.content);
+                    setCellOutputs((prev) => {
+                        if (!(tool_call_id in prev)) return prev;
+                        const next = { ...prev };
+                        delete next[tool_call_id];
+                        return next;
+                    });
+                } catch { /* ignore malformed result */ }
+            }
             setEntries((prev) => {
                 if (prev.some((e) => e.id === entry.id)) return prev;
-                // When a compaction_summary arrives, discard all prior entries
-                // and start the view from the compaction entry.
+                // When a compaction_summary arrives, discard the entries it
+                // summarizes: the view starts with the summary, followed by the
+                // pinned entries it covers and the entries from its first kept
+                // entry on.
                 if (entry.role === 'compaction_summary') {
-                    return [entry];
+                    const kept = prev.findIndex((e) => e.id === entry.first_kept_entry_id);
+                    const covered = kept >= 0 ? prev.slice(0, kept) : prev;
+                    const pinned = covered.filter((e) => e.pinned && e.role !== 'compaction_summary');
+                    const tail = kept >= 0 ? prev.slice(kept).filter((e) => e.role !== 'compaction_summary') : [];
+                    return [entry, ...pinned, ...tail];
                 }
                 return [...prev, entry];
             });
@@ -103,10 +185,84 @@ export function OperativeDetail() {
     // Auto-scroll
     useEffect(() => {
         scrollRef.current?.scrollIntoView({ behavior: 'smooth' });
-    }, [entries]);
+    }, [entries, partial, cellOutputs]);
 
     const sandboxReady = sandboxStatus === 'running';
 
+    // A cell is running while the latest model turn has a run_ipython_cell
+    // call. Results for a turn's calls are appended together once all of
+    // them finish.
+    const cellRunning = (() => {
+        for (let i = entries.length - 1; i >= 0; i--) {
+            const e = entries[i];
+            if (e.role === 'system') continue;
+            if (e.role !== 'assistant') return false;
+            if (e.content_type === 'tool_call' && e.content.includes('"run_ipython_cell"')) return true;
+        }
+        return false;
+    })();
+
+    const handleInterrupt = async () => {
+        if (!id) return;
+        try {
+            await interruptOperative(id);
+        } catch (err) {
+            console.error('Interrupt failed', err);
+        }
+    };
+
+    // Checkpoint operations replace or pause the container, so only one runs
+    // at a time.
+    const withCheckpointBusy = async (fn: () => Promise<unknown>) => {
+        setCheckpointBusy(true);
+        setCheckpointError('');
+        try {
+            await fn();
+        } catch (err) {
+            setCheckpointError(err instanceof Error ? err.message : String(err));
+        } finally {
+            setCheckpointBusy(false);
+            loadCheckpoints();
+        }
+    };
+
+    const handleCreateCheckpoint = () => {
+        if (!id) return;
+        withCheckpointBusy(() => createCheckpoint(id));
+    };
+
+    const handleRestoreCheckpoint = (cp: Checkpoint) => {
+        if (!id) return;
+        if (!confirm(`Restore the sandbox to ${new Date(cp.created_at).toLocaleString()}? Its current state will be lost.`)) return;
+        withCheckpointBusy(() => restoreCheckpoint(id, cp.id));
+    };
+
+    // Compacting previews the summary first; the stream picks up the applied
+    // summary like any other entry.
+    const handleCompact = async () => {
+        if (!id) return;
+        setCompactBusy(true);
+        try {
+            const preview = await compactStream(id, true);
+            if (!confirm(`Replace ${preview.entry_count} entries with this summary?\n\n${preview.summary.content}`)) return;
+            await compactStream(id);
+        } catch (err) {
+            alert(`Compaction failed: ${err instanceof Error ? err.message : String(err)}`);
+        } finally {
+            setCompactBusy(false);
+        }
+    };
+
+    const handleTogglePin = async (entry: StreamEntry) => {
+        if (!id) return;
+        try {
+            const updated = await setEntryPinned(id, entry.id, !entry.pinned);
+            setEntries((prev) => prev.map((e) => (e.id === updated.id ? updated : e)));
+        } catch (err) {
+            console.error('Pinning failed', err);
+        }
+    };
+
     const sendMessage = () => {
         if (!message.trim() || !wsRef.current || !sandboxReady) return;
         wsRef.current.send(JSON.stringify({ content: message }));
@@ -120,9 +276,26 @@ export function OperativeDetail() {
         }
     };
 
-    const saveInstructions = async () => {
+    const saveConfig = async () => {
         if (!id || !operative) return;
-        await updateOperative(id, { ...operative, admin_instructions: editInstructions });
+        const allowedHosts = editAllowedHosts.split(',').map((h) => h.trim()).filter(Boolean);
+        setConfigError('');
+        try {
+            await updateOperative(id, {
+                ...operative,
+                admin_instructions: editInstructions,
+                cell_timeout_seconds: Number(editCellTimeout) || 0,
+                max_cell_output_bytes: Number(editMaxCellOutput) || 0,
+                image: editImage || undefined,
+                sandbox: {
+                    ...editSandbox,
+                    allowed_hosts: editSandbox.network === 'allowlist' ? allowedHosts : undefined,
+                },
+            });
+        } catch (err) {
+            setConfigError(err instanceof Error ? err.message : String(err));
+            return;
+        }
         loadOperative();
     };
 
@@ -157,15 +330,17 @@ export function OperativeDetail() {
                     <div className="flex items-center gap-3">
                         <Button variant="ghost" onClick={() => navigate('/operatives')}>← Back</Button>
                         <h1 className="text-2xl font-bold">{operative.name}</h1>
+                        {operative.provider && <Badge variant="outline">{operative.provider}</Badge>}
                         <Badge variant="outline">{operative.model}</Badge>
                     </div>
                 </div>
 
                 <Tabs value={activeTab} onValueChange={setActiveTab} className="w-full">
-                    <TabsList className="grid w-full grid-cols-3">
+                    <TabsList className="grid w-full grid-cols-4">
                         <TabsTrigger value="chat">Chat</TabsTrigger>
                         <TabsTrigger value="config">Config</TabsTrigger>
                         <TabsTrigger value="notes">Notes</TabsTrigger>
+                        <TabsTrigger value="sandbox">Sandbox</TabsTrigger>
                     </TabsList>
 
                     {/* Chat Tab */}
@@ -183,8 +358,9 @@ export function OperativeDetail() {
                                 <ScrollArea className="flex-1 p-4">
                                     <div className="space-y-4">
                                         {entries.map((entry) => (
-                                            <MessageBubble key={entry.id} entry={entry} />
+                                            <MessageBubble key={entry.id} entry={entry} cellOutputs={cellOutputs} onTogglePin={handleTogglePin} />
                                         ))}
+                                        {partial && <PartialBubble partial={partial} />}
                                         <div ref={scrollRef} />
                                     </div>
                                 </ScrollArea>
@@ -199,6 +375,12 @@ export function OperativeDetail() {
                                         rows={1}
                                         disabled={!sandboxReady}
                                     />
+                                    {cellRunning && (
+                                        <Button onClick={handleInterrupt} size="lg" variant="destructive">Interrupt</Button>
+                                    )}
+                                    <Button onClick={handleCompact} size="lg" variant="outline" disabled={compactBusy || cellRunning}>
+                                        {compactBusy ? 'Compacting…' : 'Compact'}
+                                    </Button>
                                     <Button onClick={sendMessage} size="lg" disabled={!sandboxReady}>Send</Button>
                                 </div>
                             </CardContent>
@@ -228,7 +410,115 @@ export function OperativeDetail() {
                                     <label className="text-sm font-medium">Operative Self-Set Instructions</label>
                                     <Textarea value={operative.operative_instructions} disabled rows={4} />
                                 </div>
-                                <Button onClick={saveInstructions}>Save Instructions</Button>
+                                <div className="grid gap-4 sm:grid-cols-2">
+                                    <div>
+                                        <label className="text-sm font-medium">Cell Timeout (seconds)</label>
+                                        <Input
+                                            type="number"
+                                            min={0}
+                                            value={editCellTimeout}
+                                            onChange={(e) => setEditCellTimeout(e.target.value)}
+                                            placeholder="Default (300)"
+                                        />
+                                    </div>
+                                    <div>
+                                        <label className="text-sm font-medium">Max Cell Output (bytes)</label>
+                                        <Input
+                                            type="number"
+                                            min={0}
+                                            value={editMaxCellOutput}
+                                            onChange={(e) => setEditMaxCellOutput(e.target.value)}
+                                            placeholder="Default (16384)"
+                                        />
+                                    </div>
+                                </div>
+                                <Separator />
+                                <div>
+                                    <p className="text-sm font-medium">Sandbox</p>
+                                    <p className="text-xs text-muted-foreground">
+                                        Changing these settings recreates the sandbox; files in /workspace are kept.
+                                    </p>
+                                </div>
+                                <div>
+                                    <label className="text-sm font-medium">Image</label>
+                                    <select
+                                        className="flex h-9 w-full rounded-md border border-input bg-transparent px-3 py-1 text-sm"
+                                        value={editImage}
+                                        onChange={(e) => setEditImage(e.target.value)}
+                                    >
+                                        <option value="">Default</option>
+                                        {/* Show the current image even if it is no longer allowed. */}
+                                        {editImage && !images.includes(editImage) && <option value={editImage}>{editImage}</option>}
+                                        {images.map((image) => <option key={image} value={image}>{image}</option>)}
+                                    </select>
+                                </div>
+                                <div className="grid gap-4 sm:grid-cols-3">
+                                    <div>
+                                        <label className="text-sm font-medium">Memory (MB)</label>
+                                        <Input
+                                            type="number"
+                                            min={0}
+                                            value={editSandbox.memory_mb || ''}
+                                            onChange={(e) => setEditSandbox({ ...editSandbox, memory_mb: Number(e.target.value) || undefined })}
+                                            placeholder="Default (2048)"
+                                        />
+                                    </div>
+                                    <div>
+                                        <label className="text-sm font-medium">CPUs</label>
+                                        <Input
+                                            type="number"
+                                            min={0}
+                                            step={0.5}
+                                            value={editSandbox.cpus || ''}
+                                            onChange={(e) => setEditSandbox({ ...editSandbox, cpus: Number(e.target.value) || undefined })}
+                                            placeholder="Default (2)"
+                                        />
+                                    </div>
+                                    <div>
+                                        <label className="text-sm font-medium">Process Limit</label>
+                                        <Input
+                                            type="number"
+                                            min={0}
+                                            value={editSandbox.pids_limit || ''}
+                                            onChange={(e) => setEditSandbox({ ...editSandbox, pids_limit: Number(e.target.value) || undefined })}
+                                            placeholder="Default (512)"
+                                        />
+                                    </div>
+                                </div>
+                                <div className="grid gap-4 sm:grid-cols-2">
+                                    <div>
+                                        <label className="text-sm font-medium">Network</label>
+                                        <select
+                                            className="flex h-9 w-full rounded-md border border-input bg-transparent px-3 py-1 text-sm"
+                                            value={editSandbox.network || 'bridge'}
+                                            onChange={(e) => setEditSandbox({ ...editSandbox, network: e.target.value as SandboxConfig['network'] })}
+                                        >
+                                            <option value="bridge">Unrestricted</option>
+                                            <option value="allowlist">Allowed hosts only</option>
+                                            <option value="none">None</option>
+                                        </select>
+                                    </div>
+                                    {editSandbox.network === 'allowlist' && (
+                                        <div>
+                                            <label className="text-sm font-medium">Allowed Hosts</label>
+                                            <Input
+                                                value={editAllowedHosts}
+                                                onChange={(e) => setEditAllowedHosts(e.target.value)}
+                                                placeholder="pypi.org, *.githubusercontent.com"
+                                            />
+                                        </div>
+                                    )}
+                                </div>
+                                <label className="flex items-center gap-2 text-sm">
+                                    <input
+                                        type="checkbox"
+                                        checked={!!editSandbox.read_only_rootfs}
+                                        onChange={(e) => setEditSandbox({ ...editSandbox, read_only_rootfs: e.target.checked || undefined })}
+                                    />
+                                    Read-only root filesystem (disables checkpoints)
+                                </label>
+                                {configError && <p className="text-sm text-destructive">{configError}</p>}
+                                <Button onClick={saveConfig}>Save Configuration</Button>
                             </CardContent>
                         </Card>
                     </TabsContent>
@@ -318,13 +608,70 @@ export function OperativeDetail() {
                             </Card>
                         </div>
                     </TabsContent>
+
+                    {/* Sandbox Tab */}
+                    <TabsContent value="sandbox" className="mt-4">
+                        <Card>
+                            <CardHeader className="flex flex-row items-center justify-between">
+                                <CardTitle>Checkpoints ({checkpoints.length})</CardTitle>
+                                <Button
+                                    onClick={handleCreateCheckpoint}
+                                    disabled={!sandboxReady || cellRunning || checkpointBusy}
+                                >
+                                    Create Checkpoint
+                                </Button>
+                            </CardHeader>
+                            <CardContent className="space-y-2">
+                                <p className="text-xs text-muted-foreground">
+                                    A checkpoint saves the sandbox filesystem, including /workspace, and IPython variables.
+                                    Running processes and background threads are not saved.
+                                </p>
+                                {checkpointError && <p className="text-sm text-destructive">{checkpointError}</p>}
+                                {checkpoints.length === 0 ? (
+                                    <p className="text-muted-foreground text-sm">No checkpoints yet.</p>
+                                ) : (
+                                    checkpoints.map((cp) => (
+                                        <div key={cp.id} className="flex items-start justify-between p-3 rounded-lg border">
+                                            <div>
+                                                <p className="font-medium text-sm">{new Date(cp.created_at).toLocaleString()}</p>
+                                                <p className="text-xs text-muted-foreground mt-1">
+                                                    {cp.size ? `${(cp.size / 1e6).toFixed(1)} MB` : ''}
+                                                    {cp.skipped_variables?.length
+                                                        ? ` · not saved: ${cp.skipped_variables.join(', ')}`
+                                                        : ''}
+                                                    {cp.workspace ? '' : ' · /workspace not included'}
+                                                </p>
+                                            </div>
+                                            <Button
+                                                variant="outline"
+                                                size="sm"
+                                                onClick={() => handleRestoreCheckpoint(cp)}
+                                                disabled={cellRunning || checkpointBusy}
+                                            >
+                                                Restore
+                                            </Button>
+                                        </div>
+                                    ))
+                                )}
+                            </CardContent>
+                        </Card>
+                    </TabsContent>
                 </Tabs>
             </div>
         </div>
     );
 }
 
-function MessageBubble({ entry }: { entry: StreamEntry }) {
+function MessageBubble({ entry, cellOutputs, onTogglePin }: {
+    entry: StreamEntry;
+    cellOutputs: Record<string, string>;
+    // Set where user messages can be pinned.
+    onTogglePin?: (entry: StreamEntry) => void;
+}) {
+    if (entry.content_type === 'attachment') {
+        return <AttachmentBubble entry={entry} />;
+    }
+
     const isUser = entry.role === 'user';
     const isCompaction = entry.role === 'compaction_summary';
     const isSystem = entry.role === 'system';
@@ -334,6 +681,7 @@ function MessageBubble({ entry }: { entry: StreamEntry }) {
 
     let content = entry.content;
     let toolInfo: { name?: string; id?: string } | null = null;
+    let isError = false;
 
     // Parse tool call/result JSON
     if (isToolCall) {
@@ -348,21 +696,13 @@ function MessageBubble({ entry }: { entry: StreamEntry }) {
     if (isToolResult) {
         try {
             const tr = JSON.parse(content);
+            isError = !!tr.is_error;
             content = tr.content || JSON.stringify(tr, null, 2);
         } catch { /* use raw content */ }
     }
 
     if (isCompaction) {
-        return (
-            <div className="animate-fade-in">
-                <div className="rounded-lg border border-dashed border-muted-foreground/30 bg-muted/30 p-3 mx-4">
-                    <div className="flex items-center gap-2 mb-1">
-                        <Badge variant="secondary" className="text-xs">📋 Compaction Summary</Badge>
-                    </div>
-                    <p className="text-xs text-muted-foreground whitespace-pre-wrap">{content}</p>
-                </div>
-            </div>
-        );
+        return <CompactionBubble entry={entry} />;
     }
 
     if (isSystem) {
@@ -377,7 +717,9 @@ function MessageBubble({ entry }: { entry: StreamEntry }) {
         <div className={`flex ${isUser ? 'justify-end' : 'justify-start'}`}>
             <div className={`max-w-[80%] rounded-lg p-3 ${isUser
                 ? 'bg-primary text-primary-foreground'
-                : isTool || isToolResult
+                : isError
+                    ? 'bg-destructive/10 border border-dashed border-destructive/50'
+                    : isTool || isToolResult
                     ? 'bg-muted border border-dashed'
                     : isToolCall
                         ? 'bg-muted/50 border'
@@ -388,11 +730,111 @@ function MessageBubble({ entry }: { entry: StreamEntry }) {
                         <Badge variant="outline" className="text-xs">{toolInfo.name}</Badge>
                     </div>
                 )}
-                {isTool && <Badge variant="outline" className="text-xs mb-1">Tool Result</Badge>}
+                {isTool && (
+                    <Badge variant={isError ? 'destructive' : 'outline'} className="text-xs mb-1">
+                        {isError ? 'Tool Error' : 'Tool Result'}
+                    </Badge>
+                )}
                 <p className="text-sm whitespace-pre-wrap break-words">{content}</p>
+                {toolInfo?.id && cellOutputs[toolInfo.id] !== undefined && (
+                    <pre className="mt-2 max-h-64 overflow-auto rounded bg-background/60 p-2 text-xs whitespace-pre-wrap break-words">
+                        {cellOutputs[toolInfo.id]}
+                    </pre>
+                )}
                 {entry.model && (
                     <p className="text-xs opacity-50 mt-1">{entry.model}</p>
                 )}
+                {isUser && entry.content_type === 'text' && (entry.pinned || onTogglePin) && (
+                    <button
+                        className={`text-xs mt-1 ${entry.pinned ? 'opacity-100' : 'opacity-50 hover:opacity-100'}`}
+                        onClick={() => onTogglePin?.(entry)}
+                        disabled={!onTogglePin}
+                        title={entry.pinned ? 'Pinned: kept verbatim through compaction. Click to unpin.' : 'Pin to keep verbatim through compaction'}
+                    >
+                        {entry.pinned ? '📌 Pinned' : '📌 Pin'}
+                    </button>
+                )}
+            </div>
+        </div>
+    );
+}
+
+// CompactionBubble renders a compaction summary, with the entries it replaced
+// loaded on demand.
+function CompactionBubble({ entry }: { entry: StreamEntry }) {
+    const [original, setOriginal] = useState<StreamEntry[] | null>(null);
+    const [expanded, setExpanded] = useState(false);
+
+    const toggle = async () => {
+        if (!expanded && original === null) {
+            try {
+                setOriginal((await getCompactedEntries(entry.operative_id, entry.id)) || []);
+            } catch (err) {
+                console.error('Loading compacted entries failed', err);
+                return;
+            }
+        }
+        setExpanded(!expanded);
+    };
+
+    return (
+        <div className="animate-fade-in">
+            <div className="rounded-lg border border-dashed border-muted-foreground/30 bg-muted/30 p-3 mx-4">
+                <div className="flex items-center gap-2 mb-1">
+                    <Badge variant="secondary" className="text-xs">📋 Compaction Summary</Badge>
+                    <Button variant="ghost" size="sm" className="h-6 text-xs" onClick={toggle}>
+                        {expanded ? 'Hide original entries' : 'Show original entries'}
+                    </Button>
+                </div>
+                <p className="text-xs text-muted-foreground whitespace-pre-wrap">{entry.content}</p>
+                {expanded && original && (
+                    <div className="space-y-4 mt-3 pt-3 border-t border-dashed opacity-75">
+                        {original.map((e) => <MessageBubble key={e.id} entry={e} cellOutputs={{}} />)}
+                    </div>
+                )}
+            </div>
+        </div>
+    );
+}
+
+function AttachmentBubble({ entry }: { entry: StreamEntry }) {
+    let attachment: Attachment;
+    try {
+        attachment = JSON.parse(entry.content);
+    } catch {
+        return null;
+    }
+    const src = `data:${attachment.mime_type};base64,${attachment.data}`;
+
+    return (
+        <div className="flex justify-start">
+            <div className="max-w-[80%] rounded-lg p-3 bg-muted border border-dashed">
+                <Badge variant="outline" className="text-xs mb-1">{attachment.mime_type}</Badge>
+                {attachment.mime_type.startsWith('image/') ? (
+                    <img src={src} alt={attachment.mime_type} className="max-w-full rounded bg-white" />
+                ) : (
+                    // Untrusted cell output: render with scripts and same-origin access disabled.
+                    <iframe src={src} sandbox="" title={attachment.mime_type} className="w-[640px] max-w-full h-80 rounded bg-white" />
+                )}
+            </div>
+        </div>
+    );
+}
+
+function PartialBubble({ partial }: { partial: PartialResponse }) {
+    return (
+        <div className="flex justify-start">
+            <div className="max-w-[80%] rounded-lg p-3 bg-muted space-y-2">
+                {partial.text && (
+                    <p className="text-sm whitespace-pre-wrap break-words">{partial.text}</p>
+                )}
+                {partial.toolCalls.filter(Boolean).map((tc, i) => (
+                    <div key={i}>
+                        <Badge variant="outline" className="text-xs mb-1">{tc.name}</Badge>
+                        <p className="text-sm whitespace-pre-wrap break-words font-mono opacity-70">{tc.arguments}</p>
+                    </div>
+                ))}
+                <div className="h-2 w-2 rounded-full bg-muted-foreground animate-pulse" />
             </div>
         </div>
     );
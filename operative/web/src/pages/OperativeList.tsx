@@ -9,6 +9,9 @@ import { Textarea } from '@/components/ui/textarea';
 import { Badge } from '@/components/ui/badge';
 import { Dialog, DialogContent, DialogHeader, DialogTitle, DialogTrigger } from '@/components/ui/dialog';
 
+// modelKey identifies a model across providers as "provider/id".
+const modelKey = (m: Model) => `${m.provider}/${m.id}`;
+
 export function OperativeList() {
     const navigate = useNavigate();
     const [operatives, setOperatives] = useState<Operative[]>([]);
@@ -24,7 +27,7 @@ export function OperativeList() {
         setOperatives(ops || []);
         setModels(mods || []);
         if (mods?.length && !newModel) {
-            setNewModel(mods[0].id);
+            setNewModel(modelKey(mods[0]));
         }
 
         // Load sandbox statuses
@@ -44,9 +47,11 @@ export function OperativeList() {
 
     const handleCreate = async () => {
         if (!newName.trim()) return;
+        const [provider, ...rest] = newModel.split('/');
         await createOperative({
             name: newName,
-            model: newModel,
+            provider,
+            model: rest.join('/'),
             admin_instructions: newInstructions,
         });
         setNewName('');
@@ -94,7 +99,9 @@ export function OperativeList() {
                                         onChange={(e) => setNewModel(e.target.value)}
                                     >
                                         {models.map((m) => (
-                                            <option key={m.id} value={m.id}>{m.name || m.id}</option>
+                                            <option key={modelKey(m)} value={modelKey(m)}>
+                                                {m.provider}: {m.name || m.id}
+                                            </option>
                                         ))}
                                     </select>
                                 </div>
@@ -135,7 +142,7 @@ export function OperativeList() {
                                         <Badge variant={sandboxStatuses[op.id] === 'running' ? 'default' : 'secondary'}>
                                             {sandboxStatuses[op.id] || 'unknown'}
                                         </Badge>
-                                        <Badge variant="outline">{op.model}</Badge>
+                                        <Badge variant="outline">{op.provider ? `${op.provider}/${op.model}` : op.model}</Badge>
                                         <Button
                                             variant="ghost"
                                             size="sm"